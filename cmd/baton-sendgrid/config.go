@@ -1,6 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"net/url"
+	"time"
+
 	"github.com/conductorone/baton-sdk/pkg/field"
 	"github.com/spf13/viper"
 )
@@ -8,10 +12,19 @@ import (
 var (
 	SendGridApiKeyField = field.StringField(
 		"sendgrid-api-key",
-		field.WithRequired(true),
 		field.WithDescription("API key for SendGrid service."),
 	)
 
+	SendGridApiKeyFileField = field.StringField(
+		"sendgrid-api-key-file",
+		field.WithDescription("Path of a file holding the API key for SendGrid service, reloaded when SendGrid rejects the key so rotated keys are picked up."),
+	)
+
+	SendGridApiKeysField = field.StringSliceField(
+		"sendgrid-api-keys",
+		field.WithDescription("API keys of additional SendGrid accounts, each optionally prefixed with its region ex: eu:SG.xxx."),
+	)
+
 	SendGridRegionField = field.StringField(
 		"sendgrid-region",
 		field.WithRequired(false),
@@ -19,11 +32,59 @@ var (
 		field.WithDescription("Region for SendGrid service ex: global or eu."),
 	)
 
+	SendGridBaseUrlField = field.StringField(
+		"sendgrid-base-url",
+		field.WithDescription("Base URL overriding the regional SendGrid API URL, ex: a corporate egress proxy or a local SendGrid stand-in."),
+	)
+
 	IgnoreSubusers = field.BoolField(
 		"ignore-subusers",
 		field.WithDefaultValue(false),
 		field.WithDescription("Ignore subusers in the SendGrid account, subusers are an upgraded feature of sendgrid."),
 	)
+
+	DryRunField = field.BoolField(
+		"dry-run",
+		field.WithDefaultValue(false),
+		field.WithDescription("Log the requests provisioning would send to SendGrid instead of sending them."),
+	)
+
+	AuditLogFileField = field.StringField(
+		"audit-log-file",
+		field.WithDescription("Path of a JSONL file every request changing SendGrid is recorded in."),
+	)
+
+	HttpRecordFileField = field.StringField(
+		"http-record-file",
+		field.WithDescription("Path of a JSONL file the SendGrid requests and responses are recorded in, for support cases."),
+	)
+
+	HttpRecordRedactField = field.BoolField(
+		"http-record-redact",
+		field.WithDefaultValue(true),
+		field.WithDescription("Leave the Authorization header, passwords, API keys, tokens and email addresses out of the HTTP recording."),
+	)
+
+	HttpReplayFileField = field.StringField(
+		"http-replay-file",
+		field.WithDescription("Path of an HTTP recording the SendGrid responses are served from instead of the network."),
+	)
+
+	TeammateCacheFileField = field.StringField(
+		"teammate-cache-file",
+		field.WithDescription("Path of a file the scopes of the teammates are kept in between syncs, only refetching the teammates which changed."),
+	)
+
+	TeammateCacheTTLField = field.StringField(
+		"teammate-cache-ttl",
+		field.WithDefaultValue("24h"),
+		field.WithDescription("How long the scopes of an unchanged teammate are reused from the teammate cache ex: 30m or 24h."),
+	)
+
+	MetricsFileField = field.StringField(
+		"metrics-file",
+		field.WithDescription("Path of a JSONL file the metrics of the connector and of its SendGrid requests are exported to every minute and on exit."),
+	)
 )
 
 var (
@@ -32,15 +93,30 @@ var (
 	// required.
 	ConfigurationFields = []field.SchemaField{
 		SendGridApiKeyField,
+		SendGridApiKeyFileField,
+		SendGridApiKeysField,
 		SendGridRegionField,
+		SendGridBaseUrlField,
 		IgnoreSubusers,
+		DryRunField,
+		AuditLogFileField,
+		HttpRecordFileField,
+		HttpRecordRedactField,
+		HttpReplayFileField,
+		TeammateCacheFileField,
+		TeammateCacheTTLField,
+		MetricsFileField,
 	}
 
 	// FieldRelationships defines relationships between the fields listed in
 	// ConfigurationFields that can be automatically validated. For example, a
 	// username and password can be required together, or an access token can be
 	// marked as mutually exclusive from the username password pair.
-	FieldRelationships = []field.SchemaFieldRelationship{}
+	FieldRelationships = []field.SchemaFieldRelationship{
+		field.FieldsAtLeastOneUsed(SendGridApiKeyField, SendGridApiKeyFileField, SendGridApiKeysField),
+		field.FieldsMutuallyExclusive(SendGridApiKeyField, SendGridApiKeyFileField),
+		field.FieldsMutuallyExclusive(HttpRecordFileField, HttpReplayFileField),
+	}
 )
 
 // ValidateConfig is run after the configuration is loaded, and should return an
@@ -48,5 +124,47 @@ var (
 // needs to perform extra validations that cannot be encoded with configuration
 // parameters.
 func ValidateConfig(v *viper.Viper) error {
+	region := v.GetString(SendGridRegionField.GetName())
+	if err := validateRegion(region); err != nil {
+		return err
+	}
+
+	for _, entry := range v.GetStringSlice(SendGridApiKeysField.GetName()) {
+		if err := validateRegion(parseAccountKey(entry, region).region); err != nil {
+			return err
+		}
+	}
+
+	if ttl := v.GetString(TeammateCacheTTLField.GetName()); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", TeammateCacheTTLField.GetName(), err)
+		}
+
+		if d <= 0 {
+			return fmt.Errorf("invalid %s %q: expected a positive duration", TeammateCacheTTLField.GetName(), ttl)
+		}
+	}
+
+	if baseUrl := v.GetString(SendGridBaseUrlField.GetName()); baseUrl != "" {
+		u, err := url.Parse(baseUrl)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", SendGridBaseUrlField.GetName(), err)
+		}
+
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid %s %q: expected an absolute http or https URL", SendGridBaseUrlField.GetName(), baseUrl)
+		}
+	}
+
 	return nil
 }
+
+func validateRegion(region string) error {
+	switch region {
+	case "", "global", "eu":
+		return nil
+	default:
+		return fmt.Errorf("invalid %s %q: expected global or eu", SendGridRegionField.GetName(), region)
+	}
+}
@@ -14,7 +14,113 @@ func TestConfigs(t *testing.T) {
 	)
 
 	testCases := []test.TestCase{
-		// Add test cases here.
+		{
+			Configs: map[string]string{},
+			IsValid: false,
+			Message: "missing api key",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName: "SG.key",
+			},
+			IsValid: true,
+			Message: "single api key",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeysField.FieldName: "eu:SG.key",
+			},
+			IsValid: true,
+			Message: "api key list",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyFileField.FieldName: "/run/secrets/sendgrid",
+			},
+			IsValid: true,
+			Message: "api key file",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName:     "SG.key",
+				SendGridApiKeyFileField.FieldName: "/run/secrets/sendgrid",
+			},
+			IsValid: false,
+			Message: "api key and api key file",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName: "SG.key",
+				SendGridRegionField.FieldName: "us",
+			},
+			IsValid: false,
+			Message: "invalid region",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeysField.FieldName: "apac:SG.key",
+			},
+			IsValid: false,
+			Message: "invalid api key region",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName:  "SG.key",
+				SendGridBaseUrlField.FieldName: "http://localhost:8080/",
+			},
+			IsValid: true,
+			Message: "custom base url",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName:  "SG.key",
+				SendGridBaseUrlField.FieldName: "localhost:8080",
+			},
+			IsValid: false,
+			Message: "relative base url",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName: "SG.key",
+				HttpReplayFileField.FieldName: "sync.jsonl",
+			},
+			IsValid: true,
+			Message: "http replay",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName: "SG.key",
+				HttpRecordFileField.FieldName: "sync.jsonl",
+				HttpReplayFileField.FieldName: "sync.jsonl",
+			},
+			IsValid: false,
+			Message: "http recording and replay",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName:    "SG.key",
+				TeammateCacheFileField.FieldName: "teammates.json",
+				TeammateCacheTTLField.FieldName:  "6h",
+			},
+			IsValid: true,
+			Message: "teammate cache",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName:   "SG.key",
+				TeammateCacheTTLField.FieldName: "a day",
+			},
+			IsValid: false,
+			Message: "invalid teammate cache ttl",
+		},
+		{
+			Configs: map[string]string{
+				SendGridApiKeyField.FieldName:   "SG.key",
+				TeammateCacheTTLField.FieldName: "-1h",
+			},
+			IsValid: false,
+			Message: "negative teammate cache ttl",
+		},
 	}
 
 	test.ExerciseTestCases(t, configurationSchema, ValidateConfig, testCases)
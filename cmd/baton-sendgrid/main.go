@@ -3,7 +3,11 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/conductorone/baton-sdk/pkg/config"
 	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
@@ -18,6 +22,12 @@ import (
 
 var version = "dev"
 
+const defaultTeammateCacheTTL = 24 * time.Hour
+
+// closers are closed once the command is done, flushing what the connector
+// writes to files.
+var closers []io.Closer
+
 func main() {
 	ctx := context.Background()
 
@@ -26,7 +36,8 @@ func main() {
 		"baton-sendgrid",
 		getConnector,
 		field.Configuration{
-			Fields: ConfigurationFields,
+			Fields:      ConfigurationFields,
+			Constraints: FieldRelationships,
 		},
 	)
 	if err != nil {
@@ -37,6 +48,12 @@ func main() {
 	cmd.Version = version
 
 	err = cmd.Execute()
+	for _, c := range closers {
+		if closeErr := c.Close(); closeErr != nil {
+			fmt.Fprintln(os.Stderr, closeErr.Error())
+		}
+	}
+
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
@@ -50,36 +67,159 @@ func getConnector(ctx context.Context, v *viper.Viper) (types.ConnectorServer, e
 	}
 
 	sendGridApyKey := v.GetString(SendGridApiKeyField.GetName())
+	sendGridApiKeyFile := v.GetString(SendGridApiKeyFileField.GetName())
+	sendGridApiKeys := v.GetStringSlice(SendGridApiKeysField.GetName())
 	sendgridRegion := v.GetString(SendGridRegionField.GetName())
+	sendgridBaseUrl := v.GetString(SendGridBaseUrlField.GetName())
 	sendgridIgnoreSubusers := v.GetBool(IgnoreSubusers.GetName())
+	dryRun := v.GetBool(DryRunField.GetName())
+	auditLogFile := v.GetString(AuditLogFileField.GetName())
+	httpRecordFile := v.GetString(HttpRecordFileField.GetName())
+	httpRecordRedact := v.GetBool(HttpRecordRedactField.GetName())
+	httpReplayFile := v.GetString(HttpReplayFileField.GetName())
+	teammateCacheFile := v.GetString(TeammateCacheFileField.GetName())
+	metricsFile := v.GetString(MetricsFileField.GetName())
 
-	var baseUrl string
+	clientOptions := []client.Option{client.WithDryRun(dryRun)}
+	var builderOptions []connectorbuilder.Opt
+	if metricsFile != "" {
+		export, err := openMetricsExport(ctx, metricsFile)
+		if err != nil {
+			l.Error("error opening metrics file", zap.Error(err))
+			return nil, err
+		}
+		closers = append(closers, export)
 
-	switch sendgridRegion {
-	case "eu":
-		baseUrl = client.SendGridEUBaseUrl
-	case "global":
-		baseUrl = client.SendGridBaseUrl
-	default:
-		baseUrl = client.SendGridBaseUrl
-		l.Warn("invalid sendgrid region, using the default global URL", zap.String("region", sendgridRegion))
+		// The client reports its requests next to the metrics of the SDK.
+		clientOptions = append(clientOptions, client.WithMetrics(export.handler))
+		builderOptions = append(builderOptions, connectorbuilder.WithMetricsHandler(export.handler))
 	}
+	if auditLogFile != "" {
+		auditLog, err := client.OpenAuditLog(auditLogFile)
+		if err != nil {
+			l.Error("error opening audit log", zap.Error(err))
+			return nil, err
+		}
+		closers = append(closers, auditLog)
 
-	sendGridCliet, err := client.NewClient(ctx, baseUrl, sendGridApyKey)
-	if err != nil {
-		l.Error("error creating sendgrid client", zap.Error(err))
-		return nil, err
+		clientOptions = append(clientOptions, client.WithAuditLog(auditLog))
+	}
+
+	if httpRecordFile != "" {
+		recording, err := client.OpenRecording(httpRecordFile, httpRecordRedact)
+		if err != nil {
+			l.Error("error opening http recording", zap.Error(err))
+			return nil, err
+		}
+		closers = append(closers, recording)
+
+		clientOptions = append(clientOptions, client.WithRecording(recording))
+	}
+
+	if httpReplayFile != "" {
+		replay, err := client.LoadReplay(httpReplayFile)
+		if err != nil {
+			l.Error("error loading http replay", zap.Error(err))
+			return nil, err
+		}
+
+		clientOptions = append(clientOptions, client.WithReplay(replay))
+	}
+
+	var accountKeys []accountKey
+	if sendGridApyKey != "" {
+		accountKeys = append(accountKeys, accountKey{apiKey: sendGridApyKey, region: sendgridRegion})
+	}
+
+	if sendGridApiKeyFile != "" {
+		apiKey, err := client.ReadApiKeyFile(sendGridApiKeyFile)
+		if err != nil {
+			l.Error("error reading sendgrid api key file", zap.Error(err))
+			return nil, err
+		}
+
+		accountKeys = append(accountKeys, accountKey{apiKey: apiKey, apiKeyFile: sendGridApiKeyFile, region: sendgridRegion})
+	}
+
+	for _, entry := range sendGridApiKeys {
+		accountKeys = append(accountKeys, parseAccountKey(entry, sendgridRegion))
 	}
 
-	cb, err := connector.New(ctx, sendGridCliet, sendgridIgnoreSubusers)
+	clients := make([]connector.SendGridClient, 0, len(accountKeys))
+	for _, key := range accountKeys {
+		baseUrl := sendgridBaseUrl
+		if baseUrl == "" {
+			baseUrl = regionBaseUrl(key.region)
+		}
+
+		options := clientOptions
+		if key.apiKeyFile != "" {
+			options = append(slices.Clone(clientOptions), client.WithApiKeyFile(key.apiKeyFile))
+		}
+
+		sendGridCliet, err := client.NewClient(ctx, baseUrl, key.apiKey, options...)
+		if err != nil {
+			l.Error("error creating sendgrid client", zap.Error(err))
+			return nil, err
+		}
+
+		clients = append(clients, sendGridCliet)
+	}
+
+	connectorOptions := []connector.Option{connector.WithDryRun(dryRun)}
+	if teammateCacheFile != "" {
+		// ValidateConfig checked the TTL, which defaults when unset.
+		ttl, err := time.ParseDuration(v.GetString(TeammateCacheTTLField.GetName()))
+		if err != nil {
+			ttl = defaultTeammateCacheTTL
+		}
+
+		teammateCache, err := connector.OpenTeammateCache(teammateCacheFile, ttl)
+		if err != nil {
+			l.Error("error opening teammate cache", zap.Error(err))
+			return nil, err
+		}
+
+		connectorOptions = append(connectorOptions, connector.WithTeammateCache(teammateCache))
+	}
+
+	cb, err := connector.New(ctx, clients, sendgridIgnoreSubusers, connectorOptions...)
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
 	}
-	connector, err := connectorbuilder.NewConnector(ctx, cb)
+	connector, err := connectorbuilder.NewConnector(ctx, cb, builderOptions...)
 	if err != nil {
 		l.Error("error creating connector", zap.Error(err))
 		return nil, err
 	}
 	return connector, nil
 }
+
+// accountKey is the API key and region of a SendGrid account, and the file
+// the key is reloaded from if it was read from one.
+type accountKey struct {
+	apiKey     string
+	apiKeyFile string
+	region     string
+}
+
+// parseAccountKey parses a sendgrid-api-keys entry, which is an API key
+// optionally prefixed with the region of its account ex: eu:SG.xxx.
+func parseAccountKey(entry string, defaultRegion string) accountKey {
+	region, apiKey, ok := strings.Cut(entry, ":")
+	if !ok {
+		return accountKey{apiKey: entry, region: defaultRegion}
+	}
+
+	return accountKey{apiKey: apiKey, region: region}
+}
+
+// regionBaseUrl returns the API URL of a region validated by ValidateConfig.
+func regionBaseUrl(region string) string {
+	if region == "eu" {
+		return client.SendGridEUBaseUrl
+	}
+
+	return client.SendGridBaseUrl
+}
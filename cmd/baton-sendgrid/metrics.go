@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/conductorone/baton-sdk/pkg/metrics"
+	"go.opentelemetry.io/otel/exporters/stdout/stdoutmetric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// metricsExport exports the metrics reported to its handler to a file, as
+// JSON lines.
+type metricsExport struct {
+	handler  metrics.Handler
+	provider *sdkmetric.MeterProvider
+	file     *os.File
+}
+
+func openMetricsExport(ctx context.Context, path string) (*metricsExport, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := stdoutmetric.New(stdoutmetric.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter)))
+
+	return &metricsExport{
+		handler:  metrics.NewOtelHandler(ctx, provider, "baton-sendgrid"),
+		provider: provider,
+		file:     f,
+	}, nil
+}
+
+// Close exports the metrics not exported yet and closes the file.
+func (m *metricsExport) Close() error {
+	return errors.Join(m.provider.Shutdown(context.Background()), m.file.Close())
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/conductorone/baton-sdk/pkg/metrics"
+)
+
+func TestMetricsExport(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.jsonl")
+
+	export, err := openMetricsExport(ctx, path)
+	if err != nil {
+		t.Fatalf("openMetricsExport: %v", err)
+	}
+
+	counter := export.handler.Int64Counter("baton_sendgrid.requests", "requests", metrics.Dimensionless)
+	counter.Add(ctx, 2, map[string]string{"endpoint": "v3/teammates"})
+
+	// The metrics are exported on close, before the periodic export.
+	err = export.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(content), `"baton_sendgrid.requests"`) || !strings.Contains(string(content), `"v3/teammates"`) {
+		t.Errorf("expected the counter in the export, got %s", content)
+	}
+}
@@ -0,0 +1,535 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+)
+
+const (
+	ownerEntitlement = "owner"
+)
+
+// account is a SendGrid parent account synced by the connector.
+type account struct {
+	id             string
+	username       string
+	client         SendGridClient
+	scopeCache     *scopeCache
+	teammateScopes *teammateScopeWriter
+
+	subuserAccess *subuserAccessCache
+	ipAddresses   *ipAddressCache
+	subuserIPs    *subuserIPWriter
+	brandedLinks  *brandedLinkCache
+	subuserStats  *subuserStatsCache
+	credits       *subuserCreditsCache
+
+	subuserNamesMtx sync.Mutex
+	subuserNames    map[int]string
+	subuserIDs      map[string]int
+
+	// knownTeammates holds the usernames of the teammates known to exist,
+	// listed or looked up by the current sync.
+	knownTeammatesMtx sync.Mutex
+	knownTeammates    map[string]bool
+}
+
+// rememberTeammate records a teammate known to exist.
+func (a *account) rememberTeammate(username string) {
+	a.knownTeammatesMtx.Lock()
+	defer a.knownTeammatesMtx.Unlock()
+
+	a.knownTeammates[username] = true
+}
+
+// forgetTeammates drops the teammates known so far, before they are listed
+// again.
+func (a *account) forgetTeammates() {
+	a.knownTeammatesMtx.Lock()
+	defer a.knownTeammatesMtx.Unlock()
+
+	a.knownTeammates = make(map[string]bool)
+}
+
+// teammateExists reports whether the teammate exists in the account, looking
+// it up if it was not listed yet, as when grants are synced apart from the
+// teammates.
+func (a *account) teammateExists(ctx context.Context, username string) (bool, error) {
+	a.knownTeammatesMtx.Lock()
+	known := a.knownTeammates[username]
+	a.knownTeammatesMtx.Unlock()
+
+	if known {
+		return true, nil
+	}
+
+	_, err := a.client.GetSpecificTeammate(ctx, username)
+	if err != nil {
+		if errors.Is(err, client.ErrNotFound) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	a.rememberTeammate(username)
+
+	return true, nil
+}
+
+// rememberSubuser records the username of a listed subuser, which requests
+// on behalf of the subuser are addressed with.
+func (a *account) rememberSubuser(subuser models.Subuser) {
+	a.subuserNamesMtx.Lock()
+	defer a.subuserNamesMtx.Unlock()
+
+	a.subuserNames[subuser.Id] = subuser.Username
+	a.subuserIDs[subuser.Username] = subuser.Id
+}
+
+// subuserUsername returns the username of the subuser with the given ID,
+// looking it up in the subuser list if the subuser was not listed yet.
+func (a *account) subuserUsername(ctx context.Context, id int) (string, error) {
+	a.subuserNamesMtx.Lock()
+	username, ok := a.subuserNames[id]
+	a.subuserNamesMtx.Unlock()
+
+	if ok {
+		return username, nil
+	}
+
+	subuser, err := a.findSubuser(ctx, func(subuser models.Subuser) bool {
+		return subuser.Id == id
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if subuser == nil {
+		return "", fmt.Errorf("baton-sendgrid: subuser %d not found in account %s", id, a.id)
+	}
+
+	return subuser.Username, nil
+}
+
+// knownSubuserID returns the ID of the subuser with the given username,
+// looking it up in the subuser list if the subuser was not listed yet, and
+// whether the subuser was found at all.
+func (a *account) knownSubuserID(ctx context.Context, username string) (int, bool, error) {
+	a.subuserNamesMtx.Lock()
+	id, ok := a.subuserIDs[username]
+	a.subuserNamesMtx.Unlock()
+
+	if ok {
+		return id, true, nil
+	}
+
+	subuser, err := a.findSubuser(ctx, func(subuser models.Subuser) bool {
+		return subuser.Username == username
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	if subuser == nil {
+		return 0, false, nil
+	}
+
+	return subuser.Id, true, nil
+}
+
+// findSubuser pages through the subusers, remembering them, until one
+// matches.
+func (a *account) findSubuser(ctx context.Context, match func(models.Subuser) bool) (*models.Subuser, error) {
+	pToken := "0"
+	for pToken != "" {
+		var (
+			subusers []models.Subuser
+			err      error
+		)
+
+		subusers, pToken, err = a.client.GetSubusers(ctx, &pagination.Token{Token: pToken})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(subusers) == 0 {
+			break
+		}
+
+		for _, subuser := range subusers {
+			a.rememberSubuser(subuser)
+
+			if match(subuser) {
+				return &subuser, nil
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// subuserFromResourceID returns the ID and the username of the subuser
+// with the given resource ID.
+func (a *account) subuserFromResourceID(ctx context.Context, subuserResourceID *v2.ResourceId) (int, string, error) {
+	_, localID, err := splitAccountScopedID(subuserResourceID.Resource)
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, err := strconv.Atoi(localID)
+	if err != nil {
+		return 0, "", fmt.Errorf("baton-sendgrid: invalid subuser id %q", subuserResourceID.Resource)
+	}
+
+	username, err := a.subuserUsername(ctx, id)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return id, username, nil
+}
+
+// onBehalfOfSubuser returns a context under which requests of the account
+// client act inside the subuser with the given resource ID.
+func (a *account) onBehalfOfSubuser(ctx context.Context, subuserResourceID *v2.ResourceId) (context.Context, error) {
+	_, username, err := a.subuserFromResourceID(ctx, subuserResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.WithOnBehalfOf(ctx, username), nil
+}
+
+// accountSet resolves the SendGrid accounts behind the configured clients.
+// Accounts are identified by their SendGrid user ID, which is looked up the
+// first time an account is needed.
+type accountSet struct {
+	clients   []SendGridClient
+	teammates *TeammateCache
+	dryRun    bool
+
+	mtx      sync.Mutex
+	accounts []*account
+	byID     map[string]*account
+}
+
+func newAccountSet(clients []SendGridClient) *accountSet {
+	return &accountSet{
+		clients: clients,
+	}
+}
+
+func (a *accountSet) load(ctx context.Context) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.byID != nil {
+		return nil
+	}
+
+	accounts := make([]*account, 0, len(a.clients))
+	byID := make(map[string]*account, len(a.clients))
+
+	for _, c := range a.clients {
+		user, err := c.GetUsername(ctx)
+		if err != nil {
+			return err
+		}
+
+		id := strconv.Itoa(user.UserId)
+		if _, ok := byID[id]; ok {
+			return fmt.Errorf("baton-sendgrid: account %s (%s) is configured more than once", user.Username, id)
+		}
+
+		scopeCache := newScopeCache(c, id, a.teammates)
+		ipAddresses := newIPAddressCache(c)
+		acc := &account{
+			id:             id,
+			username:       user.Username,
+			client:         c,
+			scopeCache:     scopeCache,
+			teammateScopes: newTeammateScopeWriter(c, scopeCache, a.dryRun),
+			subuserAccess:  newSubuserAccessCache(c),
+			ipAddresses:    ipAddresses,
+			subuserIPs:     newSubuserIPWriter(c, ipAddresses),
+			brandedLinks:   newBrandedLinkCache(c),
+			subuserStats:   newSubuserStatsCache(c),
+			credits:        newSubuserCreditsCache(c),
+			subuserNames:   make(map[int]string),
+			subuserIDs:     make(map[string]int),
+			knownTeammates: make(map[string]bool),
+		}
+
+		accounts = append(accounts, acc)
+		byID[id] = acc
+	}
+
+	a.accounts = accounts
+	a.byID = byID
+
+	return nil
+}
+
+// all returns every configured account.
+func (a *accountSet) all(ctx context.Context) ([]*account, error) {
+	err := a.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.accounts, nil
+}
+
+// get returns the account with the given ID.
+func (a *accountSet) get(ctx context.Context, id string) (*account, error) {
+	err := a.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, ok := a.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("baton-sendgrid: unknown account %s", id)
+	}
+
+	return acc, nil
+}
+
+// forParent returns the account a child resource is listed under.
+func (a *accountSet) forParent(ctx context.Context, parentResourceID *v2.ResourceId) (*account, error) {
+	if parentResourceID.ResourceType == accountResourceType.Id {
+		return a.get(ctx, parentResourceID.Resource)
+	}
+
+	return a.forResource(ctx, parentResourceID)
+}
+
+// forResource returns the account owning a resource with an account scoped ID.
+func (a *accountSet) forResource(ctx context.Context, resourceID *v2.ResourceId) (*account, error) {
+	accountID, _, err := splitAccountScopedID(resourceID.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.get(ctx, accountID)
+}
+
+// forTeammateGrant resolves the account, the teammate username and the local
+// ID of the entitlement resource for a grant of an account resource to a
+// teammate, which have to belong to the same account.
+func (a *accountSet) forTeammateGrant(ctx context.Context, principalID *v2.ResourceId, resourceID *v2.ResourceId) (*account, string, string, error) {
+	if principalID.ResourceType != teammateResourceType.Id {
+		return nil, "", "", fmt.Errorf("baton-sendgrid: principal resource type is not %s", teammateResourceType.Id)
+	}
+
+	principalAccountID, username, err := splitAccountScopedID(principalID.Resource)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if _, _, ok := splitSubuserScopedID(username); ok {
+		return nil, "", "", fmt.Errorf("baton-sendgrid: teammate %s belongs to a subuser", principalID.Resource)
+	}
+
+	accountID, localID, err := splitAccountScopedID(resourceID.Resource)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if principalAccountID != accountID {
+		return nil, "", "", fmt.Errorf("baton-sendgrid: teammate %s does not belong to account %s", username, accountID)
+	}
+
+	acc, err := a.get(ctx, accountID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return acc, username, localID, nil
+}
+
+// forAccountGrant resolves the account, the local ID of the principal and
+// the local ID of the entitlement resource for a grant of an account
+// resource to a principal of the given type, which have to belong to the
+// same account.
+func (a *accountSet) forAccountGrant(
+	ctx context.Context,
+	principalType *v2.ResourceType,
+	principalID *v2.ResourceId,
+	resourceID *v2.ResourceId,
+) (*account, string, string, error) {
+	if principalID.ResourceType != principalType.Id {
+		return nil, "", "", fmt.Errorf("baton-sendgrid: principal resource type is not %s", principalType.Id)
+	}
+
+	principalAccountID, principalLocalID, err := splitAccountScopedID(principalID.Resource)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	accountID, localID, err := splitAccountScopedID(resourceID.Resource)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	if principalAccountID != accountID {
+		return nil, "", "", fmt.Errorf("baton-sendgrid: %s %s does not belong to account %s", principalType.Id, principalID.Resource, accountID)
+	}
+
+	acc, err := a.get(ctx, accountID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return acc, principalLocalID, localID, nil
+}
+
+// forSubuserGrant resolves the account, the subuser username and the local
+// ID of the entitlement resource for a grant of an account resource to a
+// subuser, which have to belong to the same account.
+func (a *accountSet) forSubuserGrant(ctx context.Context, principalID *v2.ResourceId, resourceID *v2.ResourceId) (*account, string, string, error) {
+	acc, _, localID, err := a.forAccountGrant(ctx, subuserResourceType, principalID, resourceID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	_, username, err := acc.subuserFromResourceID(ctx, principalID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	return acc, username, localID, nil
+}
+
+// forCreate returns the account a resource is created in, which is its
+// parent, or the only configured account when the resource has no parent.
+func (a *accountSet) forCreate(ctx context.Context, resource *v2.Resource) (*account, error) {
+	if resource.ParentResourceId != nil {
+		return a.forParent(ctx, resource.ParentResourceId)
+	}
+
+	accounts, err := a.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(accounts) != 1 {
+		return nil, fmt.Errorf("baton-sendgrid: the parent account of the %s is required", resource.Id.GetResourceType())
+	}
+
+	return accounts[0], nil
+}
+
+func getAccountDetails(ctx context.Context, acc *account) (*models.AccountDetails, error) {
+	username, err := acc.client.GetUsername(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userAccount, err := acc.client.GetUserAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	profile, err := acc.client.GetUserProfile(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	email, err := acc.client.GetUserEmail(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AccountDetails{
+		Username: *username,
+		Account:  *userAccount,
+		Profile:  *profile,
+		Email:    *email,
+	}, nil
+}
+
+type accountBuilder struct {
+	resourceType *v2.ResourceType
+	accounts     *accountSet
+}
+
+func (r *accountBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return accountResourceType
+}
+
+func (r *accountBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	accounts, err := r.accounts.all(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rv := make([]*v2.Resource, len(accounts))
+	for i, acc := range accounts {
+		details, err := getAccountDetails(ctx, acc)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rb, err := accountResource(ctx, details)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv[i] = rb
+	}
+
+	return rv, "", nil, nil
+}
+
+func (r *accountBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+	ownerOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(teammateResourceType),
+		ent.WithDescription(fmt.Sprintf("Owner %s of the account", teammateResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s owner", resource.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, ownerEntitlement, ownerOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (r *accountBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	acc, err := r.accounts.get(ctx, resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// The account username is the login of the owner teammate.
+	ownerId, err := rs.NewResourceID(teammateResourceType, newAccountScopedID(acc.id, acc.username))
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rv := []*v2.Grant{
+		grant.NewGrant(resource, ownerEntitlement, ownerId),
+	}
+
+	return rv, "", nil, nil
+}
+
+func newAccountBuilder(accounts *accountSet) *accountBuilder {
+	return &accountBuilder{
+		resourceType: accountResourceType,
+		accounts:     accounts,
+	}
+}
@@ -0,0 +1,68 @@
+package connector
+
+import (
+	"context"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+)
+
+type apiKeyBuilder struct {
+	resourceType *v2.ResourceType
+	accounts     *accountSet
+}
+
+func newApiKeyBuilder(accounts *accountSet) *apiKeyBuilder {
+	return &apiKeyBuilder{
+		resourceType: apiKeyResourceType,
+		accounts:     accounts,
+	}
+}
+
+func (r *apiKeyBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return apiKeyResourceType
+}
+
+// List returns the API keys of a subuser, which are only reachable on behalf of the subuser.
+func (r *apiKeyBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil || parentResourceID.ResourceType != subuserResourceType.Id {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	ctx, err = acc.onBehalfOfSubuser(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	apiKeys, nextToken, err := acc.client.GetApiKeys(ctx, pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, apiKey := range apiKeys {
+		rb, err := apiKeyResource(ctx, apiKey, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, rb)
+	}
+
+	return rv, nextToken, nil, nil
+}
+
+func (r *apiKeyBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func (r *apiKeyBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
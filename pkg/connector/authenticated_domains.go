@@ -0,0 +1,228 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	senderEntitlement = "sender"
+)
+
+type authenticatedDomainBuilder struct {
+	resourceType   *v2.ResourceType
+	accounts       *accountSet
+	ignoreSubusers bool
+}
+
+func newAuthenticatedDomainBuilder(accounts *accountSet, ignoreSubusers bool) *authenticatedDomainBuilder {
+	return &authenticatedDomainBuilder{
+		resourceType:   authenticatedDomainResourceType,
+		accounts:       accounts,
+		ignoreSubusers: ignoreSubusers,
+	}
+}
+
+func (r *authenticatedDomainBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return authenticatedDomainResourceType
+}
+
+func (r *authenticatedDomainBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	domains, pNextToken, err := acc.client.GetAuthenticatedDomains(ctx, pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, domain := range domains {
+		rb, err := authenticatedDomainResource(ctx, domain, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, rb)
+	}
+
+	return rv, pNextToken, nil, nil
+}
+
+func (r *authenticatedDomainBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+	senderOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(subuserResourceType),
+		ent.WithDescription(fmt.Sprintf("%s sending as the %s", subuserResourceType.DisplayName, authenticatedDomainResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s sender", resource.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, senderEntitlement, senderOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (r *authenticatedDomainBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	var rv []*v2.Grant
+
+	// The grants go to subusers, which are not synced when ignored.
+	if r.ignoreSubusers {
+		return rv, "", nil, nil
+	}
+
+	accountID, localID, err := splitAccountScopedID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	domainID, err := parseSenderDomainID(authenticatedDomainResourceType, localID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	acc, err := r.accounts.get(ctx, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	domain, err := acc.client.GetAuthenticatedDomain(ctx, domainID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, subuser := range domain.Subusers {
+		subuserResourceID, err := rs.NewResourceID(subuserResourceType, newAccountScopedID(accountID, subuser.UserId))
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, grant.NewGrant(resource, senderEntitlement, subuserResourceID))
+	}
+
+	return rv, "", nil, nil
+}
+
+// ResourceProvisioner
+
+func (r *authenticatedDomainBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, domainID, err := r.forSubuserGrant(ctx, principal.Id, entitlement.Resource.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	associated, err := domainAssociated(ctx, acc, domainID, username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if associated {
+		l.Info(
+			"baton-sendgrid: authenticated domain already associated with subuser",
+			zap.Int("domain_id", domainID),
+			zap.String("subuser", username),
+		)
+
+		return nil, annotations.New(&v2.GrantAlreadyExists{}), nil
+	}
+
+	err = acc.client.AddAuthenticatedDomainToSubuser(ctx, domainID, username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, senderEntitlement, principal.Id)}, nil, nil
+}
+
+func (r *authenticatedDomainBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, domainID, err := r.forSubuserGrant(ctx, grant.Principal.Id, grant.Entitlement.Resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	associated, err := domainAssociated(ctx, acc, domainID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	if !associated {
+		l.Info(
+			"baton-sendgrid: authenticated domain not associated with subuser",
+			zap.Int("domain_id", domainID),
+			zap.String("subuser", username),
+		)
+
+		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+	}
+
+	err = acc.client.RemoveAuthenticatedDomainFromSubuser(ctx, domainID, username)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// forSubuserGrant resolves the account, the subuser username and the
+// SendGrid ID of the authenticated domain of a sender grant.
+func (r *authenticatedDomainBuilder) forSubuserGrant(ctx context.Context, principalID *v2.ResourceId, resourceID *v2.ResourceId) (*account, string, int, error) {
+	acc, username, localID, err := r.accounts.forSubuserGrant(ctx, principalID, resourceID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	domainID, err := parseSenderDomainID(authenticatedDomainResourceType, localID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return acc, username, domainID, nil
+}
+
+// domainAssociated reports whether the authenticated domain is associated
+// with the subuser, read past the response cache which would miss the
+// changes made since.
+func domainAssociated(ctx context.Context, acc *account, domainID int, username string) (bool, error) {
+	domain, err := acc.client.GetAuthenticatedDomain(client.WithoutCache(ctx), domainID)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(domain.Subusers, func(subuser models.DomainSubuser) bool {
+		return subuser.Username == username
+	}), nil
+}
+
+// parseSenderDomainID parses the SendGrid ID of an authenticated domain or a
+// branded link, which are numeric.
+func parseSenderDomainID(resourceType *v2.ResourceType, localID string) (int, error) {
+	id, err := strconv.Atoi(localID)
+	if err != nil {
+		return 0, fmt.Errorf("baton-sendgrid: invalid %s id %q", resourceType.Id, localID)
+	}
+
+	return id, nil
+}
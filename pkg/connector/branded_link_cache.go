@@ -0,0 +1,108 @@
+package connector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// brandedLinkCache maps the branded links of an account to the subusers they
+// are associated with. SendGrid only exposes the branded link of a subuser,
+// so it is collected once for every subuser. A subuser whose branded link
+// cannot be read is left out, the others keeping their grants.
+type brandedLinkCache struct {
+	client SendGridClient
+
+	mtx            sync.Mutex
+	built          bool
+	linkToSubusers map[int][]int
+}
+
+func newBrandedLinkCache(gridClient SendGridClient) *brandedLinkCache {
+	return &brandedLinkCache{
+		client: gridClient,
+	}
+}
+
+func (c *brandedLinkCache) buildCache(ctx context.Context) error {
+	l := ctxzap.Extract(ctx)
+
+	l.Info("Building cache for branded links")
+
+	linkToSubusers := make(map[int][]int)
+
+	pToken := "0"
+	for pToken != "" {
+		var (
+			subusers []models.Subuser
+			err      error
+		)
+
+		subusers, pToken, err = c.client.GetSubusers(ctx, &pagination.Token{Token: pToken})
+		if err != nil {
+			return err
+		}
+
+		if len(subusers) == 0 {
+			break
+		}
+
+		for _, subuser := range subusers {
+			link, err := c.client.GetSubuserBrandedLink(ctx, subuser.Username)
+			if err != nil {
+				if ctx.Err() != nil {
+					return err
+				}
+
+				l.Warn(
+					"baton-sendgrid: failed to get the branded link of subuser",
+					zap.String("subuser", subuser.Username),
+					zap.Error(err),
+				)
+				continue
+			}
+
+			if link != nil {
+				linkToSubusers[link.Id] = append(linkToSubusers[link.Id], subuser.Id)
+			}
+		}
+	}
+
+	c.linkToSubusers = linkToSubusers
+	c.built = true
+
+	l.Info("Cache built for branded links")
+
+	return nil
+}
+
+// GetSubusersForLink returns the IDs of the subusers the branded link is
+// associated with.
+func (c *brandedLinkCache) GetSubusersForLink(ctx context.Context, linkID int) ([]int, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.built {
+		err := c.buildCache(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.linkToSubusers[linkID], nil
+}
+
+// reset drops the collected associations, so that they are collected again
+// on their next use, after a sync started or an association changed.
+func (c *brandedLinkCache) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.built = false
+	c.linkToSubusers = nil
+}
@@ -0,0 +1,216 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type brandedLinkBuilder struct {
+	resourceType   *v2.ResourceType
+	accounts       *accountSet
+	ignoreSubusers bool
+}
+
+func newBrandedLinkBuilder(accounts *accountSet, ignoreSubusers bool) *brandedLinkBuilder {
+	return &brandedLinkBuilder{
+		resourceType:   brandedLinkResourceType,
+		accounts:       accounts,
+		ignoreSubusers: ignoreSubusers,
+	}
+}
+
+func (r *brandedLinkBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return brandedLinkResourceType
+}
+
+// List returns the branded links of an account, which SendGrid does not
+// paginate.
+func (r *brandedLinkBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Every sync collects the associations of the branded links anew.
+	acc.brandedLinks.reset()
+
+	links, err := acc.client.GetBrandedLinks(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, link := range links {
+		rb, err := brandedLinkResource(ctx, link, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, rb)
+	}
+
+	return rv, "", nil, nil
+}
+
+func (r *brandedLinkBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+	senderOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(subuserResourceType),
+		ent.WithDescription(fmt.Sprintf("%s sending links through the %s", subuserResourceType.DisplayName, brandedLinkResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s sender", resource.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, senderEntitlement, senderOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (r *brandedLinkBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	var rv []*v2.Grant
+
+	// The grants go to subusers, which are not synced when ignored.
+	if r.ignoreSubusers {
+		return rv, "", nil, nil
+	}
+
+	accountID, localID, err := splitAccountScopedID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	linkID, err := parseSenderDomainID(brandedLinkResourceType, localID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	acc, err := r.accounts.get(ctx, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	subusers, err := acc.brandedLinks.GetSubusersForLink(ctx, linkID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, subuserID := range subusers {
+		subuserResourceID, err := rs.NewResourceID(subuserResourceType, newAccountScopedID(accountID, subuserID))
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, grant.NewGrant(resource, senderEntitlement, subuserResourceID))
+	}
+
+	return rv, "", nil, nil
+}
+
+// ResourceProvisioner
+
+// Grant associates the branded link with the subuser. A subuser has at most
+// one branded link, the one it is associated with has to be revoked first.
+func (r *brandedLinkBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, linkID, err := r.forSubuserGrant(ctx, principal.Id, entitlement.Resource.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	link, err := acc.client.GetSubuserBrandedLink(client.WithoutCache(ctx), username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if link != nil && link.Id == linkID {
+		l.Info(
+			"baton-sendgrid: branded link already associated with subuser",
+			zap.Int("link_id", linkID),
+			zap.String("subuser", username),
+		)
+
+		return nil, annotations.New(&v2.GrantAlreadyExists{}), nil
+	}
+
+	if link != nil {
+		return nil, nil, status.Errorf(
+			codes.FailedPrecondition,
+			"baton-sendgrid: subuser %s is already associated with the branded link %s",
+			username,
+			senderDomainName(link.Subdomain, link.Domain),
+		)
+	}
+
+	err = acc.client.AssociateBrandedLinkWithSubuser(ctx, linkID, username)
+	acc.brandedLinks.reset()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, senderEntitlement, principal.Id)}, nil, nil
+}
+
+func (r *brandedLinkBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, linkID, err := r.forSubuserGrant(ctx, grant.Principal.Id, grant.Entitlement.Resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := acc.client.GetSubuserBrandedLink(client.WithoutCache(ctx), username)
+	if err != nil {
+		return nil, err
+	}
+
+	if link == nil || link.Id != linkID {
+		l.Info(
+			"baton-sendgrid: branded link not associated with subuser",
+			zap.Int("link_id", linkID),
+			zap.String("subuser", username),
+		)
+
+		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+	}
+
+	err = acc.client.DisassociateBrandedLinkFromSubuser(ctx, username)
+	acc.brandedLinks.reset()
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// forSubuserGrant resolves the account, the subuser username and the
+// SendGrid ID of the branded link of a sender grant.
+func (r *brandedLinkBuilder) forSubuserGrant(ctx context.Context, principalID *v2.ResourceId, resourceID *v2.ResourceId) (*account, string, int, error) {
+	acc, username, localID, err := r.accounts.forSubuserGrant(ctx, principalID, resourceID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	linkID, err := parseSenderDomainID(brandedLinkResourceType, localID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return acc, username, linkID, nil
+}
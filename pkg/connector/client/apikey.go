@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// ReadApiKeyFile returns the API key stored in the file at path, ignoring
+// the whitespace around it.
+func ReadApiKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	apiKey := strings.TrimSpace(string(data))
+	if apiKey == "" {
+		return "", ErrApiKeyIsEmpty
+	}
+
+	return apiKey, nil
+}
+
+// WithApiKeyFile reloads the API key from the file at path when SendGrid
+// rejects it, so that a rotated key is picked up without a restart.
+func WithApiKeyFile(path string) Option {
+	return func(h *SendGridClient) {
+		h.apiKeyFile = path
+	}
+}
+
+func (h *SendGridClient) getApiKey() string {
+	h.apiKeyMtx.Lock()
+	defer h.apiKeyMtx.Unlock()
+
+	return h.apiKey
+}
+
+// reloadApiKey reloads the API key from its file after SendGrid rejected the
+// key, reporting whether there is a new key to retry with.
+func (h *SendGridClient) reloadApiKey(ctx context.Context, rejected string) bool {
+	if h.apiKeyFile == "" {
+		return false
+	}
+
+	h.apiKeyMtx.Lock()
+	defer h.apiKeyMtx.Unlock()
+
+	// Another request already reloaded the key.
+	if h.apiKey != rejected {
+		return true
+	}
+
+	l := ctxzap.Extract(ctx)
+
+	apiKey, err := ReadApiKeyFile(h.apiKeyFile)
+	if err != nil {
+		l.Warn("baton-sendgrid: failed to reload the api key", zap.String("path", h.apiKeyFile), zap.Error(err))
+		return false
+	}
+
+	if apiKey == rejected {
+		return false
+	}
+
+	l.Info("baton-sendgrid: reloaded the rotated api key", zap.String("path", h.apiKeyFile))
+	h.apiKey = apiKey
+
+	return true
+}
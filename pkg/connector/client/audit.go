@@ -0,0 +1,217 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+	AuditResultDryRun  = "dry_run"
+)
+
+// AuditRecord is the audit log entry of a mutating request.
+type AuditRecord struct {
+	Timestamp   time.Time        `json:"timestamp"`
+	Operation   string           `json:"operation"`
+	Method      string           `json:"method"`
+	Endpoint    string           `json:"endpoint"`
+	Target      string           `json:"target"`
+	OnBehalfOf  string           `json:"on_behalf_of,omitempty"`
+	Before      json.RawMessage  `json:"before,omitempty"`
+	After       json.RawMessage  `json:"after,omitempty"`
+	Result      string           `json:"result"`
+	Error       string           `json:"error,omitempty"`
+	ErrorFields []CustomErrField `json:"error_fields,omitempty"`
+}
+
+// AuditLog writes audit records as JSON lines. It is safe to share between
+// the clients of several accounts.
+type AuditLog struct {
+	mtx sync.Mutex
+	w   io.Writer
+	// file is the file opened by OpenAuditLog, closed with the audit log.
+	file io.Closer
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+// OpenAuditLog opens the audit log file at path, appending to it.
+func OpenAuditLog(path string) (*AuditLog, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog := NewAuditLog(f)
+	auditLog.file = f
+
+	return auditLog, nil
+}
+
+// Close closes the file of an audit log opened by OpenAuditLog.
+func (a *AuditLog) Close() error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+
+	return a.file.Close()
+}
+
+func (a *AuditLog) Write(record AuditRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	_, err = a.w.Write(append(line, '\n'))
+
+	return err
+}
+
+// mutation is a mutating request, described for the audit log and dry runs.
+type mutation struct {
+	operation string
+	// target names the changed resource, pseudonymized when it is a secret
+	// such as an invite token.
+	target string
+	method string
+	url    *url.URL
+	body   interface{}
+	// before returns the state of the target prior to the request, it is
+	// only called when the state is recorded.
+	before func(ctx context.Context) (interface{}, error)
+}
+
+// mutate sends a mutating request, or only logs it in dry run mode, and
+// records it in the audit log.
+func (h *SendGridClient) mutate(ctx context.Context, m mutation) error {
+	l := ctxzap.Extract(ctx)
+
+	after, err := redactBody(m.body)
+	if err != nil {
+		return err
+	}
+
+	var before []byte
+	if m.before != nil && (h.dryRun || h.auditLog != nil) {
+		// The state is read past the response cache, which may predate the
+		// last change.
+		// Failing to read it does not hold back the change, it is
+		// recorded without its prior state.
+		before, err = h.readBefore(WithoutCache(ctx), m)
+		if err != nil {
+			l.Warn(
+				"baton-sendgrid: failed to read the state before the change",
+				zap.String("operation", m.operation),
+				zap.String("target", m.target),
+				zap.Error(err),
+			)
+		}
+	}
+
+	record := AuditRecord{
+		Timestamp:  time.Now().UTC(),
+		Operation:  m.operation,
+		Method:     m.method,
+		Endpoint:   "/" + redactPathTokens(h.relativePath(m.url)),
+		Target:     m.target,
+		OnBehalfOf: OnBehalfOf(ctx),
+		Before:     before,
+		After:      after,
+	}
+
+	if h.dryRun {
+		l.Info(
+			"baton-sendgrid: dry run, request not sent",
+			zap.String("operation", m.operation),
+			zap.String("method", m.method),
+			zap.String("endpoint", record.Endpoint),
+			zap.String("on_behalf_of", record.OnBehalfOf),
+			zap.ByteString("before", before),
+			zap.ByteString("body", after),
+		)
+
+		record.Result = AuditResultDryRun
+		h.audit(ctx, record)
+
+		return nil
+	}
+
+	reqErr := h.doRequest(ctx, m.method, m.url, nil, m.body)
+	if reqErr != nil {
+		record.Result = AuditResultFailure
+		record.Error = reqErr.Error()
+		record.ErrorFields = errorFields(reqErr)
+	} else {
+		record.Result = AuditResultSuccess
+	}
+
+	h.audit(ctx, record)
+
+	return reqErr
+}
+
+// readBefore returns the redacted state of the target of the mutation prior
+// to the request.
+func (h *SendGridClient) readBefore(ctx context.Context, m mutation) ([]byte, error) {
+	state, err := m.before(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return redactBody(state)
+}
+
+func (h *SendGridClient) audit(ctx context.Context, record AuditRecord) {
+	if h.auditLog == nil {
+		return
+	}
+
+	// A failing audit log does not undo the change, it is reported instead.
+	if err := h.auditLog.Write(record); err != nil {
+		ctxzap.Extract(ctx).Error(
+			"baton-sendgrid: failed to write audit record",
+			zap.String("operation", record.Operation),
+			zap.String("target", record.Target),
+			zap.Error(err),
+		)
+	}
+}
+
+// errorFields returns the SendGrid error fields carried by err.
+func errorFields(err error) []CustomErrField {
+	var field CustomErrField
+	if errors.As(err, &field) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok { //nolint:errorlint // walking the joined errors
+			var rv []CustomErrField
+			for _, e := range joined.Unwrap() {
+				rv = append(rv, errorFields(e)...)
+			}
+
+			return rv
+		}
+
+		return []CustomErrField{field}
+	}
+
+	return nil
+}
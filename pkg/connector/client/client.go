@@ -1,6 +1,7 @@
 package client
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,35 +9,73 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
+	"sync"
 
+	"github.com/conductorone/baton-sdk/pkg/metrics"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/conductorone/baton-sdk/pkg/uhttp"
 	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"google.golang.org/grpc/codes"
 )
 
 var (
 	ErrApiKeyIsEmpty          = errors.New("baton-sendgrid: api key is empty")
 	ErrInvalidPaginationToken = errors.New("baton-sendgrid: invalid pagination token")
+	ErrNotFound               = errors.New("baton-sendgrid: not found")
+	ErrUnauthorized           = errors.New("unauthorized")
 )
 
 var (
 	SendGridBaseUrl   = "https://api.sendgrid.com/"
 	SendGridEUBaseUrl = "https://api.eu.sendgrid.com/"
 	AuthHeaderName    = "Authorization"
+	OnBehalfOfHeader  = "on-behalf-of"
 
 	RetrieveAllTeammatesEndpoint     = "v3/teammates"
 	InviteTeammateEndpoint           = "v3/teammates"
 	DeleteTeammateEndpoint           = "v3/teammates"
 	SpecificTeammateEndpoint         = "v3/teammates/%s"
 	PendingTeammateEndpoint          = "v3/teammates/pending"
+	SpecificPendingTeammateEndpoint  = "v3/teammates/pending/%s"
+	ResendTeammateInviteEndpoint     = "v3/teammates/pending/%s/resend"
 	TeammateSubuserAccessEndpoint    = "v3/teammates/%s/subuser_access"
 	TeammateUpdatePermissionEndpoint = "/v3/teammates/%s"
 
+	UserUsernameEndpoint = "v3/user/username"
+	UserAccountEndpoint  = "v3/user/account"
+	UserProfileEndpoint  = "v3/user/profile"
+	UserEmailEndpoint    = "v3/user/email"
+
+	ApiKeysEndpoint = "v3/api_keys"
+
 	SubusersEndpoint              = "v3/subusers"
 	SpecificSubusersEndpoint      = "v3/subusers/%s"
 	SubusersWebsiteAccessEndpoint = "v3/subusers/%s/website_access"
+	SubuserIPsEndpoint            = "v3/subusers/%s/ips"
+	SubuserReputationsEndpoint    = "v3/subusers/reputations"
+	SubuserCreditsEndpoint        = "v3/subusers/%s/credits"
+	SubuserMonthlyStatsEndpoint   = "v3/subusers/stats/monthly"
+
+	IPsEndpoint            = "v3/ips"
+	AssignedIPsEndpoint    = "v3/ips/assigned"
+	IPPoolsEndpoint        = "v3/ips/pools"
+	SpecificIPPoolEndpoint = "v3/ips/pools/%s"
+	IPPoolIPsEndpoint      = "v3/ips/pools/%s/ips"
+	IPPoolIPEndpoint       = "v3/ips/pools/%s/ips/%s"
+
+	AuthenticatedDomainsEndpoint          = "v3/whitelabel/domains"
+	SpecificAuthenticatedDomainEndpoint   = "v3/whitelabel/domains/%d"
+	AuthenticatedDomainSubuserEndpoint    = "v3/whitelabel/domains/%d/subuser"
+	AuthenticatedDomainAddSubuserEndpoint = "v3/whitelabel/domains/%d/subuser:add"
+	BrandedLinksEndpoint                  = "v3/whitelabel/links"
+	BrandedLinkSubuserEndpoint            = "v3/whitelabel/links/subuser"
+	SpecificBrandedLinkSubuserEndpoint    = "v3/whitelabel/links/%d/subuser"
+
+	VerifiedSendersEndpoint        = "v3/verified_senders"
+	SpecificVerifiedSenderEndpoint = "v3/verified_senders/%d"
 )
 
 type CustomErrField struct {
@@ -61,15 +100,102 @@ func (c CustomErr) Error() error {
 	return errors.Join(errorsResult...)
 }
 
+// notFoundError is the error of a request for a missing resource, it matches
+// ErrNotFound while keeping the SendGrid error fields.
+type notFoundError struct {
+	err error
+}
+
+func (e notFoundError) Error() string {
+	if e.err == nil {
+		return ErrNotFound.Error()
+	}
+
+	return e.err.Error()
+}
+
+func (e notFoundError) Unwrap() []error {
+	if e.err == nil {
+		return []error{ErrNotFound}
+	}
+
+	return []error{ErrNotFound, e.err}
+}
+
+type onBehalfOfKey struct{}
+
+// WithOnBehalfOf returns a context under which every request is issued on
+// behalf of the subuser, which is how SendGrid exposes the resources of a
+// subuser to its parent account.
+// https://www.twilio.com/docs/sendgrid/api-reference/how-to-use-the-sendgrid-v3-api/on-behalf-of-subuser
+func WithOnBehalfOf(ctx context.Context, subuser string) context.Context {
+	return context.WithValue(ctx, onBehalfOfKey{}, subuser)
+}
+
+// OnBehalfOf returns the subuser requests are issued on behalf of, if any.
+func OnBehalfOf(ctx context.Context) string {
+	subuser, _ := ctx.Value(onBehalfOfKey{}).(string)
+
+	return subuser
+}
+
+type withoutCacheKey struct{}
+
+// WithoutCache returns a context under which reads skip the response cache,
+// for the reads a write is computed from or checked against, which must see
+// the effect of the writes before them.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutCacheKey{}, true)
+}
+
+func cacheSkipped(ctx context.Context) bool {
+	skip, _ := ctx.Value(withoutCacheKey{}).(bool)
+
+	return skip
+}
+
 // SendGridClient is a client for the SendGrid API.
 type SendGridClient struct {
 	httpClient *uhttp.BaseHttpClient
 	baseUrl    *url.URL
+	apiKeyMtx  sync.Mutex
 	apiKey     string
+	apiKeyFile string
 	pageLimit  int
+	dryRun     bool
+	auditLog   *AuditLog
+	metrics    *clientMetrics
+
+	// accountID is the ID of the account of the API key, known once the
+	// client retrieved it, which recordings key the requests on.
+	accountIDMtx sync.Mutex
+	accountID    string
+
+	// Requests on behalf of a subuser go through their own http client, so
+	// that its response cache never serves one subuser the data of another.
+	rawHttpClient     *http.Client
+	subuserClientsMtx sync.Mutex
+	subuserClients    map[string]*uhttp.BaseHttpClient
+}
+
+// Option configures a SendGridClient.
+type Option func(*SendGridClient)
+
+// WithDryRun makes the client log mutating requests instead of sending them.
+func WithDryRun(dryRun bool) Option {
+	return func(h *SendGridClient) {
+		h.dryRun = dryRun
+	}
+}
+
+// WithAuditLog records every mutating request in the audit log.
+func WithAuditLog(auditLog *AuditLog) Option {
+	return func(h *SendGridClient) {
+		h.auditLog = auditLog
+	}
 }
 
-func NewClient(ctx context.Context, baseUrl, apiKey string) (*SendGridClient, error) {
+func NewClient(ctx context.Context, baseUrl, apiKey string, opts ...Option) (*SendGridClient, error) {
 	parseBaseUrl, err := url.Parse(baseUrl)
 	if err != nil {
 		return nil, err
@@ -89,12 +215,28 @@ func NewClient(ctx context.Context, baseUrl, apiKey string) (*SendGridClient, er
 		return nil, err
 	}
 
-	return &SendGridClient{
-		httpClient: uhtppClient,
-		baseUrl:    parseBaseUrl,
-		apiKey:     apiKey,
-		pageLimit:  500,
-	}, nil
+	h := &SendGridClient{
+		httpClient:     uhtppClient,
+		baseUrl:        parseBaseUrl,
+		apiKey:         apiKey,
+		pageLimit:      500,
+		metrics:        newClientMetrics(metrics.NewNoOpHandler(ctx)),
+		rawHttpClient:  httpClient,
+		subuserClients: make(map[string]*uhttp.BaseHttpClient),
+	}
+
+	for _, opt := range opts {
+		opt(h)
+	}
+
+	// Set up last so that the requests are measured whatever transport the
+	// options installed.
+	h.rawHttpClient.Transport = &metricsTransport{
+		next:   h.rawHttpClient.Transport,
+		client: h,
+	}
+
+	return h, nil
 }
 
 // InviteTeammate Invite a teammate.
@@ -112,7 +254,13 @@ func (h *SendGridClient) InviteTeammate(ctx context.Context, email string, scope
 		IsAdmin: isAdmin,
 	}
 
-	return h.doRequest(ctx, http.MethodPost, uri, nil, bodyPost)
+	return h.mutate(ctx, mutation{
+		operation: "invite_teammate",
+		target:    email,
+		method:    http.MethodPost,
+		url:       uri,
+		body:      bodyPost,
+	})
 }
 
 // DeleteTeammate Delete a teammate.
@@ -120,7 +268,15 @@ func (h *SendGridClient) InviteTeammate(ctx context.Context, email string, scope
 func (h *SendGridClient) DeleteTeammate(ctx context.Context, username string) error {
 	uri := h.getUrl(DeleteTeammateEndpoint).JoinPath(username)
 
-	return h.doRequest(ctx, http.MethodDelete, uri, nil, nil)
+	return h.mutate(ctx, mutation{
+		operation: "delete_teammate",
+		target:    username,
+		method:    http.MethodDelete,
+		url:       uri,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.GetSpecificTeammate(ctx, username)
+		},
+	})
 }
 
 // GetSpecificTeammate Retrieve a specific teammate with scopes.
@@ -170,10 +326,10 @@ func (h *SendGridClient) GetTeammates(ctx context.Context, pToken *pagination.To
 		return nil, "", err
 	}
 
-	return response.Result, nextTokenPage(offset), nil
+	return response.Result, h.nextTokenPage(offset, len(response.Result)), nil
 }
 
-func (h *SendGridClient) GetTeammatesSubAccess(ctx context.Context, username string, pToken *pagination.Token) ([]models.TeammateSubuser, string, error) {
+func (h *SendGridClient) GetTeammatesSubAccess(ctx context.Context, username string, pToken *pagination.Token) (*models.TeammateSubuserResponse, string, error) {
 	var response models.TeammateSubuserResponse
 
 	uri := h.getUrl(fmt.Sprintf(TeammateSubuserAccessEndpoint, username))
@@ -208,13 +364,13 @@ func (h *SendGridClient) GetTeammatesSubAccess(ctx context.Context, username str
 		nextToken = strconv.Itoa(response.Metadata.NextParams.AfterSubuserId)
 	}
 
-	return response.SubuserAccess, nextToken, nil
+	return &response, nextToken, nil
 }
 
 // GetPendingTeammates List All Pending Teammates.
 // https://www.twilio.com/docs/sendgrid/api-reference/teammates/retrieve-all-pending-teammates
 func (h *SendGridClient) GetPendingTeammates(ctx context.Context, pToken *pagination.Token) ([]models.PendingUserAccess, string, error) {
-	var response []models.PendingUserAccess
+	var response models.CommonResponse[[]models.PendingUserAccess]
 
 	offset, err := getTokenValue(pToken)
 	if err != nil {
@@ -232,7 +388,152 @@ func (h *SendGridClient) GetPendingTeammates(ctx context.Context, pToken *pagina
 		return nil, "", err
 	}
 
-	return response, "", nil
+	return response.Result, h.nextTokenPage(offset, len(response.Result)), nil
+}
+
+// DeletePendingTeammate Delete a pending teammate invite.
+// https://www.twilio.com/docs/sendgrid/api-reference/teammates/delete-pending-teammate
+func (h *SendGridClient) DeletePendingTeammate(ctx context.Context, token string) error {
+	uri := h.getUrl(fmt.Sprintf(SpecificPendingTeammateEndpoint, token))
+
+	return h.mutate(ctx, mutation{
+		operation: "delete_pending_teammate",
+		target:    redactToken(token),
+		method:    http.MethodDelete,
+		url:       uri,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.getPendingTeammate(ctx, token)
+		},
+	})
+}
+
+// ResendTeammateInvite Resend a teammate invite, which resets its expiration
+// date.
+// https://www.twilio.com/docs/sendgrid/api-reference/teammates/resend-teammate-invite
+func (h *SendGridClient) ResendTeammateInvite(ctx context.Context, token string) error {
+	uri := h.getUrl(fmt.Sprintf(ResendTeammateInviteEndpoint, token))
+
+	return h.mutate(ctx, mutation{
+		operation: "resend_teammate_invite",
+		target:    redactToken(token),
+		method:    http.MethodPost,
+		url:       uri,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.getPendingTeammate(ctx, token)
+		},
+	})
+}
+
+// getPendingTeammate looks the pending invite up in the pending teammates,
+// SendGrid having no endpoint retrieving a single one.
+func (h *SendGridClient) getPendingTeammate(ctx context.Context, token string) (*models.PendingUserAccess, error) {
+	pToken := &pagination.Token{}
+	for {
+		pending, next, err := h.GetPendingTeammates(ctx, pToken)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, invite := range pending {
+			if invite.Token == token {
+				return &invite, nil
+			}
+		}
+
+		if next == "" {
+			return nil, ErrNotFound
+		}
+
+		pToken = &pagination.Token{Token: next}
+	}
+}
+
+// GetUsername Retrieve the account username and user ID.
+// https://www.twilio.com/docs/sendgrid/api-reference/users-api/retrieve-your-username
+func (h *SendGridClient) GetUsername(ctx context.Context) (*models.UserUsername, error) {
+	var response models.UserUsername
+
+	uri := h.getUrl(UserUsernameEndpoint)
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if OnBehalfOf(ctx) == "" {
+		h.setAccountID(strconv.Itoa(response.UserId))
+	}
+
+	return &response, nil
+}
+
+// GetUserAccount Retrieve the account type and reputation.
+// https://www.twilio.com/docs/sendgrid/api-reference/users-api/get-a-users-account-information
+func (h *SendGridClient) GetUserAccount(ctx context.Context) (*models.UserAccount, error) {
+	var response models.UserAccount
+
+	uri := h.getUrl(UserAccountEndpoint)
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// GetUserProfile Retrieve the account owner profile.
+// https://www.twilio.com/docs/sendgrid/api-reference/users-api/get-a-users-profile
+func (h *SendGridClient) GetUserProfile(ctx context.Context) (*models.UserProfile, error) {
+	var response models.UserProfile
+
+	uri := h.getUrl(UserProfileEndpoint)
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// GetUserEmail Retrieve the account email address.
+// https://www.twilio.com/docs/sendgrid/api-reference/users-api/retrieve-your-account-email-address
+func (h *SendGridClient) GetUserEmail(ctx context.Context) (*models.UserEmail, error) {
+	var response models.UserEmail
+
+	uri := h.getUrl(UserEmailEndpoint)
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// GetApiKeys List All API Keys.
+// https://www.twilio.com/docs/sendgrid/api-reference/api-keys/retrieve-all-api-keys-belonging-to-the-authenticated-user
+func (h *SendGridClient) GetApiKeys(ctx context.Context, pToken *pagination.Token) ([]models.ApiKey, string, error) {
+	var response models.CommonResponse[[]models.ApiKey]
+
+	offset, err := getTokenValue(pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uri := h.getUrl(ApiKeysEndpoint)
+	query := uri.Query()
+	query.Add("limit", fmt.Sprintf("%d", h.pageLimit))
+	query.Add("offset", fmt.Sprintf("%d", offset))
+	uri.RawQuery = query.Encode()
+
+	err = h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return response.Result, h.nextTokenPage(offset, len(response.Result)), nil
 }
 
 // GetSubusers List All Subusers.
@@ -256,7 +557,86 @@ func (h *SendGridClient) GetSubusers(ctx context.Context, pToken *pagination.Tok
 		return nil, "", err
 	}
 
-	return response, nextTokenPage(offset), nil
+	return response, h.nextTokenPage(offset, len(response)), nil
+}
+
+// GetSubuserReputations Retrieve the reputations of the given subusers in a
+// single request.
+// https://www.twilio.com/docs/sendgrid/api-reference/subusers-api/retrieve-subuser-reputations
+func (h *SendGridClient) GetSubuserReputations(ctx context.Context, usernames []string) ([]models.SubuserReputation, error) {
+	response := make([]models.SubuserReputation, 0)
+
+	uri := h.getUrl(SubuserReputationsEndpoint)
+	query := uri.Query()
+	for _, username := range usernames {
+		query.Add("usernames", username)
+	}
+	uri.RawQuery = query.Encode()
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// GetSubuserCredits Get a subuser's credits.
+// https://www.twilio.com/docs/sendgrid/api-reference/subusers-api/get-a-subusers-credits
+func (h *SendGridClient) GetSubuserCredits(ctx context.Context, username string) (*models.SubuserCredits, error) {
+	var response models.SubuserCredits
+
+	uri := h.getUrl(fmt.Sprintf(SubuserCreditsEndpoint, username))
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// UpdateSubuserCredits Update a subuser's credit allocation.
+// https://www.twilio.com/docs/sendgrid/api-reference/subusers-api/update-a-subusers-credits
+func (h *SendGridClient) UpdateSubuserCredits(ctx context.Context, username string, credits models.SubuserCreditsUpdate) error {
+	uri := h.getUrl(fmt.Sprintf(SubuserCreditsEndpoint, username))
+
+	return h.mutate(ctx, mutation{
+		operation: "update_subuser_credits",
+		target:    username,
+		method:    http.MethodPut,
+		url:       uri,
+		body:      credits,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.GetSubuserCredits(ctx, username)
+		},
+	})
+}
+
+// GetSubuserMonthlyStats Retrieve the monthly stats of all subusers for the
+// month starting on date, formatted as YYYY-MM-DD.
+// https://www.twilio.com/docs/sendgrid/api-reference/subuser-statistics/retrieve-monthly-stats-for-all-subusers
+func (h *SendGridClient) GetSubuserMonthlyStats(ctx context.Context, date string, pToken *pagination.Token) ([]models.SubuserStats, string, error) {
+	var response models.SubuserMonthlyStats
+
+	offset, err := getTokenValue(pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uri := h.getUrl(SubuserMonthlyStatsEndpoint)
+	query := uri.Query()
+	query.Add("date", date)
+	query.Add("limit", fmt.Sprintf("%d", h.pageLimit))
+	query.Add("offset", fmt.Sprintf("%d", offset))
+	uri.RawQuery = query.Encode()
+
+	err = h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return response.Stats, h.nextTokenPage(offset, len(response.Stats)), nil
 }
 
 // CreateSubuser Create a Subuser.
@@ -264,7 +644,13 @@ func (h *SendGridClient) GetSubusers(ctx context.Context, pToken *pagination.Tok
 func (h *SendGridClient) CreateSubuser(ctx context.Context, subuser models.SubuserCreate) error {
 	uri := h.getUrl(SubusersEndpoint)
 
-	return h.doRequest(ctx, http.MethodPost, uri, nil, subuser)
+	return h.mutate(ctx, mutation{
+		operation: "create_subuser",
+		target:    subuser.Username,
+		method:    http.MethodPost,
+		url:       uri,
+		body:      subuser,
+	})
 }
 
 // DeleteSubuser Delete a Subuser.
@@ -272,7 +658,12 @@ func (h *SendGridClient) CreateSubuser(ctx context.Context, subuser models.Subus
 func (h *SendGridClient) DeleteSubuser(ctx context.Context, username string) error {
 	uri := h.getUrl(fmt.Sprintf(SpecificSubusersEndpoint, username))
 
-	return h.doRequest(ctx, http.MethodDelete, uri, nil, nil)
+	return h.mutate(ctx, mutation{
+		operation: "delete_subuser",
+		target:    username,
+		method:    http.MethodDelete,
+		url:       uri,
+	})
 }
 
 // SetSubuserDisabled SetSubuserAccess Set Subuser Access.
@@ -286,7 +677,13 @@ func (h *SendGridClient) SetSubuserDisabled(ctx context.Context, username string
 		Disabled: disabled,
 	}
 
-	return h.doRequest(ctx, http.MethodPatch, uri, nil, body)
+	return h.mutate(ctx, mutation{
+		operation: "set_subuser_disabled",
+		target:    username,
+		method:    http.MethodPatch,
+		url:       uri,
+		body:      body,
+	})
 }
 
 // SetTeammateScopes
@@ -294,19 +691,440 @@ func (h *SendGridClient) SetSubuserDisabled(ctx context.Context, username string
 func (h *SendGridClient) SetTeammateScopes(ctx context.Context, username string, scopes []string, isAdmin bool) error {
 	uri := h.getUrl(fmt.Sprintf(TeammateUpdatePermissionEndpoint, username))
 
-	body := struct {
+	type permissions struct {
 		Scopes  []string `json:"scopes"`
 		IsAdmin bool     `json:"is_admin"`
+	}
+
+	return h.mutate(ctx, mutation{
+		operation: "set_teammate_scopes",
+		target:    username,
+		method:    http.MethodPatch,
+		url:       uri,
+		body:      permissions{Scopes: scopes, IsAdmin: isAdmin},
+		before: func(ctx context.Context) (interface{}, error) {
+			teammate, err := h.GetSpecificTeammate(ctx, username)
+			if err != nil {
+				return nil, err
+			}
+
+			return permissions{Scopes: teammate.Scopes, IsAdmin: teammate.IsAdmin}, nil
+		},
+	})
+}
+
+// GetIPs Retrieve all IP addresses.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-address/retrieve-all-ip-addresses
+func (h *SendGridClient) GetIPs(ctx context.Context, pToken *pagination.Token) ([]models.IPAddress, string, error) {
+	response := make([]models.IPAddress, 0)
+
+	offset, err := getTokenValue(pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uri := h.getUrl(IPsEndpoint)
+	query := uri.Query()
+	query.Add("limit", fmt.Sprintf("%d", h.pageLimit))
+	query.Add("offset", fmt.Sprintf("%d", offset))
+	uri.RawQuery = query.Encode()
+
+	err = h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return response, h.nextTokenPage(offset, len(response)), nil
+}
+
+// GetAssignedIPs Retrieve all assigned IPs.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-address/retrieve-all-assigned-ips
+func (h *SendGridClient) GetAssignedIPs(ctx context.Context) ([]models.AssignedIPAddress, error) {
+	response := make([]models.AssignedIPAddress, 0)
+
+	uri := h.getUrl(AssignedIPsEndpoint)
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// SetSubuserIPs Update the IPs assigned to a subuser, replacing the ones
+// assigned so far.
+// https://www.twilio.com/docs/sendgrid/api-reference/subusers-api/update-ips-assigned-to-a-subuser
+func (h *SendGridClient) SetSubuserIPs(ctx context.Context, username string, ips []string) error {
+	uri := h.getUrl(fmt.Sprintf(SubuserIPsEndpoint, username))
+
+	return h.mutate(ctx, mutation{
+		operation: "set_subuser_ips",
+		target:    username,
+		method:    http.MethodPut,
+		url:       uri,
+		body:      ips,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.subuserIPs(ctx, username)
+		},
+	})
+}
+
+// GetIPPools Retrieve all IP pools.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/retrieve-all-ip-pools
+func (h *SendGridClient) GetIPPools(ctx context.Context) ([]models.IPPool, error) {
+	response := make([]models.IPPool, 0)
+
+	uri := h.getUrl(IPPoolsEndpoint)
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// GetIPPool Retrieve all the IPs in a specified pool.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/retrieve-all-the-ips-in-a-specified-pool
+func (h *SendGridClient) GetIPPool(ctx context.Context, name string) (*models.IPPoolDetails, error) {
+	var response models.IPPoolDetails
+
+	uri := h.getUrl(fmt.Sprintf(SpecificIPPoolEndpoint, name))
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// CreateIPPool Create an IP pool.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/create-an-ip-pool
+func (h *SendGridClient) CreateIPPool(ctx context.Context, name string) error {
+	uri := h.getUrl(IPPoolsEndpoint)
+
+	body := struct {
+		Name string `json:"name"`
 	}{
-		Scopes:  scopes,
-		IsAdmin: isAdmin,
+		Name: name,
+	}
+
+	return h.mutate(ctx, mutation{
+		operation: "create_ip_pool",
+		target:    name,
+		method:    http.MethodPost,
+		url:       uri,
+		body:      body,
+	})
+}
+
+// DeleteIPPool Delete an IP pool.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/delete-an-ip-pool
+func (h *SendGridClient) DeleteIPPool(ctx context.Context, name string) error {
+	uri := h.getUrl(fmt.Sprintf(SpecificIPPoolEndpoint, name))
+
+	return h.mutate(ctx, mutation{
+		operation: "delete_ip_pool",
+		target:    name,
+		method:    http.MethodDelete,
+		url:       uri,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.GetIPPool(ctx, name)
+		},
+	})
+}
+
+// AddIPToPool Add an IP address to a pool.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/add-an-ip-address-to-a-pool
+func (h *SendGridClient) AddIPToPool(ctx context.Context, pool string, ip string) error {
+	uri := h.getUrl(fmt.Sprintf(IPPoolIPsEndpoint, pool))
+
+	body := struct {
+		Ip string `json:"ip"`
+	}{
+		Ip: ip,
+	}
+
+	return h.mutate(ctx, mutation{
+		operation: "add_ip_to_pool",
+		target:    pool,
+		method:    http.MethodPost,
+		url:       uri,
+		body:      body,
+	})
+}
+
+// RemoveIPFromPool Remove an IP address from a pool.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/remove-an-ip-address-from-a-pool
+func (h *SendGridClient) RemoveIPFromPool(ctx context.Context, pool string, ip string) error {
+	uri := h.getUrl(fmt.Sprintf(IPPoolIPEndpoint, pool, ip))
+
+	return h.mutate(ctx, mutation{
+		operation: "remove_ip_from_pool",
+		target:    pool,
+		method:    http.MethodDelete,
+		url:       uri,
+	})
+}
+
+// GetAuthenticatedDomains List all authenticated domains.
+// https://www.twilio.com/docs/sendgrid/api-reference/domain-authentication/list-all-authenticated-domains
+func (h *SendGridClient) GetAuthenticatedDomains(ctx context.Context, pToken *pagination.Token) ([]models.AuthenticatedDomain, string, error) {
+	response := make([]models.AuthenticatedDomain, 0)
+
+	offset, err := getTokenValue(pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	uri := h.getUrl(AuthenticatedDomainsEndpoint)
+	query := uri.Query()
+	query.Add("limit", fmt.Sprintf("%d", h.pageLimit))
+	query.Add("offset", fmt.Sprintf("%d", offset))
+	uri.RawQuery = query.Encode()
+
+	err = h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return response, h.nextTokenPage(offset, len(response)), nil
+}
+
+// GetAuthenticatedDomain Retrieve an authenticated domain.
+// https://www.twilio.com/docs/sendgrid/api-reference/domain-authentication/retrieve-an-authenticated-domain
+func (h *SendGridClient) GetAuthenticatedDomain(ctx context.Context, id int) (*models.AuthenticatedDomain, error) {
+	var response models.AuthenticatedDomain
+
+	uri := h.getUrl(fmt.Sprintf(SpecificAuthenticatedDomainEndpoint, id))
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// AddAuthenticatedDomainToSubuser Associate an authenticated domain with a
+// subuser, alongside the domains already associated with it.
+// https://www.twilio.com/docs/sendgrid/api-reference/domain-authentication/associate-an-authenticated-domain-with-a-subuser-multiple
+func (h *SendGridClient) AddAuthenticatedDomainToSubuser(ctx context.Context, id int, username string) error {
+	uri := h.getUrl(fmt.Sprintf(AuthenticatedDomainAddSubuserEndpoint, id))
+
+	body := struct {
+		Username string `json:"username"`
+	}{
+		Username: username,
+	}
+
+	return h.mutate(ctx, mutation{
+		operation: "add_authenticated_domain_to_subuser",
+		target:    username,
+		method:    http.MethodPost,
+		url:       uri,
+		body:      body,
+	})
+}
+
+// RemoveAuthenticatedDomainFromSubuser Disassociate an authenticated domain
+// from a subuser.
+// https://www.twilio.com/docs/sendgrid/api-reference/domain-authentication/disassociate-an-authenticated-domain-from-a-subuser-multiple
+func (h *SendGridClient) RemoveAuthenticatedDomainFromSubuser(ctx context.Context, id int, username string) error {
+	uri := h.getUrl(fmt.Sprintf(AuthenticatedDomainSubuserEndpoint, id))
+	query := uri.Query()
+	query.Add("username", username)
+	uri.RawQuery = query.Encode()
+
+	return h.mutate(ctx, mutation{
+		operation: "remove_authenticated_domain_from_subuser",
+		target:    username,
+		method:    http.MethodDelete,
+		url:       uri,
+	})
+}
+
+// GetBrandedLinks Retrieve all branded links, which SendGrid does not
+// paginate.
+// https://www.twilio.com/docs/sendgrid/api-reference/link-branding/retrieve-all-branded-links
+func (h *SendGridClient) GetBrandedLinks(ctx context.Context) ([]models.BrandedLink, error) {
+	response := make([]models.BrandedLink, 0)
+
+	uri := h.getUrl(BrandedLinksEndpoint)
+	query := uri.Query()
+	query.Add("limit", fmt.Sprintf("%d", h.pageLimit))
+	uri.RawQuery = query.Encode()
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	return h.doRequest(ctx, http.MethodPatch, uri, nil, body)
+	return response, nil
+}
+
+// GetSubuserBrandedLink Retrieve the branded link associated with a subuser,
+// nil when there is none.
+// https://www.twilio.com/docs/sendgrid/api-reference/link-branding/retrieve-a-subusers-branded-link
+func (h *SendGridClient) GetSubuserBrandedLink(ctx context.Context, username string) (*models.BrandedLink, error) {
+	var response models.BrandedLink
+
+	uri := h.getUrl(BrandedLinkSubuserEndpoint)
+	query := uri.Query()
+	query.Add("username", username)
+	uri.RawQuery = query.Encode()
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// AssociateBrandedLinkWithSubuser Associate a branded link with a subuser,
+// which has at most one.
+// https://www.twilio.com/docs/sendgrid/api-reference/link-branding/associate-a-branded-link-with-a-subuser
+func (h *SendGridClient) AssociateBrandedLinkWithSubuser(ctx context.Context, id int, username string) error {
+	uri := h.getUrl(fmt.Sprintf(SpecificBrandedLinkSubuserEndpoint, id))
+
+	body := struct {
+		Username string `json:"username"`
+	}{
+		Username: username,
+	}
+
+	return h.mutate(ctx, mutation{
+		operation: "associate_branded_link_with_subuser",
+		target:    username,
+		method:    http.MethodPost,
+		url:       uri,
+		body:      body,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.GetSubuserBrandedLink(ctx, username)
+		},
+	})
+}
+
+// DisassociateBrandedLinkFromSubuser Disassociate the branded link of a
+// subuser.
+// https://www.twilio.com/docs/sendgrid/api-reference/link-branding/disassociate-a-branded-link-from-a-subuser
+func (h *SendGridClient) DisassociateBrandedLinkFromSubuser(ctx context.Context, username string) error {
+	uri := h.getUrl(BrandedLinkSubuserEndpoint)
+	query := uri.Query()
+	query.Add("username", username)
+	uri.RawQuery = query.Encode()
+
+	return h.mutate(ctx, mutation{
+		operation: "disassociate_branded_link_from_subuser",
+		target:    username,
+		method:    http.MethodDelete,
+		url:       uri,
+		before: func(ctx context.Context) (interface{}, error) {
+			return h.GetSubuserBrandedLink(ctx, username)
+		},
+	})
+}
+
+// GetVerifiedSenders Get all verified senders, paginated by the ID of the
+// last sender of the previous page.
+// https://www.twilio.com/docs/sendgrid/api-reference/sender-verification/get-all-verified-senders
+func (h *SendGridClient) GetVerifiedSenders(ctx context.Context, pToken *pagination.Token) ([]models.VerifiedSender, string, error) {
+	var response models.VerifiedSendersResponse
+
+	uri := h.getUrl(VerifiedSendersEndpoint)
+	query := uri.Query()
+	query.Add("limit", fmt.Sprintf("%d", h.pageLimit))
+	if pToken != nil && pToken.Token != "" {
+		if _, err := strconv.Atoi(pToken.Token); err != nil {
+			return nil, "", ErrInvalidPaginationToken
+		}
+
+		query.Add("lastSeenID", pToken.Token)
+	}
+	uri.RawQuery = query.Encode()
+
+	err := h.doRequest(ctx, http.MethodGet, uri, &response, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(response.Results) < h.pageLimit {
+		return response.Results, "", nil
+	}
+
+	return response.Results, strconv.Itoa(response.Results[len(response.Results)-1].Id), nil
+}
+
+// DeleteVerifiedSender Delete a verified sender.
+// https://www.twilio.com/docs/sendgrid/api-reference/sender-verification/delete-verified-sender
+func (h *SendGridClient) DeleteVerifiedSender(ctx context.Context, id int) error {
+	uri := h.getUrl(fmt.Sprintf(SpecificVerifiedSenderEndpoint, id))
+
+	return h.mutate(ctx, mutation{
+		operation: "delete_verified_sender",
+		target:    strconv.Itoa(id),
+		method:    http.MethodDelete,
+		url:       uri,
+	})
 }
 
 // Helpers
 
+// subuserIPs returns the IPs assigned to a subuser.
+func (h *SendGridClient) subuserIPs(ctx context.Context, username string) ([]string, error) {
+	ips := make([]string, 0)
+
+	pToken := &pagination.Token{}
+	for {
+		page, next, err := h.GetIPs(ctx, pToken)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ip := range page {
+			if slices.Contains(ip.Subusers, username) {
+				ips = append(ips, ip.Ip)
+			}
+		}
+
+		if next == "" {
+			return ips, nil
+		}
+		pToken = &pagination.Token{Token: next}
+	}
+}
+
+func (h *SendGridClient) getHttpClient(ctx context.Context) (*uhttp.BaseHttpClient, error) {
+	subuser := OnBehalfOf(ctx)
+	if subuser == "" {
+		return h.httpClient, nil
+	}
+
+	h.subuserClientsMtx.Lock()
+	defer h.subuserClientsMtx.Unlock()
+
+	httpClient, ok := h.subuserClients[subuser]
+	if ok {
+		return httpClient, nil
+	}
+
+	httpClient, err := uhttp.NewBaseHttpClientWithContext(ctx, h.rawHttpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	h.subuserClients[subuser] = httpClient
+
+	return httpClient, nil
+}
+
 func (h *SendGridClient) getUrl(endPoint string) *url.URL {
 	return h.baseUrl.JoinPath(endPoint)
 }
@@ -326,8 +1144,14 @@ func getError(resp *http.Response) (CustomErr, error) {
 	return cErr, nil
 }
 
-func nextTokenPage(offset int) string {
-	return strconv.Itoa(offset + 1)
+// nextTokenPage returns the offset of the page after one of count records,
+// or an empty token when that page was the last one.
+func (h *SendGridClient) nextTokenPage(offset int, count int) string {
+	if count < h.pageLimit {
+		return ""
+	}
+
+	return strconv.Itoa(offset + count)
 }
 
 func getTokenValue(pToken *pagination.Token) (int, error) {
@@ -344,37 +1168,69 @@ func getTokenValue(pToken *pagination.Token) (int, error) {
 	return value, nil
 }
 
+// doRequest sends a request to SendGrid, retrying it once with the reloaded
+// API key when the key was rejected and its file holds a new one.
 func (h *SendGridClient) doRequest(
 	ctx context.Context,
 	method string,
 	urlAddress *url.URL,
 	res interface{},
 	body interface{},
+) error {
+	apiKey := h.getApiKey()
+
+	err := h.sendRequest(ctx, apiKey, method, urlAddress, res, body)
+	if !errors.Is(err, ErrUnauthorized) || !h.reloadApiKey(ctx, apiKey) {
+		return err
+	}
+
+	return h.sendRequest(ctx, h.getApiKey(), method, urlAddress, res, body)
+}
+
+func (h *SendGridClient) sendRequest(
+	ctx context.Context,
+	apiKey string,
+	method string,
+	urlAddress *url.URL,
+	res interface{},
+	body interface{},
 ) error {
 	var (
 		resp *http.Response
 		err  error
 	)
 
-	req, err := h.httpClient.NewRequest(
-		ctx,
-		method,
-		urlAddress,
-		uhttp.WithHeader(AuthHeaderName, fmt.Sprintf("Bearer %s", h.apiKey)),
+	httpClient, err := h.getHttpClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	options := []uhttp.RequestOption{
+		uhttp.WithHeader(AuthHeaderName, fmt.Sprintf("Bearer %s", apiKey)),
 		uhttp.WithJSONBody(body),
-	)
+	}
+
+	if subuser := OnBehalfOf(ctx); subuser != "" {
+		options = append(options, uhttp.WithHeader(OnBehalfOfHeader, subuser))
+	}
+
+	req, err := httpClient.NewRequest(ctx, method, urlAddress, options...)
 	if err != nil {
 		return err
 	}
 
 	switch method {
 	case http.MethodGet:
-		resp, err = h.httpClient.Do(req, uhttp.WithResponse(&res))
+		if cacheSkipped(ctx) {
+			resp, err = h.doUncached(req, &res)
+		} else {
+			resp, err = httpClient.Do(req, uhttp.WithResponse(&res))
+		}
 		if resp != nil {
 			defer resp.Body.Close()
 		}
-	case http.MethodPost, http.MethodPatch, http.MethodDelete:
-		resp, err = h.httpClient.Do(req)
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		resp, err = httpClient.Do(req)
 		if resp != nil {
 			defer resp.Body.Close()
 		}
@@ -382,7 +1238,7 @@ func (h *SendGridClient) doRequest(
 
 	if resp != nil {
 		if resp.StatusCode == http.StatusUnauthorized {
-			return errors.New("unauthorized")
+			return ErrUnauthorized
 		}
 
 		if resp.StatusCode == http.StatusForbidden {
@@ -394,6 +1250,11 @@ func (h *SendGridClient) doRequest(
 			if err != nil {
 				return err
 			}
+
+			if resp.StatusCode == http.StatusNotFound {
+				return notFoundError{err: cErr.Error()}
+			}
+
 			return cErr.Error()
 		}
 
@@ -406,3 +1267,37 @@ func (h *SendGridClient) doRequest(
 
 	return nil
 }
+
+// doUncached sends a request around the response cache, which
+// uhttp.BaseHttpClient cannot be told to skip, handling the response the way
+// it does.
+func (h *SendGridClient) doUncached(req *http.Request, res interface{}) (*http.Response, error) {
+	resp, err := h.rawHttpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		code := codes.Unknown
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			code = codes.Unavailable
+		}
+
+		return resp, uhttp.WrapErrorsWithRateLimitInfo(code, resp, fmt.Errorf("unexpected status code: %d", resp.StatusCode))
+	}
+
+	return resp, uhttp.WithResponse(res)(&uhttp.WrapperResponse{
+		Header:     resp.Header,
+		Status:     resp.Status,
+		StatusCode: resp.StatusCode,
+		Body:       body,
+	})
+}
@@ -0,0 +1,1691 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sdk/pkg/metrics"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client/sendgridtest"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newTestClient(t *testing.T, server *sendgridtest.Server) *SendGridClient {
+	t.Helper()
+
+	// GET responses are cached by default, which would hide the mutations
+	// done by the tests.
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	c, err := NewClient(context.Background(), server.URL, server.ApiKey)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	return c
+}
+
+func newTestServer(t *testing.T) *sendgridtest.Server {
+	t.Helper()
+
+	server := sendgridtest.NewServer("owner")
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestGetTeammatesPagination(t *testing.T) {
+	server := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		server.AddTeammate("", models.TeammateScope{Teammate: models.Teammate{Username: fmt.Sprintf("user%d", i)}})
+	}
+
+	c := newTestClient(t, server)
+	c.pageLimit = 2
+
+	seen := make(map[string]bool)
+	pToken := &pagination.Token{}
+	pages := 0
+
+	for {
+		teammates, next, err := c.GetTeammates(context.Background(), pToken)
+		if err != nil {
+			t.Fatalf("GetTeammates: %v", err)
+		}
+
+		pages++
+		for _, teammate := range teammates {
+			if seen[teammate.Username] {
+				t.Fatalf("teammate %s listed twice", teammate.Username)
+			}
+			seen[teammate.Username] = true
+		}
+
+		if next == "" {
+			break
+		}
+		pToken = &pagination.Token{Token: next}
+	}
+
+	if len(seen) != 5 {
+		t.Fatalf("expected 5 teammates, got %d", len(seen))
+	}
+
+	if pages != 3 {
+		t.Fatalf("expected 3 pages, got %d", pages)
+	}
+}
+
+func TestGetApiKeysPagination(t *testing.T) {
+	server := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		server.AddApiKey("", models.ApiKey{ApiKeyId: fmt.Sprintf("key%d", i)})
+	}
+
+	c := newTestClient(t, server)
+	c.pageLimit = 2
+
+	seen := make(map[string]bool)
+	pToken := &pagination.Token{}
+	pages := 0
+
+	for {
+		keys, next, err := c.GetApiKeys(context.Background(), pToken)
+		if err != nil {
+			t.Fatalf("GetApiKeys: %v", err)
+		}
+
+		pages++
+		for _, key := range keys {
+			if seen[key.ApiKeyId] {
+				t.Fatalf("api key %s listed twice", key.ApiKeyId)
+			}
+			seen[key.ApiKeyId] = true
+		}
+
+		if next == "" {
+			break
+		}
+		pToken = &pagination.Token{Token: next}
+	}
+
+	if len(seen) != 5 {
+		t.Fatalf("expected 5 api keys, got %d", len(seen))
+	}
+
+	if pages != 3 {
+		t.Fatalf("expected 3 pages, got %d", pages)
+	}
+}
+
+func TestNextTokenPage(t *testing.T) {
+	c := &SendGridClient{pageLimit: 2}
+
+	for _, tc := range []struct {
+		offset int
+		count  int
+		want   string
+	}{
+		// A full page continues after its last record.
+		{offset: 0, count: 2, want: "2"},
+		{offset: 2, count: 2, want: "4"},
+		// A short or empty page is the last one.
+		{offset: 4, count: 1, want: ""},
+		{offset: 4, count: 0, want: ""},
+	} {
+		if got := c.nextTokenPage(tc.offset, tc.count); got != tc.want {
+			t.Errorf("nextTokenPage(%d, %d) = %q, want %q", tc.offset, tc.count, got, tc.want)
+		}
+	}
+}
+
+func TestGetPendingTeammatesPagination(t *testing.T) {
+	server := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		server.AddPendingTeammate(models.PendingUserAccess{Token: fmt.Sprintf("token-%d", i), Email: fmt.Sprintf("user%d@example.com", i)})
+	}
+
+	c := newTestClient(t, server)
+	c.pageLimit = 2
+
+	var tokens []string
+	pToken := &pagination.Token{}
+
+	for {
+		invites, next, err := c.GetPendingTeammates(context.Background(), pToken)
+		if err != nil {
+			t.Fatalf("GetPendingTeammates: %v", err)
+		}
+
+		for _, invite := range invites {
+			tokens = append(tokens, invite.Token)
+		}
+
+		if next == "" {
+			break
+		}
+		pToken = &pagination.Token{Token: next}
+	}
+
+	want := []string{"token-0", "token-1", "token-2", "token-3", "token-4"}
+	if !slices.Equal(tokens, want) {
+		t.Fatalf("expected the invites %v, got %v", want, tokens)
+	}
+}
+
+func TestGetPendingTeammatesDecoding(t *testing.T) {
+	// The response documented by SendGrid, served as is rather than encoded
+	// from the models.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{
+			"result": [
+				{
+					"email": "teammate1@example.com",
+					"scopes": ["user.profile.read", "user.profile.update"],
+					"is_admin": false,
+					"token": "abcd123abc",
+					"expiration_date": 1456424263
+				},
+				{
+					"email": "teammate2@example.com",
+					"scopes": [],
+					"is_admin": true,
+					"token": "bcde234bcd",
+					"expiration_date": 1456424263
+				}
+			]
+		}`)
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	c, err := NewClient(context.Background(), server.URL, "SG.key")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	invites, next, err := c.GetPendingTeammates(context.Background(), &pagination.Token{})
+	if err != nil {
+		t.Fatalf("GetPendingTeammates: %v", err)
+	}
+
+	want := []models.PendingUserAccess{
+		{Token: "abcd123abc", Email: "teammate1@example.com", Scopes: []string{"user.profile.read", "user.profile.update"}, ExpirationDate: 1456424263},
+		{Token: "bcde234bcd", Email: "teammate2@example.com", Scopes: []string{}, IsAdmin: true, ExpirationDate: 1456424263},
+	}
+	if !reflect.DeepEqual(invites, want) || next != "" {
+		t.Fatalf("expected %+v and no next page, got %+v and %q", want, invites, next)
+	}
+}
+
+func TestGetSubusersPagination(t *testing.T) {
+	server := newTestServer(t)
+	for i := 0; i < 4; i++ {
+		server.AddSubuser(models.Subuser{Id: i + 1, Username: fmt.Sprintf("sub%d", i)})
+	}
+
+	c := newTestClient(t, server)
+	c.pageLimit = 2
+
+	var all []models.Subuser
+	pToken := &pagination.Token{}
+
+	for {
+		subusers, next, err := c.GetSubusers(context.Background(), pToken)
+		if err != nil {
+			t.Fatalf("GetSubusers: %v", err)
+		}
+
+		all = append(all, subusers...)
+
+		if next == "" {
+			break
+		}
+		pToken = &pagination.Token{Token: next}
+	}
+
+	if len(all) != 4 {
+		t.Fatalf("expected 4 subusers, got %d", len(all))
+	}
+}
+
+func TestGetTeammatesSubAccess(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{Teammate: models.Teammate{Username: "alice"}})
+	for i := 1; i <= 3; i++ {
+		server.AddSubuser(models.Subuser{Id: i, Username: fmt.Sprintf("sub%d", i)})
+	}
+	server.SetSubuserAccess("alice", true, 1, 3)
+
+	c := newTestClient(t, server)
+	c.pageLimit = 1
+
+	var ids []int
+	pToken := &pagination.Token{}
+
+	for {
+		access, next, err := c.GetTeammatesSubAccess(context.Background(), "alice", pToken)
+		if err != nil {
+			t.Fatalf("GetTeammatesSubAccess: %v", err)
+		}
+
+		if !access.HasRestrictedSubuserAccess {
+			t.Fatal("expected restricted subuser access")
+		}
+
+		for _, a := range access.SubuserAccess {
+			ids = append(ids, a.Id)
+		}
+
+		if next == "" {
+			break
+		}
+		pToken = &pagination.Token{Token: next}
+	}
+
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 3 {
+		t.Fatalf("expected access to subusers [1 3], got %v", ids)
+	}
+}
+
+func TestSetTeammateScopes(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "alice", UserType: "teammate"},
+		Scopes:   []string{"alerts.read"},
+	})
+
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	err := c.SetTeammateScopes(ctx, "alice", []string{"alerts.read", "alerts.create"}, false)
+	if err != nil {
+		t.Fatalf("SetTeammateScopes: %v", err)
+	}
+
+	teammate, err := c.GetSpecificTeammate(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetSpecificTeammate: %v", err)
+	}
+
+	if len(teammate.Scopes) != 2 || teammate.Scopes[1] != "alerts.create" {
+		t.Fatalf("unexpected scopes %v", teammate.Scopes)
+	}
+
+	var patch *sendgridtest.Request
+	for _, r := range server.Requests() {
+		if r.Method == http.MethodPatch {
+			patch = &r
+		}
+	}
+
+	if patch == nil || patch.Path != "/v3/teammates/alice" {
+		t.Fatalf("expected a PATCH of /v3/teammates/alice, got %+v", server.Requests())
+	}
+
+	var body struct {
+		Scopes  []string `json:"scopes"`
+		IsAdmin bool     `json:"is_admin"`
+	}
+	if err := json.Unmarshal(patch.Body, &body); err != nil {
+		t.Fatalf("unmarshal PATCH body: %v", err)
+	}
+
+	if len(body.Scopes) != 2 || body.IsAdmin {
+		t.Fatalf("unexpected PATCH body %s", patch.Body)
+	}
+}
+
+func TestWithoutCache(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{Teammate: models.Teammate{Username: "alice"}, Scopes: []string{"alerts.read"}})
+	ctx := context.Background()
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "false")
+	c, err := NewClient(ctx, server.URL, server.ApiKey)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	_, err = c.GetSpecificTeammate(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetSpecificTeammate: %v", err)
+	}
+
+	err = c.SetTeammateScopes(ctx, "alice", []string{"alerts.read", "alerts.create"}, false)
+	if err != nil {
+		t.Fatalf("SetTeammateScopes: %v", err)
+	}
+
+	cached, err := c.GetSpecificTeammate(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetSpecificTeammate: %v", err)
+	}
+
+	if len(cached.Scopes) != 1 {
+		t.Fatalf("expected the cached read to predate the write, got %v", cached.Scopes)
+	}
+
+	fresh, err := c.GetSpecificTeammate(WithoutCache(ctx), "alice")
+	if err != nil {
+		t.Fatalf("GetSpecificTeammate: %v", err)
+	}
+
+	if len(fresh.Scopes) != 2 {
+		t.Fatalf("expected the uncached read to see the write, got %v", fresh.Scopes)
+	}
+
+	_, err = c.GetSpecificTeammate(WithoutCache(ctx), "bob")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestInviteAndPendingTeammates(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	err := c.InviteTeammate(ctx, "bob@example.com", []string{"alerts.read"}, false)
+	if err != nil {
+		t.Fatalf("InviteTeammate: %v", err)
+	}
+
+	pending, next, err := c.GetPendingTeammates(ctx, &pagination.Token{})
+	if err != nil {
+		t.Fatalf("GetPendingTeammates: %v", err)
+	}
+
+	if next != "" {
+		t.Fatalf("expected a single page, got next token %q", next)
+	}
+
+	if len(pending) != 1 || pending[0].Email != "bob@example.com" || pending[0].Token == "" {
+		t.Fatalf("unexpected pending teammates %+v", pending)
+	}
+}
+
+func TestResendAndDeletePendingTeammate(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	expired := time.Now().Add(-24 * time.Hour).Unix()
+	server.AddPendingTeammate(models.PendingUserAccess{Token: "token-1", Email: "bob@example.com", ExpirationDate: expired})
+
+	err := c.ResendTeammateInvite(ctx, "token-1")
+	if err != nil {
+		t.Fatalf("ResendTeammateInvite: %v", err)
+	}
+
+	pending := server.PendingTeammates()
+	if len(pending) != 1 || pending[0].ExpirationDate <= time.Now().Unix() {
+		t.Fatalf("expected the invite expiration to be reset, got %+v", pending)
+	}
+
+	err = c.DeletePendingTeammate(ctx, "token-1")
+	if err != nil {
+		t.Fatalf("DeletePendingTeammate: %v", err)
+	}
+
+	if pending := server.PendingTeammates(); len(pending) != 0 {
+		t.Fatalf("expected the invite to be deleted, got %+v", pending)
+	}
+
+	err = c.ResendTeammateInvite(ctx, "token-1")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound resending a deleted invite, got %v", err)
+	}
+}
+
+func TestSubuserLifecycle(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	err := c.CreateSubuser(ctx, models.SubuserCreate{Username: "sub", Email: "sub@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateSubuser: %v", err)
+	}
+
+	err = c.SetSubuserDisabled(ctx, "sub", true)
+	if err != nil {
+		t.Fatalf("SetSubuserDisabled: %v", err)
+	}
+
+	subuser, ok := server.Subuser("sub")
+	if !ok || !subuser.Disabled {
+		t.Fatalf("expected sub to be disabled, got %+v", subuser)
+	}
+
+	err = c.DeleteSubuser(ctx, "sub")
+	if err != nil {
+		t.Fatalf("DeleteSubuser: %v", err)
+	}
+
+	if _, ok := server.Subuser("sub"); ok {
+		t.Fatal("expected sub to be deleted")
+	}
+}
+
+func TestOnBehalfOf(t *testing.T) {
+	server := newTestServer(t)
+	server.AddSubuser(models.Subuser{Id: 1, Username: "sub"})
+	server.AddApiKey("", models.ApiKey{ApiKeyId: "parent-key", Name: "parent"})
+	server.AddApiKey("sub", models.ApiKey{ApiKeyId: "sub-key", Name: "sub"})
+
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	keys, _, err := c.GetApiKeys(ctx, &pagination.Token{})
+	if err != nil {
+		t.Fatalf("GetApiKeys: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].ApiKeyId != "parent-key" {
+		t.Fatalf("unexpected parent api keys %+v", keys)
+	}
+
+	keys, _, err = c.GetApiKeys(WithOnBehalfOf(ctx, "sub"), &pagination.Token{})
+	if err != nil {
+		t.Fatalf("GetApiKeys on behalf of sub: %v", err)
+	}
+
+	if len(keys) != 1 || keys[0].ApiKeyId != "sub-key" {
+		t.Fatalf("unexpected subuser api keys %+v", keys)
+	}
+
+	requests := server.Requests()
+	if requests[len(requests)-1].OnBehalfOf != "sub" {
+		t.Fatalf("expected the on-behalf-of header, got %+v", requests[len(requests)-1])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := newTestServer(t)
+	ctx := context.Background()
+
+	t.Run("unauthorized", func(t *testing.T) {
+		c := newTestClient(t, server)
+		c.apiKey = "SG.wrong"
+
+		_, err := c.GetUsername(ctx)
+		if err == nil || err.Error() != "unauthorized" {
+			t.Fatalf("expected unauthorized, got %v", err)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		c := newTestClient(t, server)
+
+		_, err := c.GetSpecificTeammate(ctx, "nobody")
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		server.AddFault(sendgridtest.Fault{
+			Method:     http.MethodPatch,
+			PathPrefix: "/v3/teammates/",
+			Status:     http.StatusBadRequest,
+			Message:    "invalid scope",
+			Times:      1,
+		})
+
+		c := newTestClient(t, server)
+
+		err := c.SetTeammateScopes(ctx, "alice", []string{"nope"}, false)
+		want := (CustomErrField{Message: "invalid scope"}).Error()
+		if err == nil || err.Error() != want {
+			t.Fatalf("expected %q, got %v", want, err)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		server.RateLimit(1)
+
+		c := newTestClient(t, server)
+
+		_, err := c.GetUsername(ctx)
+		if status.Code(err) != codes.Unavailable {
+			t.Fatalf("expected codes.Unavailable, got %v", err)
+		}
+
+		_, err = c.GetUsername(ctx)
+		if err != nil {
+			t.Fatalf("expected the rate limit to be over, got %v", err)
+		}
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "alice", UserType: "teammate"},
+		Scopes:   []string{"alerts.read"},
+	})
+	server.AddSubuser(models.Subuser{Id: 1, Username: "sub"})
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	c, err := NewClient(context.Background(), server.URL, server.ApiKey, WithDryRun(true))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	ctx := context.Background()
+
+	err = c.SetTeammateScopes(ctx, "alice", []string{"alerts.read", "alerts.create"}, false)
+	if err != nil {
+		t.Fatalf("SetTeammateScopes: %v", err)
+	}
+
+	err = c.InviteTeammate(ctx, "bob@example.com", nil, true)
+	if err != nil {
+		t.Fatalf("InviteTeammate: %v", err)
+	}
+
+	err = c.CreateSubuser(ctx, models.SubuserCreate{Username: "new", Email: "new@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateSubuser: %v", err)
+	}
+
+	err = c.DeleteSubuser(ctx, "sub")
+	if err != nil {
+		t.Fatalf("DeleteSubuser: %v", err)
+	}
+
+	for _, r := range server.Requests() {
+		if r.Method != http.MethodGet {
+			t.Fatalf("unexpected %s %s in dry run", r.Method, r.Path)
+		}
+	}
+
+	teammate, ok := server.Teammate("alice")
+	if !ok || len(teammate.Scopes) != 1 {
+		t.Fatalf("expected alice to be unchanged, got %+v", teammate)
+	}
+
+	if _, ok := server.Subuser("sub"); !ok {
+		t.Fatal("expected sub to be kept")
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	body, err := redactBody(models.SubuserCreate{Username: "sub", Email: "sub@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("redactBody: %v", err)
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+
+	if fields["password"] != redacted || fields["username"] != "sub" {
+		t.Fatalf("unexpected redacted body %s", body)
+	}
+}
+
+func TestRedactBodyNested(t *testing.T) {
+	body, err := redactBody(map[string]interface{}{
+		"user": map[string]interface{}{"name": "sub", "password": "secret"},
+		"keys": []interface{}{map[string]interface{}{"api_key": "SG.secret"}},
+	})
+	if err != nil {
+		t.Fatalf("redactBody: %v", err)
+	}
+
+	if strings.Contains(string(body), "secret") || !strings.Contains(string(body), `"name":"sub"`) {
+		t.Fatalf("unexpected redacted body %s", body)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "alice", UserType: "teammate"},
+		Scopes:   []string{"alerts.read"},
+	})
+	server.AddFault(sendgridtest.Fault{
+		Method:     http.MethodPatch,
+		PathPrefix: "/v3/teammates/bob",
+		Status:     http.StatusBadRequest,
+		Message:    "invalid scope",
+		Times:      1,
+	})
+
+	var buf bytes.Buffer
+	c := newTestClient(t, server)
+	c.auditLog = NewAuditLog(&buf)
+	ctx := context.Background()
+
+	err := c.SetTeammateScopes(ctx, "alice", []string{"alerts.read", "alerts.create"}, false)
+	if err != nil {
+		t.Fatalf("SetTeammateScopes: %v", err)
+	}
+
+	server.AddTeammate("", models.TeammateScope{Teammate: models.Teammate{Username: "bob"}})
+	err = c.SetTeammateScopes(ctx, "bob", []string{"nope"}, false)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	err = c.CreateSubuser(ctx, models.SubuserCreate{Username: "sub", Email: "sub@example.com", Password: "secret"})
+	if err != nil {
+		t.Fatalf("CreateSubuser: %v", err)
+	}
+
+	if strings.Contains(buf.String(), "secret") || strings.Contains(buf.String(), server.ApiKey) {
+		t.Fatalf("audit log is not redacted: %s", buf.String())
+	}
+
+	var records []AuditRecord
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("unmarshal %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records, got %d", len(records))
+	}
+
+	if r := records[0]; r.Operation != "set_teammate_scopes" || r.Target != "alice" || r.Result != AuditResultSuccess ||
+		string(r.Before) != `{"is_admin":false,"scopes":["alerts.read"]}` ||
+		string(r.After) != `{"is_admin":false,"scopes":["alerts.read","alerts.create"]}` {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	if r := records[1]; r.Result != AuditResultFailure || len(r.ErrorFields) != 1 || r.ErrorFields[0].Message != "invalid scope" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	if r := records[2]; r.Operation != "create_subuser" || r.Method != http.MethodPost || r.Endpoint != "/v3/subusers" {
+		t.Fatalf("unexpected record %+v", r)
+	}
+}
+
+func TestAuditLogBeforeFailure(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "alice", UserType: "teammate"},
+		Scopes:   []string{"alerts.read"},
+	})
+	server.AddFault(sendgridtest.Fault{
+		Method:     http.MethodGet,
+		PathPrefix: "/v3/teammates/alice",
+		Status:     http.StatusInternalServerError,
+		Message:    "unavailable",
+		Times:      1,
+	})
+
+	var buf bytes.Buffer
+	c := newTestClient(t, server)
+	c.auditLog = NewAuditLog(&buf)
+
+	// The change is made although its prior state could not be read.
+	err := c.SetTeammateScopes(context.Background(), "alice", []string{"alerts.read", "alerts.create"}, false)
+	if err != nil {
+		t.Fatalf("SetTeammateScopes: %v", err)
+	}
+
+	if teammate, _ := server.Teammate("alice"); len(teammate.Scopes) != 2 {
+		t.Fatalf("expected alice's scopes to change, got %+v", teammate)
+	}
+
+	var record AuditRecord
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("unmarshal %q: %v", buf.String(), err)
+	}
+
+	if record.Result != AuditResultSuccess || record.Before != nil {
+		t.Fatalf("unexpected record %+v", record)
+	}
+}
+
+func TestAuditLogInviteTokens(t *testing.T) {
+	server := newTestServer(t)
+	server.AddPendingTeammate(models.PendingUserAccess{Token: "token-1", Email: "bob@example.com"})
+	server.AddPendingTeammate(models.PendingUserAccess{Token: "token-2", Email: "carol@example.com"})
+
+	var buf bytes.Buffer
+	c := newTestClient(t, server)
+	c.auditLog = NewAuditLog(&buf)
+	ctx := context.Background()
+
+	err := c.ResendTeammateInvite(ctx, "token-1")
+	if err != nil {
+		t.Fatalf("ResendTeammateInvite: %v", err)
+	}
+
+	err = c.DeletePendingTeammate(ctx, "token-2")
+	if err != nil {
+		t.Fatalf("DeletePendingTeammate: %v", err)
+	}
+
+	if strings.Contains(buf.String(), "token-1") || strings.Contains(buf.String(), "token-2") {
+		t.Fatalf("expected the tokens to be left out of the audit log, got %s", buf.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(lines))
+	}
+
+	var record AuditRecord
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("unmarshal %q: %v", lines[1], err)
+	}
+
+	pseudonym := redactToken("token-2")
+	if record.Target != pseudonym || record.Endpoint != "/v3/teammates/pending/"+pseudonym {
+		t.Fatalf("unexpected record %+v", record)
+	}
+}
+
+func TestAuditLogBeforeUncached(t *testing.T) {
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "alice", UserType: "teammate"},
+		Scopes:   []string{"alerts.read"},
+	})
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "false")
+
+	var buf bytes.Buffer
+	ctx := context.Background()
+	c, err := NewClient(ctx, server.URL, server.ApiKey, WithAuditLog(NewAuditLog(&buf)))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	// The scopes are cached before they change.
+	_, err = c.GetSpecificTeammate(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetSpecificTeammate: %v", err)
+	}
+
+	for _, scopes := range [][]string{{"alerts.read", "alerts.create"}, {"alerts.read"}} {
+		err = c.SetTeammateScopes(ctx, "alice", scopes, false)
+		if err != nil {
+			t.Fatalf("SetTeammateScopes: %v", err)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 audit records, got %d", len(lines))
+	}
+
+	var record AuditRecord
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil {
+		t.Fatalf("unmarshal %q: %v", lines[1], err)
+	}
+
+	if string(record.Before) != `{"is_admin":false,"scopes":["alerts.read","alerts.create"]}` {
+		t.Fatalf("expected the state left by the first change, got %s", record.Before)
+	}
+}
+
+func TestSubuserIPs(t *testing.T) {
+	server := newTestServer(t)
+	server.AddSubuser(models.Subuser{Id: 1, Username: "sub"})
+	server.AddIP(models.IPAddress{Ip: "1.1.1.1", Subusers: []string{"sub"}}, true)
+	server.AddIP(models.IPAddress{Ip: "2.2.2.2"}, false)
+
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	ips, next, err := c.GetIPs(ctx, &pagination.Token{})
+	if err != nil {
+		t.Fatalf("GetIPs: %v", err)
+	}
+
+	if len(ips) != 2 || next != "" {
+		t.Fatalf("unexpected ips %+v, next token %q", ips, next)
+	}
+
+	assigned, err := c.GetAssignedIPs(ctx)
+	if err != nil {
+		t.Fatalf("GetAssignedIPs: %v", err)
+	}
+
+	if len(assigned) != 1 || assigned[0].Ip != "1.1.1.1" {
+		t.Fatalf("unexpected assigned ips %+v", assigned)
+	}
+
+	err = c.SetSubuserIPs(ctx, "sub", []string{"2.2.2.2"})
+	if err != nil {
+		t.Fatalf("SetSubuserIPs: %v", err)
+	}
+
+	if ip, _ := server.IP("1.1.1.1"); len(ip.Subusers) != 0 {
+		t.Fatalf("expected 1.1.1.1 to be unassigned, got %+v", ip)
+	}
+
+	if ip, _ := server.IP("2.2.2.2"); len(ip.Subusers) != 1 || ip.Subusers[0] != "sub" {
+		t.Fatalf("expected 2.2.2.2 to be assigned to sub, got %+v", ip)
+	}
+
+	requests := server.Requests()
+	if last := requests[len(requests)-1]; last.Method != http.MethodPut || last.Path != "/v3/subusers/sub/ips" {
+		t.Fatalf("expected a PUT of /v3/subusers/sub/ips, got %+v", last)
+	}
+}
+
+func TestIPPools(t *testing.T) {
+	server := newTestServer(t)
+	server.AddIP(models.IPAddress{Ip: "1.1.1.1"}, true)
+	server.AddIPPool("marketing")
+
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	err := c.CreateIPPool(ctx, "transactional")
+	if err != nil {
+		t.Fatalf("CreateIPPool: %v", err)
+	}
+
+	pools, err := c.GetIPPools(ctx)
+	if err != nil {
+		t.Fatalf("GetIPPools: %v", err)
+	}
+
+	if len(pools) != 2 || pools[1].Name != "transactional" {
+		t.Fatalf("unexpected pools %+v", pools)
+	}
+
+	err = c.AddIPToPool(ctx, "transactional", "1.1.1.1")
+	if err != nil {
+		t.Fatalf("AddIPToPool: %v", err)
+	}
+
+	pool, err := c.GetIPPool(ctx, "transactional")
+	if err != nil {
+		t.Fatalf("GetIPPool: %v", err)
+	}
+
+	if pool.PoolName != "transactional" || len(pool.Ips) != 1 || pool.Ips[0].Ip != "1.1.1.1" {
+		t.Fatalf("unexpected pool %+v", pool)
+	}
+
+	err = c.RemoveIPFromPool(ctx, "transactional", "1.1.1.1")
+	if err != nil {
+		t.Fatalf("RemoveIPFromPool: %v", err)
+	}
+
+	if ip, _ := server.IP("1.1.1.1"); len(ip.Pools) != 0 {
+		t.Fatalf("expected 1.1.1.1 to be removed from the pool, got %+v", ip)
+	}
+
+	err = c.DeleteIPPool(ctx, "transactional")
+	if err != nil {
+		t.Fatalf("DeleteIPPool: %v", err)
+	}
+
+	if server.HasIPPool("transactional") {
+		t.Fatal("expected the pool to be deleted")
+	}
+}
+
+func TestSenderDomains(t *testing.T) {
+	server := newTestServer(t)
+	server.AddSubuser(models.Subuser{Id: 1, Username: "sub"})
+	server.AddAuthenticatedDomain(models.AuthenticatedDomain{Id: 100, Domain: "example.com"})
+	server.AddBrandedLink(models.BrandedLink{Id: 200, Domain: "example.com"})
+
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	domains, next, err := c.GetAuthenticatedDomains(ctx, &pagination.Token{})
+	if err != nil {
+		t.Fatalf("GetAuthenticatedDomains: %v", err)
+	}
+
+	if len(domains) != 1 || domains[0].Id != 100 || next != "" {
+		t.Fatalf("unexpected domains %+v, next token %q", domains, next)
+	}
+
+	err = c.AddAuthenticatedDomainToSubuser(ctx, 100, "sub")
+	if err != nil {
+		t.Fatalf("AddAuthenticatedDomainToSubuser: %v", err)
+	}
+
+	domain, err := c.GetAuthenticatedDomain(ctx, 100)
+	if err != nil {
+		t.Fatalf("GetAuthenticatedDomain: %v", err)
+	}
+
+	if len(domain.Subusers) != 1 || domain.Subusers[0].Username != "sub" || domain.Subusers[0].UserId != 1 {
+		t.Fatalf("expected the domain to be associated with sub, got %+v", domain)
+	}
+
+	err = c.RemoveAuthenticatedDomainFromSubuser(ctx, 100, "sub")
+	if err != nil {
+		t.Fatalf("RemoveAuthenticatedDomainFromSubuser: %v", err)
+	}
+
+	if d, _ := server.AuthenticatedDomain(100); len(d.Subusers) != 0 {
+		t.Fatalf("expected the domain to be disassociated, got %+v", d)
+	}
+
+	link, err := c.GetSubuserBrandedLink(ctx, "sub")
+	if err != nil {
+		t.Fatalf("GetSubuserBrandedLink: %v", err)
+	}
+
+	if link != nil {
+		t.Fatalf("expected no branded link, got %+v", link)
+	}
+
+	err = c.AssociateBrandedLinkWithSubuser(ctx, 200, "sub")
+	if err != nil {
+		t.Fatalf("AssociateBrandedLinkWithSubuser: %v", err)
+	}
+
+	link, err = c.GetSubuserBrandedLink(ctx, "sub")
+	if err != nil {
+		t.Fatalf("GetSubuserBrandedLink: %v", err)
+	}
+
+	if link == nil || link.Id != 200 {
+		t.Fatalf("expected branded link 200, got %+v", link)
+	}
+
+	err = c.DisassociateBrandedLinkFromSubuser(ctx, "sub")
+	if err != nil {
+		t.Fatalf("DisassociateBrandedLinkFromSubuser: %v", err)
+	}
+
+	if _, ok := server.SubuserBrandedLink("sub"); ok {
+		t.Fatal("expected the branded link to be disassociated")
+	}
+}
+
+func TestNotFound(t *testing.T) {
+	server := newTestServer(t)
+	c := newTestClient(t, server)
+
+	_, err := c.GetAuthenticatedDomain(context.Background(), 100)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+
+	if !strings.Contains(err.Error(), "authenticated domain not found") {
+		t.Fatalf("expected the SendGrid error message, got %v", err)
+	}
+}
+
+func TestVerifiedSenders(t *testing.T) {
+	server := newTestServer(t)
+	for i := 1; i <= 3; i++ {
+		server.AddVerifiedSender(models.VerifiedSender{Id: i * 10, FromEmail: fmt.Sprintf("sender%d@example.com", i)})
+	}
+
+	c := newTestClient(t, server)
+	c.pageLimit = 2
+	ctx := context.Background()
+
+	var all []models.VerifiedSender
+	pToken := &pagination.Token{}
+
+	for {
+		senders, next, err := c.GetVerifiedSenders(ctx, pToken)
+		if err != nil {
+			t.Fatalf("GetVerifiedSenders: %v", err)
+		}
+
+		all = append(all, senders...)
+
+		if next == "" {
+			break
+		}
+		pToken = &pagination.Token{Token: next}
+	}
+
+	if len(all) != 3 || all[2].Id != 30 {
+		t.Fatalf("expected 3 verified senders, got %+v", all)
+	}
+
+	err := c.DeleteVerifiedSender(ctx, 20)
+	if err != nil {
+		t.Fatalf("DeleteVerifiedSender: %v", err)
+	}
+
+	if server.HasVerifiedSender(20) {
+		t.Fatal("expected the verified sender to be deleted")
+	}
+}
+
+func TestSubuserUsage(t *testing.T) {
+	server := newTestServer(t)
+	server.AddSubuser(models.Subuser{Id: 1, Username: "sub1"})
+	server.AddSubuser(models.Subuser{Id: 2, Username: "sub2"})
+	server.SetSubuserUsage("sub1", 99.5, models.SubuserCredits{Type: "recurring", Total: 1000, Remain: 400, Used: 600}, models.StatsMetrics{Requests: 10})
+	server.SetSubuserUsage("sub2", 80, models.SubuserCredits{Type: "unlimited"}, models.StatsMetrics{Requests: 20})
+
+	c := newTestClient(t, server)
+	ctx := context.Background()
+
+	reputations, err := c.GetSubuserReputations(ctx, []string{"sub1", "sub2"})
+	if err != nil {
+		t.Fatalf("GetSubuserReputations: %v", err)
+	}
+
+	if len(reputations) != 2 || reputations[0].Reputation != 99.5 || reputations[1].Username != "sub2" {
+		t.Fatalf("unexpected reputations %+v", reputations)
+	}
+
+	requests := server.Requests()
+	if query := requests[len(requests)-1].Query["usernames"]; len(query) != 2 {
+		t.Fatalf("expected the reputations of both subusers in one request, got %v", query)
+	}
+
+	credits, err := c.GetSubuserCredits(ctx, "sub1")
+	if err != nil {
+		t.Fatalf("GetSubuserCredits: %v", err)
+	}
+
+	if credits.Type != "recurring" || credits.Remain != 400 {
+		t.Fatalf("unexpected credits %+v", credits)
+	}
+
+	stats, next, err := c.GetSubuserMonthlyStats(ctx, "2024-01-01", &pagination.Token{})
+	if err != nil {
+		t.Fatalf("GetSubuserMonthlyStats: %v", err)
+	}
+
+	if len(stats) != 2 || stats[1].Name != "sub2" || stats[1].Metrics.Requests != 20 || next != "" {
+		t.Fatalf("unexpected stats %+v, next token %q", stats, next)
+	}
+}
+
+func TestUpdateSubuserCredits(t *testing.T) {
+	server := newTestServer(t)
+	server.AddSubuser(models.Subuser{Id: 1, Username: "sub"})
+
+	c := newTestClient(t, server)
+
+	total := 5000
+	err := c.UpdateSubuserCredits(context.Background(), "sub", models.SubuserCreditsUpdate{
+		Type:           "recurring",
+		ResetFrequency: "weekly",
+		Total:          &total,
+	})
+	if err != nil {
+		t.Fatalf("UpdateSubuserCredits: %v", err)
+	}
+
+	credits := server.SubuserCredits("sub")
+	if credits.Type != "recurring" || credits.ResetFrequency != "weekly" || credits.Total != 5000 {
+		t.Fatalf("unexpected credits %+v", credits)
+	}
+
+	requests := server.Requests()
+	if last := requests[len(requests)-1]; last.Method != http.MethodPut || last.Path != "/v3/subusers/sub/credits" {
+		t.Fatalf("expected a PUT of /v3/subusers/sub/credits, got %+v", last)
+	}
+}
+
+// recordingHandler is a metrics.Handler keeping the sum of the values
+// reported to each metric by tags.
+type recordingHandler struct {
+	mtx    sync.Mutex
+	values map[string]int64
+}
+
+func (r *recordingHandler) Int64Counter(name string, _ string, _ metrics.Unit) metrics.Int64Counter {
+	return &recordingMetric{handler: r, name: name}
+}
+
+func (r *recordingHandler) Int64Gauge(name string, _ string, _ metrics.Unit) metrics.Int64Gauge {
+	return &recordingMetric{handler: r, name: name, gauge: true}
+}
+
+func (r *recordingHandler) Int64Histogram(name string, _ string, _ metrics.Unit) metrics.Int64Histogram {
+	return &recordingMetric{handler: r, name: name}
+}
+
+func (r *recordingHandler) WithTags(_ map[string]string) metrics.Handler {
+	return r
+}
+
+// value returns the value reported to the metric with the tags, written as
+// sorted key=value pairs.
+func (r *recordingHandler) value(name string, tags ...string) (int64, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	value, ok := r.values[name+" "+strings.Join(tags, " ")]
+
+	return value, ok
+}
+
+type recordingMetric struct {
+	handler *recordingHandler
+	name    string
+	gauge   bool
+}
+
+func (m *recordingMetric) report(value int64, tags map[string]string) {
+	var pairs []string
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	slices.Sort(pairs)
+
+	m.handler.mtx.Lock()
+	defer m.handler.mtx.Unlock()
+
+	key := m.name + " " + strings.Join(pairs, " ")
+	if m.gauge {
+		m.handler.values[key] = value
+	} else {
+		m.handler.values[key] += value
+	}
+}
+
+func (m *recordingMetric) Add(_ context.Context, value int64, tags map[string]string) {
+	m.report(value, tags)
+}
+
+func (m *recordingMetric) Record(_ context.Context, value int64, tags map[string]string) {
+	m.report(value, tags)
+}
+
+func (m *recordingMetric) Observe(_ context.Context, value int64, tags map[string]string) {
+	m.report(value, tags)
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t)
+	server.AddTeammate("", models.TeammateScope{Teammate: models.Teammate{Username: "alice"}})
+	server.AddTeammate("", models.TeammateScope{Teammate: models.Teammate{Username: "bob"}})
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	handler := &recordingHandler{values: make(map[string]int64)}
+	c, err := NewClient(ctx, server.URL, server.ApiKey, WithMetrics(handler))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	for _, username := range []string{"alice", "bob", "carol"} {
+		_, _ = c.GetSpecificTeammate(ctx, username)
+	}
+
+	server.RateLimit(1)
+	_, _ = c.GetUsername(ctx)
+
+	for _, tc := range []struct {
+		metric string
+		tags   []string
+		want   int64
+	}{
+		{requestCounterName, []string{"endpoint=v3/teammates/%s", "method=GET"}, 3},
+		{responseCounterName, []string{"endpoint=v3/teammates/%s", "method=GET", "status_code=200"}, 2},
+		{responseCounterName, []string{"endpoint=v3/teammates/%s", "method=GET", "status_code=404"}, 1},
+		{responseCounterName, []string{"endpoint=v3/user/username", "method=GET", "status_code=429"}, 1},
+		{rateLimitGaugeName, []string{"endpoint=v3/teammates/%s"}, 599},
+		{rateLimitGaugeName, []string{"endpoint=v3/user/username"}, 0},
+	} {
+		got, ok := handler.value(tc.metric, tc.tags...)
+		if !ok || got != tc.want {
+			t.Errorf("expected %s %v to be %d, got %d (%t)", tc.metric, tc.tags, tc.want, got, ok)
+		}
+	}
+
+	if _, ok := handler.value(requestLatencyHistoName, "endpoint=v3/teammates/%s", "method=GET"); !ok {
+		t.Error("expected the latency of the requests to be recorded")
+	}
+}
+
+func TestMetricsLeaveOutCachedResponses(t *testing.T) {
+	ctx := context.Background()
+	server := newTestServer(t)
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "false")
+
+	handler := &recordingHandler{values: make(map[string]int64)}
+	c, err := NewClient(ctx, server.URL, server.ApiKey, WithMetrics(handler))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err := c.GetUsername(ctx)
+		if err != nil {
+			t.Fatalf("GetUsername: %v", err)
+		}
+	}
+
+	got, _ := handler.value(requestCounterName, "endpoint=v3/user/username", "method=GET")
+	if got != 1 {
+		t.Errorf("expected the request sent to SendGrid to be counted once, got %d", got)
+	}
+
+	if n := len(server.Requests()); n != 1 {
+		t.Fatalf("expected the response to be cached, got %d requests", n)
+	}
+}
+
+func TestEndpointOf(t *testing.T) {
+	c, err := NewClient(context.Background(), "https://proxy.example.com/sendgrid/", "SG.key")
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"v3/teammates":                         RetrieveAllTeammatesEndpoint,
+		"v3/teammates/pending":                 PendingTeammateEndpoint,
+		"v3/teammates/pending/token-1":         SpecificPendingTeammateEndpoint,
+		"v3/teammates/pending/token-1/resend":  ResendTeammateInviteEndpoint,
+		"v3/teammates/alice":                   SpecificTeammateEndpoint,
+		"v3/teammates/alice/subuser_access":    TeammateSubuserAccessEndpoint,
+		"v3/subusers/reputations":              SubuserReputationsEndpoint,
+		"v3/subusers/sub/credits":              SubuserCreditsEndpoint,
+		"v3/ips/pools/marketing/ips/1.1.1.1":   IPPoolIPEndpoint,
+		"v3/whitelabel/domains/12/subuser:add": AuthenticatedDomainAddSubuserEndpoint,
+		"v3/whitelabel/links/subuser":          BrandedLinkSubuserEndpoint,
+		"v3/whitelabel/links/12/subuser":       SpecificBrandedLinkSubuserEndpoint,
+		"v3/mail_settings":                     otherEndpoint,
+	} {
+		if got := c.endpointOf(c.getUrl(path)); got != want {
+			t.Errorf("expected %s to be tagged %s, got %s", path, want, got)
+		}
+	}
+}
+
+func TestRecordingReplay(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	// sync lists the teammates, fetches each of them, and creates a subuser.
+	sync := func(c *SendGridClient) []models.TeammateScope {
+		t.Helper()
+
+		teammates, _, err := c.GetTeammates(ctx, &pagination.Token{})
+		if err != nil {
+			t.Fatalf("GetTeammates: %v", err)
+		}
+
+		var rv []models.TeammateScope
+		for _, teammate := range teammates {
+			specific, err := c.GetSpecificTeammate(ctx, teammate.Username)
+			if err != nil {
+				t.Fatalf("GetSpecificTeammate: %v", err)
+			}
+
+			rv = append(rv, *specific)
+		}
+
+		err = c.CreateSubuser(ctx, models.SubuserCreate{Username: "new", Email: "new@example.com", Password: "hunter2"})
+		if err != nil {
+			t.Fatalf("CreateSubuser: %v", err)
+		}
+
+		return rv
+	}
+
+	for _, redact := range []bool{false, true} {
+		t.Run(fmt.Sprintf("redact %t", redact), func(t *testing.T) {
+			server := newTestServer(t)
+			server.AddTeammate("", models.TeammateScope{
+				Teammate: models.Teammate{Username: "alice@example.com", Email: "alice@example.com", UserType: "teammate"},
+				Scopes:   []string{"alerts.read"},
+			})
+
+			var buf bytes.Buffer
+			c, err := NewClient(ctx, server.URL, server.ApiKey, WithRecording(NewRecording(&buf, redact)))
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+
+			recorded := sync(c)
+
+			recording := buf.String()
+			for _, secret := range []string{server.ApiKey, "hunter2", "alice@example.com", "new@example.com"} {
+				if strings.Contains(recording, secret) == redact {
+					t.Errorf("expected %q in the recording %t, got %s", secret, !redact, recording)
+				}
+			}
+
+			replay, err := NewReplay(strings.NewReader(recording))
+			if err != nil {
+				t.Fatalf("NewReplay: %v", err)
+			}
+
+			// The replay never reaches the network, whatever the base URL
+			// and API key are.
+			requests := len(server.Requests())
+			c, err = NewClient(ctx, "http://127.0.0.1:1/", "SG.other-key", WithReplay(replay))
+			if err != nil {
+				t.Fatalf("NewClient: %v", err)
+			}
+
+			// A redacted recording replays the pseudonyms of the email
+			// addresses instead of the addresses.
+			wantUsername := recorded[0].Username
+			if redact {
+				wantUsername = redactEmails(wantUsername)
+			}
+
+			replayed := sync(c)
+			if len(replayed) != 1 || replayed[0].Username != wantUsername || !slices.Equal(replayed[0].Scopes, recorded[0].Scopes) {
+				t.Errorf("expected the replay to return %s with %v, got %v", wantUsername, recorded[0].Scopes, replayed)
+			}
+
+			if n := len(server.Requests()); n != requests {
+				t.Errorf("expected no request to reach the server, got %d", n-requests)
+			}
+
+			_, err = c.GetIPPools(ctx)
+			if err == nil || !strings.Contains(err.Error(), "no recorded response") {
+				t.Errorf("expected an unrecorded request to fail, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRecordingReplayInviteTokens(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	server := newTestServer(t)
+	server.AddPendingTeammate(models.PendingUserAccess{Token: "token-1", Email: "bob@example.com"})
+	server.AddPendingTeammate(models.PendingUserAccess{Token: "token-2", Email: "carol@example.com"})
+
+	// resendAll lists the invites and resends each of them.
+	resendAll := func(c *SendGridClient) []string {
+		t.Helper()
+
+		invites, _, err := c.GetPendingTeammates(ctx, &pagination.Token{})
+		if err != nil {
+			t.Fatalf("GetPendingTeammates: %v", err)
+		}
+
+		var rv []string
+		for _, invite := range invites {
+			err := c.ResendTeammateInvite(ctx, invite.Token)
+			if err != nil {
+				t.Fatalf("ResendTeammateInvite %s: %v", invite.Token, err)
+			}
+
+			rv = append(rv, invite.Token)
+		}
+
+		return rv
+	}
+
+	var buf bytes.Buffer
+	c, err := NewClient(ctx, server.URL, server.ApiKey, WithRecording(NewRecording(&buf, true)))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	resendAll(c)
+
+	for _, token := range []string{"token-1", "token-2"} {
+		if strings.Contains(buf.String(), token) {
+			t.Errorf("expected %s to be left out of the recording, got %s", token, buf.String())
+		}
+	}
+
+	replay, err := NewReplay(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("NewReplay: %v", err)
+	}
+
+	c, err = NewClient(ctx, "http://127.0.0.1:1/", "SG.other-key", WithReplay(replay))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	// The invites keep distinct pseudonyms, which their requests match.
+	want := []string{redactToken("token-1"), redactToken("token-2")}
+	if got := resendAll(c); !slices.Equal(got, want) {
+		t.Errorf("expected the replayed tokens %v, got %v", want, got)
+	}
+}
+
+func TestRecordingReplayApiKeys(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	server := newTestServer(t)
+	server.AddApiKey("", models.ApiKey{ApiKeyId: "key-1", Name: "first"})
+	server.AddApiKey("", models.ApiKey{ApiKeyId: "key-2", Name: "second"})
+
+	listIDs := func(c *SendGridClient) []string {
+		t.Helper()
+
+		keys, _, err := c.GetApiKeys(ctx, &pagination.Token{})
+		if err != nil {
+			t.Fatalf("GetApiKeys: %v", err)
+		}
+
+		var rv []string
+		for _, key := range keys {
+			rv = append(rv, key.ApiKeyId)
+		}
+
+		return rv
+	}
+
+	var buf bytes.Buffer
+	c, err := NewClient(ctx, server.URL, server.ApiKey, WithRecording(NewRecording(&buf, true)))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	listIDs(c)
+
+	replay, err := NewReplay(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatalf("NewReplay: %v", err)
+	}
+
+	c, err = NewClient(ctx, "http://127.0.0.1:1/", "SG.other-key", WithReplay(replay))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	// The API keys keep their IDs, which their resources are identified by.
+	want := []string{"key-1", "key-2"}
+	if got := listIDs(c); !slices.Equal(got, want) {
+		t.Errorf("expected the replayed api key ids %v, got %v", want, got)
+	}
+}
+
+func TestRecordingReplaySeveralAccounts(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	servers := make([]*sendgridtest.Server, 2)
+	for i := range servers {
+		servers[i] = newTestServer(t)
+		servers[i].SetAccount(models.AccountDetails{
+			Username: models.UserUsername{Username: fmt.Sprintf("owner%d", i), UserId: 1000 + i},
+		})
+		servers[i].AddTeammate("", models.TeammateScope{Teammate: models.Teammate{Username: fmt.Sprintf("teammate%d", i)}})
+	}
+
+	// connect retrieves the account of each client, the way the connector
+	// starts, then lists the teammates of each account.
+	connect := func(clients []*SendGridClient) []string {
+		t.Helper()
+
+		for _, c := range clients {
+			_, err := c.GetUsername(ctx)
+			if err != nil {
+				t.Fatalf("GetUsername: %v", err)
+			}
+		}
+
+		var rv []string
+		for _, c := range clients {
+			teammates, _, err := c.GetTeammates(ctx, &pagination.Token{})
+			if err != nil {
+				t.Fatalf("GetTeammates: %v", err)
+			}
+
+			for _, teammate := range teammates {
+				rv = append(rv, c.account()+":"+teammate.Username)
+			}
+		}
+
+		return rv
+	}
+
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	recording, err := OpenRecording(path, true)
+	if err != nil {
+		t.Fatalf("OpenRecording: %v", err)
+	}
+
+	var clients []*SendGridClient
+	for _, server := range servers {
+		c, err := NewClient(ctx, server.URL, server.ApiKey, WithRecording(recording))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+
+		clients = append(clients, c)
+	}
+
+	recorded := connect(clients)
+
+	err = recording.Close()
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	replay, err := LoadReplay(path)
+	if err != nil {
+		t.Fatalf("LoadReplay: %v", err)
+	}
+
+	// The API keys were rotated since the recording.
+	clients = nil
+	for i := range servers {
+		c, err := NewClient(ctx, "http://127.0.0.1:1/", fmt.Sprintf("SG.rotated-%d", i), WithReplay(replay))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+
+		clients = append(clients, c)
+	}
+
+	want := []string{"1000:teammate0", "1001:teammate1"}
+	if !slices.Equal(recorded, want) {
+		t.Fatalf("expected the recorded teammates %v, got %v", want, recorded)
+	}
+
+	if replayed := connect(clients); !slices.Equal(replayed, want) {
+		t.Errorf("expected the replayed teammates %v, got %v", want, replayed)
+	}
+}
+
+func TestApiKeyFile(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+
+	writeKey := func(path string, apiKey string) {
+		t.Helper()
+
+		err := os.WriteFile(path, []byte(apiKey+"\n"), 0600)
+		if err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+
+	t.Run("read", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "api-key")
+		writeKey(path, "  SG.key ")
+
+		apiKey, err := ReadApiKeyFile(path)
+		if err != nil || apiKey != "SG.key" {
+			t.Fatalf("expected SG.key, got %q (%v)", apiKey, err)
+		}
+
+		writeKey(path, "")
+		if _, err := ReadApiKeyFile(path); !errors.Is(err, ErrApiKeyIsEmpty) {
+			t.Fatalf("expected ErrApiKeyIsEmpty, got %v", err)
+		}
+	})
+
+	t.Run("rotated key", func(t *testing.T) {
+		server := newTestServer(t)
+		path := filepath.Join(t.TempDir(), "api-key")
+		writeKey(path, "SG.old")
+
+		c, err := NewClient(ctx, server.URL, "SG.old", WithApiKeyFile(path))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+
+		// The key was rotated after the client was created.
+		writeKey(path, server.ApiKey)
+
+		_, err = c.GetUsername(ctx)
+		if err != nil {
+			t.Fatalf("expected the rotated key to be used, got %v", err)
+		}
+
+		if n := len(server.Requests()); n != 2 {
+			t.Errorf("expected the request to be retried once, got %d requests", n)
+		}
+
+		_, err = c.GetUsername(ctx)
+		if err != nil || len(server.Requests()) != 3 {
+			t.Errorf("expected the reloaded key to be kept, got %v after %d requests", err, len(server.Requests()))
+		}
+	})
+
+	t.Run("key not rotated", func(t *testing.T) {
+		server := newTestServer(t)
+		path := filepath.Join(t.TempDir(), "api-key")
+		writeKey(path, "SG.old")
+
+		c, err := NewClient(ctx, server.URL, "SG.old", WithApiKeyFile(path))
+		if err != nil {
+			t.Fatalf("NewClient: %v", err)
+		}
+
+		_, err = c.GetUsername(ctx)
+		if !errors.Is(err, ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+
+		if n := len(server.Requests()); n != 1 {
+			t.Errorf("expected no retry, got %d requests", n)
+		}
+	})
+}
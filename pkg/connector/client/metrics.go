@@ -0,0 +1,173 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/conductorone/baton-sdk/pkg/metrics"
+)
+
+const (
+	requestCounterName      = "baton_sendgrid.requests"
+	requestCounterDesc      = "number of requests sent to SendGrid by endpoint and method"
+	responseCounterName     = "baton_sendgrid.responses"
+	responseCounterDesc     = "number of SendGrid responses by endpoint, method and status code"
+	requestLatencyHistoName = "baton_sendgrid.request_latency"
+	requestLatencyHistoDesc = "duration of the requests sent to SendGrid by endpoint and method"
+	rateLimitGaugeName      = "baton_sendgrid.ratelimit_remaining"
+	rateLimitGaugeDesc      = "requests left in the current SendGrid rate limit window by endpoint"
+
+	// RateLimitRemainingHeader holds how many requests SendGrid still
+	// accepts on the endpoint before throttling.
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+
+	// statusError tags the requests which got no response at all.
+	statusError = "error"
+	// otherEndpoint tags the requests to paths no known endpoint matches.
+	otherEndpoint = "other"
+)
+
+// endpoints are the path templates requests are tagged with, so that the
+// IDs and usernames in their paths do not make a tag value per resource.
+var endpoints = []string{
+	RetrieveAllTeammatesEndpoint,
+	SpecificTeammateEndpoint,
+	PendingTeammateEndpoint,
+	SpecificPendingTeammateEndpoint,
+	ResendTeammateInviteEndpoint,
+	TeammateSubuserAccessEndpoint,
+	UserUsernameEndpoint,
+	UserAccountEndpoint,
+	UserProfileEndpoint,
+	UserEmailEndpoint,
+	ApiKeysEndpoint,
+	SubusersEndpoint,
+	SpecificSubusersEndpoint,
+	SubusersWebsiteAccessEndpoint,
+	SubuserIPsEndpoint,
+	SubuserReputationsEndpoint,
+	SubuserCreditsEndpoint,
+	SubuserMonthlyStatsEndpoint,
+	IPsEndpoint,
+	AssignedIPsEndpoint,
+	IPPoolsEndpoint,
+	SpecificIPPoolEndpoint,
+	IPPoolIPsEndpoint,
+	IPPoolIPEndpoint,
+	AuthenticatedDomainsEndpoint,
+	SpecificAuthenticatedDomainEndpoint,
+	AuthenticatedDomainSubuserEndpoint,
+	AuthenticatedDomainAddSubuserEndpoint,
+	BrandedLinksEndpoint,
+	BrandedLinkSubuserEndpoint,
+	SpecificBrandedLinkSubuserEndpoint,
+	VerifiedSendersEndpoint,
+	SpecificVerifiedSenderEndpoint,
+}
+
+// WithMetrics reports the requests sent to SendGrid to the metrics handler.
+// The responses served from the response cache are not requests to SendGrid
+// and are left out.
+func WithMetrics(handler metrics.Handler) Option {
+	return func(h *SendGridClient) {
+		h.metrics = newClientMetrics(handler)
+	}
+}
+
+// metricsTransport reports the requests which reach the transport of the
+// client, underneath the response cache.
+type metricsTransport struct {
+	next   http.RoundTripper
+	client *SendGridClient
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.client.metrics.record(req.Context(), t.client.endpointOf(req.URL), req.Method, resp, time.Since(start))
+
+	return resp, err
+}
+
+type clientMetrics struct {
+	requests  metrics.Int64Counter
+	responses metrics.Int64Counter
+	latency   metrics.Int64Histogram
+	rateLimit metrics.Int64Gauge
+}
+
+func newClientMetrics(handler metrics.Handler) *clientMetrics {
+	return &clientMetrics{
+		requests:  handler.Int64Counter(requestCounterName, requestCounterDesc, metrics.Dimensionless),
+		responses: handler.Int64Counter(responseCounterName, responseCounterDesc, metrics.Dimensionless),
+		latency:   handler.Int64Histogram(requestLatencyHistoName, requestLatencyHistoDesc, metrics.Milliseconds),
+		rateLimit: handler.Int64Gauge(rateLimitGaugeName, rateLimitGaugeDesc, metrics.Dimensionless),
+	}
+}
+
+// record reports a request to the endpoint, resp being nil when the request
+// got no response.
+func (m *clientMetrics) record(ctx context.Context, endpoint string, method string, resp *http.Response, dur time.Duration) {
+	tags := map[string]string{"endpoint": endpoint, "method": method}
+	m.requests.Add(ctx, 1, tags)
+	m.latency.Record(ctx, dur.Milliseconds(), tags)
+
+	status := statusError
+	if resp != nil {
+		status = strconv.Itoa(resp.StatusCode)
+
+		remaining, err := strconv.ParseInt(resp.Header.Get(RateLimitRemainingHeader), 10, 64)
+		if err == nil {
+			m.rateLimit.Observe(ctx, remaining, map[string]string{"endpoint": endpoint})
+		}
+	}
+
+	m.responses.Add(ctx, 1, map[string]string{"endpoint": endpoint, "method": method, "status_code": status})
+}
+
+// endpointOf returns the endpoint template matching the path of the request
+// URL, preferring the templates with the fewest placeholders so that
+// v3/teammates/pending is not mistaken for a teammate named pending.
+func (h *SendGridClient) endpointOf(u *url.URL) string {
+	path := strings.TrimPrefix(u.Path, h.baseUrl.Path)
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	rv := otherEndpoint
+	fewest := -1
+	for _, endpoint := range endpoints {
+		placeholders, ok := matchEndpoint(endpoint, segments)
+		if ok && (fewest == -1 || placeholders < fewest) {
+			rv = endpoint
+			fewest = placeholders
+		}
+	}
+
+	return rv
+}
+
+// matchEndpoint reports whether the path segments match the endpoint
+// template, and how many of its segments are placeholders.
+func matchEndpoint(endpoint string, segments []string) (int, bool) {
+	template := strings.Split(strings.Trim(endpoint, "/"), "/")
+	if len(template) != len(segments) {
+		return 0, false
+	}
+
+	placeholders := 0
+	for i, part := range template {
+		if strings.HasPrefix(part, "%") {
+			placeholders++
+			continue
+		}
+
+		if part != segments[i] {
+			return 0, false
+		}
+	}
+
+	return placeholders, true
+}
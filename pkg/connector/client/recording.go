@@ -0,0 +1,456 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exchange is a request sent to SendGrid and the response it got, as
+// written to a recording.
+type Exchange struct {
+	Timestamp time.Time `json:"timestamp"`
+	// Account is the ID of the account the request was sent to, so that the
+	// recording of several accounts replays whatever their API keys are. It
+	// is empty for the requests sent before the client retrieved it.
+	Account       string            `json:"account"`
+	Method        string            `json:"method"`
+	Path          string            `json:"path"`
+	Query         string            `json:"query,omitempty"`
+	RequestHeader map[string]string `json:"request_header,omitempty"`
+	RequestBody   string            `json:"request_body,omitempty"`
+	Status        int               `json:"status"`
+	Header        map[string]string `json:"header,omitempty"`
+	Body          string            `json:"body,omitempty"`
+}
+
+// recordedRequestHeaders are the request headers written to a recording.
+var recordedRequestHeaders = []string{AuthHeaderName, OnBehalfOfHeader}
+
+// Recording writes the SendGrid traffic of the clients as JSON lines, one
+// exchange per line. It is safe to share between the clients of several
+// accounts.
+type Recording struct {
+	mtx    sync.Mutex
+	w      io.Writer
+	redact bool
+	// file is the file opened by OpenRecording, closed with the recording.
+	file io.Closer
+}
+
+// NewRecording returns a recording writing to w. When redact is set the
+// Authorization header, the passwords, API keys and tokens, and the email
+// addresses are left out of the recording, every email address being
+// replaced by the same pseudonym wherever it appears so the recording still
+// replays.
+func NewRecording(w io.Writer, redact bool) *Recording {
+	return &Recording{w: w, redact: redact}
+}
+
+// OpenRecording creates the recording file at path, replacing any previous
+// recording.
+func OpenRecording(path string, redact bool) (*Recording, error) {
+	f, err := os.OpenFile(path, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	recording := NewRecording(f, redact)
+	recording.file = f
+
+	return recording, nil
+}
+
+// Close closes the file of a recording opened by OpenRecording.
+func (r *Recording) Close() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	return r.file.Close()
+}
+
+func (r *Recording) write(exchange Exchange) error {
+	if r.redact {
+		exchange = redactExchange(exchange)
+	}
+
+	line, err := json.Marshal(exchange)
+	if err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	_, err = r.w.Write(append(line, '\n'))
+
+	return err
+}
+
+// WithRecording writes every request the client sends to SendGrid and the
+// response it gets to the recording.
+func WithRecording(recording *Recording) Option {
+	return func(h *SendGridClient) {
+		h.rawHttpClient.Transport = &recordingTransport{
+			next:      h.rawHttpClient.Transport,
+			recording: recording,
+			client:    h,
+		}
+	}
+}
+
+type recordingTransport struct {
+	next      http.RoundTripper
+	recording *Recording
+	client    *SendGridClient
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var requestBody []byte
+	if req.Body != nil {
+		var err error
+		requestBody, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		req.Body = io.NopCloser(bytes.NewReader(requestBody))
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	exchange := Exchange{
+		Timestamp:     time.Now().UTC(),
+		Account:       t.client.account(),
+		Method:        req.Method,
+		Path:          t.client.relativePath(req.URL),
+		Query:         canonicalQuery(req.URL.RawQuery, false),
+		RequestHeader: make(map[string]string),
+		RequestBody:   string(requestBody),
+		Status:        resp.StatusCode,
+		Header:        make(map[string]string),
+		Body:          string(body),
+	}
+
+	for _, name := range recordedRequestHeaders {
+		if value := req.Header.Get(name); value != "" {
+			exchange.RequestHeader[name] = value
+		}
+	}
+
+	for name := range resp.Header {
+		exchange.Header[name] = resp.Header.Get(name)
+	}
+
+	err = t.recording.write(exchange)
+	if err != nil {
+		return nil, fmt.Errorf("baton-sendgrid: failed to record %s %s: %w", req.Method, exchange.Path, err)
+	}
+
+	return resp, nil
+}
+
+// Replay serves requests from a recording instead of SendGrid. Requests are
+// matched by account ID, method, path, query and on-behalf-of header, and
+// the responses to identical requests are served in the order they were
+// recorded, the last one being served again once they run out. The clients
+// retrieve their account ID first, from the responses recorded before any
+// account was known, so clients created in the order of the recording find
+// their accounts again whatever their API keys are. A recording of a single
+// account replays whatever the account of the client is.
+type Replay struct {
+	mtx       sync.Mutex
+	accounts  map[string]struct{}
+	exchanges map[string][]Exchange
+}
+
+// LoadReplay reads the recording file at path.
+func LoadReplay(path string) (*Replay, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return NewReplay(f)
+}
+
+// NewReplay reads a recording from r.
+func NewReplay(r io.Reader) (*Replay, error) {
+	replay := &Replay{
+		accounts:  make(map[string]struct{}),
+		exchanges: make(map[string][]Exchange),
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var exchange Exchange
+		err := json.Unmarshal(scanner.Bytes(), &exchange)
+		if err != nil {
+			return nil, fmt.Errorf("baton-sendgrid: invalid recording line %d: %w", line, err)
+		}
+
+		key := exchangeKey(exchange.Account, exchange.Method, exchange.Path, exchange.Query, exchange.RequestHeader[OnBehalfOfHeader])
+		if exchange.Account != "" {
+			replay.accounts[exchange.Account] = struct{}{}
+		}
+		replay.exchanges[key] = append(replay.exchanges[key], exchange)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return replay, nil
+}
+
+// account returns the recorded account the requests of the client with the
+// given account are served from.
+func (r *Replay) account(account string) string {
+	if _, ok := r.accounts[account]; ok || account == "" || len(r.accounts) != 1 {
+		return account
+	}
+
+	for recorded := range r.accounts {
+		account = recorded
+	}
+
+	return account
+}
+
+// next returns the recorded exchange answering the request.
+func (r *Replay) next(key string) (Exchange, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	exchanges := r.exchanges[key]
+	if len(exchanges) == 0 {
+		return Exchange{}, false
+	}
+
+	if len(exchanges) > 1 {
+		r.exchanges[key] = exchanges[1:]
+	}
+
+	return exchanges[0], true
+}
+
+// WithReplay serves every request of the client from the replay, the client
+// never reaching the network.
+func WithReplay(replay *Replay) Option {
+	return func(h *SendGridClient) {
+		h.rawHttpClient.Transport = &replayTransport{
+			replay: replay,
+			client: h,
+		}
+	}
+}
+
+type replayTransport struct {
+	replay *Replay
+	client *SendGridClient
+}
+
+func (t *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	account := t.replay.account(t.client.account())
+	path := t.client.relativePath(req.URL)
+	onBehalfOf := req.Header.Get(OnBehalfOfHeader)
+
+	exchange, ok := t.replay.next(exchangeKey(account, req.Method, path, canonicalQuery(req.URL.RawQuery, false), onBehalfOf))
+	if !ok {
+		// A redacted recording holds pseudonyms in place of the email
+		// addresses of the requests.
+		exchange, ok = t.replay.next(exchangeKey(account, req.Method, redactEmails(redactPathTokens(path)), canonicalQuery(req.URL.RawQuery, true), redactEmails(onBehalfOf)))
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("baton-sendgrid: no recorded response to %s %s", req.Method, req.URL.String())
+	}
+
+	header := make(http.Header)
+	for name, value := range exchange.Header {
+		header.Set(name, value)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
+
+func exchangeKey(account string, method string, path string, query string, onBehalfOf string) string {
+	return strings.Join([]string{account, method, path, query, onBehalfOf}, " ")
+}
+
+// account returns the ID of the account of the client, or an empty string
+// until the client retrieved it.
+func (h *SendGridClient) account() string {
+	h.accountIDMtx.Lock()
+	defer h.accountIDMtx.Unlock()
+
+	return h.accountID
+}
+
+func (h *SendGridClient) setAccountID(id string) {
+	h.accountIDMtx.Lock()
+	defer h.accountIDMtx.Unlock()
+
+	h.accountID = id
+}
+
+// relativePath returns the path of the request URL under the base URL of
+// the client, so recordings replay whatever the base URL is.
+func (h *SendGridClient) relativePath(u *url.URL) string {
+	return strings.TrimPrefix(strings.TrimPrefix(u.Path, h.baseUrl.Path), "/")
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// redactEmails replaces the email addresses in s with pseudonyms, the same
+// address always getting the same pseudonym.
+func redactEmails(s string) string {
+	return emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		sum := sha256.Sum256([]byte(strings.ToLower(email)))
+
+		return "redacted-" + hex.EncodeToString(sum[:4]) + "@example.invalid"
+	})
+}
+
+// redactToken returns the pseudonym of an invite token, the same token
+// always getting the same pseudonym so that the requests to an invite still
+// match it.
+func redactToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return "redacted-token-" + hex.EncodeToString(sum[:8])
+}
+
+// tokenEndpoints are the endpoints with an invite token in their path.
+var tokenEndpoints = []string{
+	SpecificPendingTeammateEndpoint,
+	ResendTeammateInviteEndpoint,
+}
+
+// redactPathTokens replaces the invite token in the path of a request to an
+// invite with its pseudonym.
+func redactPathTokens(path string) string {
+	segments := strings.Split(path, "/")
+	for _, endpoint := range tokenEndpoints {
+		template := strings.Split(strings.Trim(endpoint, "/"), "/")
+		if _, ok := matchEndpoint(endpoint, segments); !ok {
+			continue
+		}
+
+		for i, part := range template {
+			if strings.HasPrefix(part, "%") {
+				segments[i] = redactToken(segments[i])
+			}
+		}
+
+		return strings.Join(segments, "/")
+	}
+
+	return path
+}
+
+// canonicalQuery encodes the query with its parameters sorted, the email
+// addresses in their values redacted if redact is set.
+func canonicalQuery(query string, redact bool) string {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return query
+	}
+
+	if redact {
+		for key, vs := range values {
+			for i, v := range vs {
+				vs[i] = redactEmails(v)
+			}
+			values[key] = vs
+		}
+	}
+
+	return values.Encode()
+}
+
+func redactExchange(exchange Exchange) Exchange {
+	exchange.Path = redactEmails(redactPathTokens(exchange.Path))
+	exchange.Query = canonicalQuery(exchange.Query, true)
+	exchange.RequestBody = redactEmails(redactJSON(exchange.RequestBody))
+	exchange.Body = redactEmails(redactJSON(exchange.Body))
+
+	header := make(map[string]string, len(exchange.RequestHeader))
+	for name, value := range exchange.RequestHeader {
+		if name == AuthHeaderName {
+			value = redacted
+		}
+
+		header[name] = redactEmails(value)
+	}
+	exchange.RequestHeader = header
+
+	return exchange
+}
+
+// redactJSON replaces the values of the sensitive fields anywhere in a JSON
+// document, bodies which are not JSON being returned as is.
+func redactJSON(body string) string {
+	if body == "" {
+		return body
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(body), &value); err != nil {
+		return body
+	}
+
+	raw, err := json.Marshal(redactValue(value))
+	if err != nil {
+		return body
+	}
+
+	return string(raw)
+}
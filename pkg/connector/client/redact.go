@@ -0,0 +1,90 @@
+package client
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveFields are the request body fields which are never logged.
+var sensitiveFields = []string{
+	"password",
+	"api_key",
+	"token",
+}
+
+// redactBody returns the JSON encoding of a request body with the values of
+// sensitive fields replaced wherever they are nested, for logging.
+func redactBody(body interface{}) ([]byte, error) {
+	if body == nil {
+		return nil, nil
+	}
+
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(redactValue(value))
+}
+
+// redactValue replaces the values of the sensitive fields anywhere in a
+// decoded JSON document, in place.
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			token, isString := field.(string)
+			switch {
+			case isTokenField(key) && isString:
+				// Invites are requested by their token, which is replaced by
+				// a pseudonym rather than left out to tell them apart.
+				v[key] = redactToken(token)
+			case isSensitiveField(key):
+				v[key] = redacted
+			default:
+				v[key] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+
+	return value
+}
+
+// isTokenField reports whether the field holds a token.
+func isTokenField(key string) bool {
+	key = strings.ToLower(key)
+
+	return !isIDField(key) && strings.Contains(key, "token")
+}
+
+func isSensitiveField(key string) bool {
+	key = strings.ToLower(key)
+	if isIDField(key) {
+		return false
+	}
+
+	for _, field := range sensitiveFields {
+		if strings.Contains(key, field) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isIDField reports whether the field identifies a resource, such as the
+// api_key_id of an API key, which is kept for the resources to stay apart.
+func isIDField(key string) bool {
+	return strings.HasSuffix(key, "_id")
+}
@@ -0,0 +1,1416 @@
+// Package sendgridtest provides an in-process fake of the SendGrid API for
+// exercising client.SendGridClient end-to-end without network access.
+//
+// The server keeps its data in memory and mutates it the way SendGrid does,
+// records every request it receives, and can be told to answer requests with
+// rate limit or error responses.
+package sendgridtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+)
+
+const (
+	DefaultApiKey = "SG.test-api-key"
+
+	authHeaderName   = "Authorization"
+	onBehalfOfHeader = "on-behalf-of"
+	defaultPageLimit = 500
+)
+
+// Request is a request received by the server.
+type Request struct {
+	Method     string
+	Path       string
+	Query      map[string][]string
+	OnBehalfOf string
+	Body       []byte
+}
+
+// Fault is an error response the server answers matching requests with.
+type Fault struct {
+	// Method and PathPrefix select the requests the fault applies to, an
+	// empty value matches every request.
+	Method     string
+	PathPrefix string
+
+	// Status is the HTTP status code of the response, Message the message of
+	// the SendGrid error it carries.
+	Status  int
+	Message string
+
+	// Times is how many requests the fault answers, zero means every one.
+	Times int
+}
+
+// tenant is the data visible to a parent account or, through the
+// on-behalf-of header, to one of its subusers.
+type tenant struct {
+	teammates []*models.TeammateScope
+	pending   []*models.PendingUserAccess
+	apiKeys   []models.ApiKey
+}
+
+type subuserAccess struct {
+	restricted bool
+	subusers   []int
+}
+
+// Server is a fake SendGrid API server.
+type Server struct {
+	*httptest.Server
+
+	ApiKey string
+
+	mtx           sync.Mutex
+	account       models.AccountDetails
+	tenants       map[string]*tenant
+	subusers      []*models.Subuser
+	subuserAccess map[string]*subuserAccess
+	reputations   map[string]float64
+	credits       map[string]models.SubuserCredits
+	monthlyStats  map[string]models.StatsMetrics
+	ips           []*models.IPAddress
+	assignedIPs   []string
+	pools         []string
+	domains       []*models.AuthenticatedDomain
+	links         []*models.BrandedLink
+	subuserLinks  map[string]int
+	senders       []*models.VerifiedSender
+	requests      []Request
+	faults        []*Fault
+	nextID        int
+}
+
+// NewServer starts a fake SendGrid server for the account with the given
+// username, answering requests authenticated with DefaultApiKey.
+func NewServer(username string) *Server {
+	s := &Server{
+		ApiKey: DefaultApiKey,
+		account: models.AccountDetails{
+			Username: models.UserUsername{Username: username, UserId: 1000},
+			Account:  models.UserAccount{Type: "paid", Reputation: 100},
+			Email:    models.UserEmail{Email: username + "@example.com"},
+		},
+		tenants:       map[string]*tenant{"": {}},
+		subuserAccess: make(map[string]*subuserAccess),
+		subuserLinks:  make(map[string]int),
+		reputations:   make(map[string]float64),
+		credits:       make(map[string]models.SubuserCredits),
+		monthlyStats:  make(map[string]models.StatsMetrics),
+		nextID:        2000,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v3/user/username", s.getUsername)
+	mux.HandleFunc("GET /v3/user/account", s.getUserAccount)
+	mux.HandleFunc("GET /v3/user/profile", s.getUserProfile)
+	mux.HandleFunc("GET /v3/user/email", s.getUserEmail)
+	mux.HandleFunc("GET /v3/teammates", s.listTeammates)
+	mux.HandleFunc("POST /v3/teammates", s.inviteTeammate)
+	mux.HandleFunc("GET /v3/teammates/pending", s.listPendingTeammates)
+	mux.HandleFunc("DELETE /v3/teammates/pending/{token}", s.deletePendingTeammate)
+	mux.HandleFunc("POST /v3/teammates/pending/{token}/resend", s.resendTeammateInvite)
+	mux.HandleFunc("GET /v3/teammates/{username}", s.getTeammate)
+	mux.HandleFunc("PATCH /v3/teammates/{username}", s.updateTeammate)
+	mux.HandleFunc("DELETE /v3/teammates/{username}", s.deleteTeammate)
+	mux.HandleFunc("GET /v3/teammates/{username}/subuser_access", s.listSubuserAccess)
+	mux.HandleFunc("GET /v3/subusers", s.listSubusers)
+	mux.HandleFunc("GET /v3/subusers/reputations", s.listSubuserReputations)
+	mux.HandleFunc("GET /v3/subusers/stats/monthly", s.listSubuserMonthlyStats)
+	mux.HandleFunc("GET /v3/subusers/{username}/credits", s.getSubuserCredits)
+	mux.HandleFunc("PUT /v3/subusers/{username}/credits", s.updateSubuserCredits)
+	mux.HandleFunc("POST /v3/subusers", s.createSubuser)
+	mux.HandleFunc("DELETE /v3/subusers/{username}", s.deleteSubuser)
+	mux.HandleFunc("PATCH /v3/subusers/{username}/website_access", s.updateWebsiteAccess)
+	mux.HandleFunc("PUT /v3/subusers/{username}/ips", s.updateSubuserIPs)
+	mux.HandleFunc("GET /v3/api_keys", s.listApiKeys)
+	mux.HandleFunc("GET /v3/ips", s.listIPs)
+	mux.HandleFunc("GET /v3/ips/assigned", s.listAssignedIPs)
+	mux.HandleFunc("GET /v3/ips/pools", s.listIPPools)
+	mux.HandleFunc("POST /v3/ips/pools", s.createIPPool)
+	mux.HandleFunc("GET /v3/ips/pools/{pool}", s.getIPPool)
+	mux.HandleFunc("DELETE /v3/ips/pools/{pool}", s.deleteIPPool)
+	mux.HandleFunc("POST /v3/ips/pools/{pool}/ips", s.addIPToPool)
+	mux.HandleFunc("DELETE /v3/ips/pools/{pool}/ips/{ip}", s.removeIPFromPool)
+	mux.HandleFunc("GET /v3/whitelabel/domains", s.listDomains)
+	mux.HandleFunc("GET /v3/whitelabel/domains/{id}", s.getDomain)
+	mux.HandleFunc("POST /v3/whitelabel/domains/{id}/subuser:add", s.addDomainSubuser)
+	mux.HandleFunc("DELETE /v3/whitelabel/domains/{id}/subuser", s.removeDomainSubuser)
+	mux.HandleFunc("GET /v3/whitelabel/links", s.listLinks)
+	mux.HandleFunc("GET /v3/whitelabel/links/subuser", s.getSubuserLink)
+	mux.HandleFunc("DELETE /v3/whitelabel/links/subuser", s.disassociateSubuserLink)
+	mux.HandleFunc("POST /v3/whitelabel/links/{id}/subuser", s.associateSubuserLink)
+	mux.HandleFunc("GET /v3/verified_senders", s.listVerifiedSenders)
+	mux.HandleFunc("DELETE /v3/verified_senders/{id}", s.deleteVerifiedSender)
+
+	s.Server = httptest.NewServer(s.middleware(mux))
+
+	return s
+}
+
+// SetAccount replaces the account details served by the user endpoints.
+func (s *Server) SetAccount(details models.AccountDetails) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.account = details
+}
+
+// AddTeammate adds a teammate to the parent account, or to a subuser when
+// subuser is not empty.
+func (s *Server) AddTeammate(subuser string, teammate models.TeammateScope) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.tenant(subuser).teammates = append(s.tenant(subuser).teammates, &teammate)
+}
+
+// Teammate returns a copy of a teammate of the parent account.
+func (s *Server) Teammate(username string) (models.TeammateScope, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t := s.findTeammate(s.tenant(""), username)
+	if t == nil {
+		return models.TeammateScope{}, false
+	}
+
+	rv := *t
+	rv.Scopes = slices.Clone(t.Scopes)
+
+	return rv, true
+}
+
+// AddPendingTeammate adds a pending invitation to the parent account.
+func (s *Server) AddPendingTeammate(pending models.PendingUserAccess) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.tenant("").pending = append(s.tenant("").pending, &pending)
+}
+
+// PendingTeammates returns the pending invitations of the parent account.
+func (s *Server) PendingTeammates() []models.PendingUserAccess {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rv := make([]models.PendingUserAccess, len(s.tenant("").pending))
+	for i, p := range s.tenant("").pending {
+		rv[i] = *p
+	}
+
+	return rv
+}
+
+// AddSubuser adds a subuser to the parent account.
+func (s *Server) AddSubuser(subuser models.Subuser) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.subusers = append(s.subusers, &subuser)
+}
+
+// Subuser returns a copy of a subuser of the parent account.
+func (s *Server) Subuser(username string) (models.Subuser, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	su := s.findSubuser(username)
+	if su == nil {
+		return models.Subuser{}, false
+	}
+
+	return *su, true
+}
+
+// SetSubuserUsage sets the reputation, credits and monthly sending stats of
+// a subuser.
+func (s *Server) SetSubuserUsage(username string, reputation float64, credits models.SubuserCredits, stats models.StatsMetrics) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.reputations[username] = reputation
+	s.credits[username] = credits
+	s.monthlyStats[username] = stats
+}
+
+// SubuserCredits returns the credit allocation of a subuser.
+func (s *Server) SubuserCredits(username string) models.SubuserCredits {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.credits[username]
+}
+
+// SetSubuserAccess sets the subusers a teammate can access. Teammates without
+// restricted access can access every subuser.
+func (s *Server) SetSubuserAccess(username string, restricted bool, subuserIDs ...int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.subuserAccess[username] = &subuserAccess{
+		restricted: restricted,
+		subusers:   subuserIDs,
+	}
+}
+
+// AddApiKey adds an API key to the parent account, or to a subuser when
+// subuser is not empty.
+func (s *Server) AddApiKey(subuser string, apiKey models.ApiKey) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.tenant(subuser).apiKeys = append(s.tenant(subuser).apiKeys, apiKey)
+}
+
+// AddIP adds an IP address to the parent account, assigned to the account
+// for sending when assigned is set.
+func (s *Server) AddIP(ip models.IPAddress, assigned bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.ips = append(s.ips, &ip)
+	if assigned {
+		s.assignedIPs = append(s.assignedIPs, ip.Ip)
+	}
+}
+
+// IP returns a copy of an IP address of the parent account.
+func (s *Server) IP(address string) (models.IPAddress, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for _, ip := range s.ips {
+		if ip.Ip == address {
+			rv := *ip
+			rv.Subusers = slices.Clone(ip.Subusers)
+
+			return rv, true
+		}
+	}
+
+	return models.IPAddress{}, false
+}
+
+// AddIPPool adds an IP pool to the parent account.
+func (s *Server) AddIPPool(name string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.pools = append(s.pools, name)
+}
+
+// HasIPPool reports whether the parent account has the IP pool.
+func (s *Server) HasIPPool(name string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return slices.Contains(s.pools, name)
+}
+
+// AddAuthenticatedDomain adds an authenticated domain to the parent account.
+func (s *Server) AddAuthenticatedDomain(domain models.AuthenticatedDomain) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.domains = append(s.domains, &domain)
+}
+
+// AuthenticatedDomain returns a copy of an authenticated domain of the
+// parent account.
+func (s *Server) AuthenticatedDomain(id int) (models.AuthenticatedDomain, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	d := s.findDomain(id)
+	if d == nil {
+		return models.AuthenticatedDomain{}, false
+	}
+
+	rv := *d
+	rv.Subusers = slices.Clone(d.Subusers)
+
+	return rv, true
+}
+
+// AddBrandedLink adds a branded link to the parent account, associated with
+// the given subusers.
+func (s *Server) AddBrandedLink(link models.BrandedLink, subusers ...string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.links = append(s.links, &link)
+	for _, username := range subusers {
+		s.subuserLinks[username] = link.Id
+	}
+}
+
+// SubuserBrandedLink returns the ID of the branded link associated with a
+// subuser.
+func (s *Server) SubuserBrandedLink(username string) (int, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id, ok := s.subuserLinks[username]
+
+	return id, ok
+}
+
+// AddVerifiedSender adds a verified sender to the parent account.
+func (s *Server) AddVerifiedSender(sender models.VerifiedSender) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.senders = append(s.senders, &sender)
+}
+
+// HasVerifiedSender reports whether the parent account has the verified
+// sender.
+func (s *Server) HasVerifiedSender(id int) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return slices.ContainsFunc(s.senders, func(sender *models.VerifiedSender) bool { return sender.Id == id })
+}
+
+// AddFault makes the server answer requests matching the fault with an error.
+func (s *Server) AddFault(fault Fault) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.faults = append(s.faults, &fault)
+}
+
+// RateLimit makes the server answer the next n requests with 429 Too Many
+// Requests.
+func (s *Server) RateLimit(n int) {
+	s.AddFault(Fault{
+		Status:  http.StatusTooManyRequests,
+		Message: "too many requests",
+		Times:   n,
+	})
+}
+
+// Requests returns every request received by the server so far.
+func (s *Server) Requests() []Request {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return slices.Clone(s.requests)
+}
+
+// ResetRequests forgets the requests received so far.
+func (s *Server) ResetRequests() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.requests = nil
+}
+
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "", err.Error())
+			return
+		}
+
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+
+		s.mtx.Lock()
+		s.requests = append(s.requests, Request{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Query:      r.URL.Query(),
+			OnBehalfOf: r.Header.Get(onBehalfOfHeader),
+			Body:       body,
+		})
+		s.mtx.Unlock()
+
+		if r.Header.Get(authHeaderName) != "Bearer "+s.ApiKey {
+			writeError(w, http.StatusUnauthorized, "", "authorization required")
+			return
+		}
+
+		s.mtx.Lock()
+		fault := s.takeFault(r)
+		s.mtx.Unlock()
+
+		if fault != nil {
+			if fault.Status == http.StatusTooManyRequests {
+				w.Header().Set("X-RateLimit-Limit", "600")
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Second).Unix(), 10))
+			}
+
+			writeError(w, fault.Status, "", fault.Message)
+			return
+		}
+
+		// SendGrid reports the rate limit of the endpoint on every response.
+		w.Header().Set("X-RateLimit-Limit", "600")
+		w.Header().Set("X-RateLimit-Remaining", "599")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10))
+
+		subuser := r.Header.Get(onBehalfOfHeader)
+		if subuser != "" && s.lockedFindSubuser(subuser) == nil {
+			writeError(w, http.StatusUnauthorized, "", "subuser not found")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// takeFault returns the first fault matching the request. It must be called
+// with the lock held.
+func (s *Server) takeFault(r *http.Request) *Fault {
+	for i, fault := range s.faults {
+		if fault.Method != "" && fault.Method != r.Method {
+			continue
+		}
+
+		if !strings.HasPrefix(r.URL.Path, fault.PathPrefix) {
+			continue
+		}
+
+		if fault.Times > 0 {
+			fault.Times--
+			if fault.Times == 0 {
+				s.faults = slices.Delete(s.faults, i, i+1)
+			}
+		}
+
+		return fault
+	}
+
+	return nil
+}
+
+// tenant returns the data visible on behalf of the subuser, or to the parent
+// account when subuser is empty. It must be called with the lock held.
+func (s *Server) tenant(subuser string) *tenant {
+	t, ok := s.tenants[subuser]
+	if !ok {
+		t = &tenant{}
+		s.tenants[subuser] = t
+	}
+
+	return t
+}
+
+func (s *Server) requestTenant(r *http.Request) *tenant {
+	return s.tenant(r.Header.Get(onBehalfOfHeader))
+}
+
+func (s *Server) findTeammate(t *tenant, username string) *models.TeammateScope {
+	for _, teammate := range t.teammates {
+		if teammate.Username == username {
+			return teammate
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) findSubuser(username string) *models.Subuser {
+	for _, subuser := range s.subusers {
+		if subuser.Username == username {
+			return subuser
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) lockedFindSubuser(username string) *models.Subuser {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.findSubuser(username)
+}
+
+func (s *Server) getUsername(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, s.account.Username)
+}
+
+func (s *Server) getUserAccount(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, s.account.Account)
+}
+
+func (s *Server) getUserProfile(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, s.account.Profile)
+}
+
+func (s *Server) getUserEmail(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, s.account.Email)
+}
+
+func (s *Server) listTeammates(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	page := paginate(r, s.requestTenant(r).teammates)
+
+	result := make([]models.Teammate, len(page))
+	for i, teammate := range page {
+		result[i] = teammate.Teammate
+	}
+
+	writeJSON(w, http.StatusOK, models.CommonResponse[[]models.Teammate]{Result: result})
+}
+
+func (s *Server) inviteTeammate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Email   string   `json:"email"`
+		Scopes  []string `json:"scopes"`
+		IsAdmin bool     `json:"is_admin"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Email == "" {
+		writeError(w, http.StatusBadRequest, "email", "invalid email")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t := s.requestTenant(r)
+	for _, teammate := range t.teammates {
+		if teammate.Email == body.Email {
+			writeError(w, http.StatusBadRequest, "email", "already a teammate")
+			return
+		}
+	}
+
+	s.nextID++
+	pending := &models.PendingUserAccess{
+		Token:          fmt.Sprintf("token-%d", s.nextID),
+		Email:          body.Email,
+		Scopes:         body.Scopes,
+		IsAdmin:        body.IsAdmin,
+		ExpirationDate: time.Now().Add(7 * 24 * time.Hour).Unix(),
+	}
+	t.pending = append(t.pending, pending)
+
+	writeJSON(w, http.StatusCreated, pending)
+}
+
+func (s *Server) listPendingTeammates(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	page := paginate(r, s.requestTenant(r).pending)
+
+	result := make([]models.PendingUserAccess, len(page))
+	for i, pending := range page {
+		result[i] = *pending
+	}
+
+	writeJSON(w, http.StatusOK, models.CommonResponse[[]models.PendingUserAccess]{Result: result})
+}
+
+func (s *Server) deletePendingTeammate(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t := s.requestTenant(r)
+	token := r.PathValue("token")
+
+	index := slices.IndexFunc(t.pending, func(pending *models.PendingUserAccess) bool {
+		return pending.Token == token
+	})
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "token", "invite not found")
+		return
+	}
+
+	t.pending = slices.Delete(t.pending, index, index+1)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) resendTeammateInvite(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t := s.requestTenant(r)
+	token := r.PathValue("token")
+
+	index := slices.IndexFunc(t.pending, func(pending *models.PendingUserAccess) bool {
+		return pending.Token == token
+	})
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "token", "invite not found")
+		return
+	}
+
+	t.pending[index].ExpirationDate = time.Now().Add(7 * 24 * time.Hour).Unix()
+
+	writeJSON(w, http.StatusOK, t.pending[index])
+}
+
+func (s *Server) getTeammate(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	teammate := s.findTeammate(s.requestTenant(r), r.PathValue("username"))
+	if teammate == nil {
+		writeError(w, http.StatusNotFound, "username", "teammate not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, teammate)
+}
+
+func (s *Server) updateTeammate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Scopes  []string `json:"scopes"`
+		IsAdmin bool     `json:"is_admin"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "", "invalid body")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	teammate := s.findTeammate(s.requestTenant(r), r.PathValue("username"))
+	if teammate == nil {
+		writeError(w, http.StatusNotFound, "username", "teammate not found")
+		return
+	}
+
+	teammate.IsAdmin = body.IsAdmin
+	teammate.Scopes = slices.Clone(body.Scopes)
+	if teammate.UserType != "owner" {
+		teammate.UserType = "teammate"
+		if body.IsAdmin {
+			teammate.UserType = "admin"
+		}
+	}
+
+	writeJSON(w, http.StatusOK, teammate)
+}
+
+func (s *Server) deleteTeammate(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	t := s.requestTenant(r)
+	username := r.PathValue("username")
+
+	index := slices.IndexFunc(t.teammates, func(teammate *models.TeammateScope) bool {
+		return teammate.Username == username
+	})
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "username", "teammate not found")
+		return
+	}
+
+	t.teammates = slices.Delete(t.teammates, index, index+1)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listSubuserAccess(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	username := r.PathValue("username")
+	if s.findTeammate(s.tenant(""), username) == nil {
+		writeError(w, http.StatusNotFound, "username", "teammate not found")
+		return
+	}
+
+	access, ok := s.subuserAccess[username]
+	if !ok {
+		access = &subuserAccess{}
+	}
+
+	limit := queryInt(r, "limit", defaultPageLimit)
+	after := queryInt(r, "after_subuser_id", 0)
+
+	var response models.TeammateSubuserResponse
+	response.HasRestrictedSubuserAccess = access.restricted
+
+	for _, subuser := range s.subusers {
+		if access.restricted && !slices.Contains(access.subusers, subuser.Id) {
+			continue
+		}
+
+		if subuser.Id <= after {
+			continue
+		}
+
+		if len(response.SubuserAccess) == limit {
+			response.Metadata.NextParams = models.NextParams{
+				Limit:          limit,
+				AfterSubuserId: response.SubuserAccess[limit-1].Id,
+				Username:       username,
+			}
+			break
+		}
+
+		permissionType := "admin"
+		if access.restricted {
+			permissionType = "restricted"
+		}
+
+		response.SubuserAccess = append(response.SubuserAccess, models.TeammateSubuser{
+			Id:             subuser.Id,
+			Username:       subuser.Username,
+			Email:          subuser.Email,
+			Disabled:       subuser.Disabled,
+			PermissionType: permissionType,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) listSubusers(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	page := paginate(r, s.subusers)
+
+	result := make([]models.Subuser, len(page))
+	for i, subuser := range page {
+		result[i] = *subuser
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) listSubuserReputations(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result := make([]models.SubuserReputation, 0)
+	for _, username := range r.URL.Query()["usernames"] {
+		if reputation, ok := s.reputations[username]; ok {
+			result = append(result, models.SubuserReputation{Username: username, Reputation: reputation})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) getSubuserCredits(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	username := r.PathValue("username")
+	if s.findSubuser(username) == nil {
+		writeError(w, http.StatusNotFound, "username", "subuser not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.credits[username])
+}
+
+func (s *Server) updateSubuserCredits(w http.ResponseWriter, r *http.Request) {
+	var body models.SubuserCreditsUpdate
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "", "invalid body")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	username := r.PathValue("username")
+	if s.findSubuser(username) == nil {
+		writeError(w, http.StatusNotFound, "username", "subuser not found")
+		return
+	}
+
+	credits := models.SubuserCredits{Type: body.Type}
+	switch body.Type {
+	case "unlimited":
+	case "recurring", "nonrecurring":
+		if body.Total == nil {
+			writeError(w, http.StatusBadRequest, "total", "total is required")
+			return
+		}
+
+		credits.Total = *body.Total
+		credits.Remain = *body.Total
+		if body.Type == "recurring" {
+			credits.ResetFrequency = body.ResetFrequency
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "type", "invalid type")
+		return
+	}
+
+	s.credits[username] = credits
+
+	writeJSON(w, http.StatusOK, credits)
+}
+
+// listSubuserMonthlyStats answers with the stats of the subusers which sent
+// mail, the same whichever the month.
+func (s *Server) listSubuserMonthlyStats(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	stats := make([]models.SubuserStats, 0)
+	for _, subuser := range s.subusers {
+		if metrics, ok := s.monthlyStats[subuser.Username]; ok {
+			stats = append(stats, models.SubuserStats{Name: subuser.Username, Type: "subuser", Metrics: metrics})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, models.SubuserMonthlyStats{
+		Date:  r.URL.Query().Get("date"),
+		Stats: paginate(r, stats),
+	})
+}
+
+func (s *Server) createSubuser(w http.ResponseWriter, r *http.Request) {
+	var body models.SubuserCreate
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Username == "" {
+		writeError(w, http.StatusBadRequest, "username", "invalid username")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.findSubuser(body.Username) != nil {
+		writeError(w, http.StatusBadRequest, "username", "username exists")
+		return
+	}
+
+	s.nextID++
+	subuser := &models.Subuser{
+		Id:       s.nextID,
+		Username: body.Username,
+		Email:    body.Email,
+	}
+	s.subusers = append(s.subusers, subuser)
+
+	writeJSON(w, http.StatusCreated, subuser)
+}
+
+func (s *Server) deleteSubuser(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	username := r.PathValue("username")
+
+	index := slices.IndexFunc(s.subusers, func(subuser *models.Subuser) bool {
+		return subuser.Username == username
+	})
+	if index < 0 {
+		writeError(w, http.StatusNotFound, "username", "subuser not found")
+		return
+	}
+
+	s.subusers = slices.Delete(s.subusers, index, index+1)
+	delete(s.tenants, username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) updateWebsiteAccess(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Disabled bool `json:"disabled"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "", "invalid body")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	subuser := s.findSubuser(r.PathValue("username"))
+	if subuser == nil {
+		writeError(w, http.StatusNotFound, "username", "subuser not found")
+		return
+	}
+
+	subuser.Disabled = body.Disabled
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listApiKeys(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, models.CommonResponse[[]models.ApiKey]{Result: paginate(r, s.requestTenant(r).apiKeys)})
+}
+
+func (s *Server) updateSubuserIPs(w http.ResponseWriter, r *http.Request) {
+	var ips []string
+
+	if err := json.NewDecoder(r.Body).Decode(&ips); err != nil {
+		writeError(w, http.StatusBadRequest, "", "invalid body")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	username := r.PathValue("username")
+	if s.findSubuser(username) == nil {
+		writeError(w, http.StatusNotFound, "username", "subuser not found")
+		return
+	}
+
+	for _, address := range ips {
+		if !slices.ContainsFunc(s.ips, func(ip *models.IPAddress) bool { return ip.Ip == address }) {
+			writeError(w, http.StatusBadRequest, "ips", fmt.Sprintf("unknown ip %s", address))
+			return
+		}
+	}
+
+	for _, ip := range s.ips {
+		ip.Subusers = slices.DeleteFunc(ip.Subusers, func(subuser string) bool {
+			return subuser == username
+		})
+
+		if slices.Contains(ips, ip.Ip) {
+			ip.Subusers = append(ip.Subusers, username)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, ips)
+}
+
+func (s *Server) listIPs(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	page := paginate(r, s.ips)
+
+	result := make([]models.IPAddress, len(page))
+	for i, ip := range page {
+		result[i] = *ip
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) listAssignedIPs(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result := make([]models.AssignedIPAddress, len(s.assignedIPs))
+	for i, ip := range s.assignedIPs {
+		result[i] = models.AssignedIPAddress{Ip: ip}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) findIP(address string) *models.IPAddress {
+	for _, ip := range s.ips {
+		if ip.Ip == address {
+			return ip
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) listIPPools(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	result := make([]models.IPPool, len(s.pools))
+	for i, name := range s.pools {
+		result[i] = models.IPPool{Name: name}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) createIPPool(w http.ResponseWriter, r *http.Request) {
+	var body models.IPPool
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Name == "" {
+		writeError(w, http.StatusBadRequest, "name", "invalid name")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if slices.Contains(s.pools, body.Name) {
+		writeError(w, http.StatusBadRequest, "name", "pool exists")
+		return
+	}
+
+	s.pools = append(s.pools, body.Name)
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+func (s *Server) getIPPool(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	name := r.PathValue("pool")
+	if !slices.Contains(s.pools, name) {
+		writeError(w, http.StatusNotFound, "pool", "pool not found")
+		return
+	}
+
+	result := models.IPPoolDetails{PoolName: name, Ips: make([]models.IPPoolAddress, 0)}
+	for _, ip := range s.ips {
+		if slices.Contains(ip.Pools, name) {
+			result.Ips = append(result.Ips, models.IPPoolAddress{Ip: ip.Ip, Warmup: ip.Warmup, StartDate: ip.StartDate})
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) deleteIPPool(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	name := r.PathValue("pool")
+	if !slices.Contains(s.pools, name) {
+		writeError(w, http.StatusNotFound, "pool", "pool not found")
+		return
+	}
+
+	s.pools = slices.DeleteFunc(s.pools, func(pool string) bool { return pool == name })
+	for _, ip := range s.ips {
+		ip.Pools = slices.DeleteFunc(ip.Pools, func(pool string) bool { return pool == name })
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) addIPToPool(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Ip string `json:"ip"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "ip", "invalid ip")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	name := r.PathValue("pool")
+	if !slices.Contains(s.pools, name) {
+		writeError(w, http.StatusNotFound, "pool", "pool not found")
+		return
+	}
+
+	ip := s.findIP(body.Ip)
+	if ip == nil {
+		writeError(w, http.StatusNotFound, "ip", "ip not found")
+		return
+	}
+
+	if !slices.Contains(ip.Pools, name) {
+		ip.Pools = append(ip.Pools, name)
+	}
+
+	writeJSON(w, http.StatusCreated, ip)
+}
+
+func (s *Server) removeIPFromPool(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	name := r.PathValue("pool")
+	ip := s.findIP(r.PathValue("ip"))
+	if ip == nil || !slices.Contains(ip.Pools, name) {
+		writeError(w, http.StatusNotFound, "ip", "ip not found in pool")
+		return
+	}
+
+	ip.Pools = slices.DeleteFunc(ip.Pools, func(pool string) bool { return pool == name })
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) findDomain(id int) *models.AuthenticatedDomain {
+	for _, d := range s.domains {
+		if d.Id == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func (s *Server) requestDomain(w http.ResponseWriter, r *http.Request) *models.AuthenticatedDomain {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err == nil {
+		if d := s.findDomain(id); d != nil {
+			return d
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "id", "authenticated domain not found")
+
+	return nil
+}
+
+func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	page := paginate(r, s.domains)
+
+	result := make([]models.AuthenticatedDomain, len(page))
+	for i, d := range page {
+		result[i] = *d
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) getDomain(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	d := s.requestDomain(w, r)
+	if d == nil {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (s *Server) addDomainSubuser(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "username", "invalid username")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	d := s.requestDomain(w, r)
+	if d == nil {
+		return
+	}
+
+	subuser := s.findSubuser(body.Username)
+	if subuser == nil {
+		writeError(w, http.StatusNotFound, "username", "subuser not found")
+		return
+	}
+
+	if !slices.ContainsFunc(d.Subusers, func(ds models.DomainSubuser) bool { return ds.Username == body.Username }) {
+		d.Subusers = append(d.Subusers, models.DomainSubuser{UserId: subuser.Id, Username: subuser.Username})
+	}
+
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (s *Server) removeDomainSubuser(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	d := s.requestDomain(w, r)
+	if d == nil {
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if !slices.ContainsFunc(d.Subusers, func(ds models.DomainSubuser) bool { return ds.Username == username }) {
+		writeError(w, http.StatusNotFound, "username", "subuser not associated with the domain")
+		return
+	}
+
+	d.Subusers = slices.DeleteFunc(d.Subusers, func(ds models.DomainSubuser) bool { return ds.Username == username })
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) listLinks(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	page := paginate(r, s.links)
+
+	result := make([]models.BrandedLink, len(page))
+	for i, l := range page {
+		result[i] = *l
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) getSubuserLink(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id, ok := s.subuserLinks[r.URL.Query().Get("username")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "username", "no branded link associated with the subuser")
+		return
+	}
+
+	for _, l := range s.links {
+		if l.Id == id {
+			writeJSON(w, http.StatusOK, l)
+			return
+		}
+	}
+
+	writeError(w, http.StatusNotFound, "username", "no branded link associated with the subuser")
+}
+
+func (s *Server) associateSubuserLink(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string `json:"username"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "username", "invalid username")
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || !slices.ContainsFunc(s.links, func(l *models.BrandedLink) bool { return l.Id == id }) {
+		writeError(w, http.StatusNotFound, "id", "branded link not found")
+		return
+	}
+
+	if s.findSubuser(body.Username) == nil {
+		writeError(w, http.StatusNotFound, "username", "subuser not found")
+		return
+	}
+
+	s.subuserLinks[body.Username] = id
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) disassociateSubuserLink(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	username := r.URL.Query().Get("username")
+	if _, ok := s.subuserLinks[username]; !ok {
+		writeError(w, http.StatusNotFound, "username", "no branded link associated with the subuser")
+		return
+	}
+
+	delete(s.subuserLinks, username)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listVerifiedSenders pages the verified senders by the ID of the last
+// sender seen, which they are sorted by.
+func (s *Server) listVerifiedSenders(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	limit := queryInt(r, "limit", defaultPageLimit)
+	lastSeenID := queryInt(r, "lastSeenID", 0)
+
+	result := models.VerifiedSendersResponse{Results: make([]models.VerifiedSender, 0)}
+	for _, sender := range s.senders {
+		if sender.Id > lastSeenID && len(result.Results) < limit {
+			result.Results = append(result.Results, *sender)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) deleteVerifiedSender(w http.ResponseWriter, r *http.Request) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || !slices.ContainsFunc(s.senders, func(sender *models.VerifiedSender) bool { return sender.Id == id }) {
+		writeError(w, http.StatusNotFound, "id", "verified sender not found")
+		return
+	}
+
+	s.senders = slices.DeleteFunc(s.senders, func(sender *models.VerifiedSender) bool { return sender.Id == id })
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// paginate returns the page of items selected by the limit and offset
+// query parameters.
+func paginate[T any](r *http.Request, items []T) []T {
+	limit := queryInt(r, "limit", defaultPageLimit)
+	offset := queryInt(r, "offset", 0)
+
+	if offset >= len(items) {
+		return nil
+	}
+
+	return items[offset:min(offset+limit, len(items))]
+}
+
+func queryInt(r *http.Request, name string, defaultValue int) int {
+	value, err := strconv.Atoi(r.URL.Query().Get(name))
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, field string, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"errors": []map[string]string{
+			{"field": field, "message": message},
+		},
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"slices"
 
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
@@ -23,28 +24,74 @@ type SendGridClient interface {
 
 	GetSpecificTeammate(ctx context.Context, username string) (*models.TeammateScope, error)
 	GetTeammates(ctx context.Context, pToken *pagination.Token) ([]models.Teammate, string, error)
-	GetTeammatesSubAccess(ctx context.Context, username string, pToken *pagination.Token) ([]models.TeammateSubuser, string, error)
+	GetTeammatesSubAccess(ctx context.Context, username string, pToken *pagination.Token) (*models.TeammateSubuserResponse, string, error)
 	GetPendingTeammates(ctx context.Context, pToken *pagination.Token) ([]models.PendingUserAccess, string, error)
+	DeletePendingTeammate(ctx context.Context, token string) error
+	ResendTeammateInvite(ctx context.Context, token string) error
 	SetTeammateScopes(ctx context.Context, username string, scopes []string, isAdmin bool) error
 
 	GetSubusers(ctx context.Context, pToken *pagination.Token) ([]models.Subuser, string, error)
 	CreateSubuser(ctx context.Context, subuser models.SubuserCreate) error
 	DeleteSubuser(ctx context.Context, username string) error
 	SetSubuserDisabled(ctx context.Context, username string, disabled bool) error
+	GetSubuserReputations(ctx context.Context, usernames []string) ([]models.SubuserReputation, error)
+	GetSubuserCredits(ctx context.Context, username string) (*models.SubuserCredits, error)
+	UpdateSubuserCredits(ctx context.Context, username string, credits models.SubuserCreditsUpdate) error
+	GetSubuserMonthlyStats(ctx context.Context, date string, pToken *pagination.Token) ([]models.SubuserStats, string, error)
+
+	GetApiKeys(ctx context.Context, pToken *pagination.Token) ([]models.ApiKey, string, error)
+
+	GetIPs(ctx context.Context, pToken *pagination.Token) ([]models.IPAddress, string, error)
+	GetAssignedIPs(ctx context.Context) ([]models.AssignedIPAddress, error)
+	SetSubuserIPs(ctx context.Context, username string, ips []string) error
+
+	GetIPPools(ctx context.Context) ([]models.IPPool, error)
+	GetIPPool(ctx context.Context, name string) (*models.IPPoolDetails, error)
+	CreateIPPool(ctx context.Context, name string) error
+	DeleteIPPool(ctx context.Context, name string) error
+	AddIPToPool(ctx context.Context, pool string, ip string) error
+	RemoveIPFromPool(ctx context.Context, pool string, ip string) error
+
+	GetAuthenticatedDomains(ctx context.Context, pToken *pagination.Token) ([]models.AuthenticatedDomain, string, error)
+	GetAuthenticatedDomain(ctx context.Context, id int) (*models.AuthenticatedDomain, error)
+	AddAuthenticatedDomainToSubuser(ctx context.Context, id int, username string) error
+	RemoveAuthenticatedDomainFromSubuser(ctx context.Context, id int, username string) error
+
+	GetBrandedLinks(ctx context.Context) ([]models.BrandedLink, error)
+	GetSubuserBrandedLink(ctx context.Context, username string) (*models.BrandedLink, error)
+	AssociateBrandedLinkWithSubuser(ctx context.Context, id int, username string) error
+	DisassociateBrandedLinkFromSubuser(ctx context.Context, username string) error
+
+	GetVerifiedSenders(ctx context.Context, pToken *pagination.Token) ([]models.VerifiedSender, string, error)
+	DeleteVerifiedSender(ctx context.Context, id int) error
+
+	GetUsername(ctx context.Context) (*models.UserUsername, error)
+	GetUserAccount(ctx context.Context) (*models.UserAccount, error)
+	GetUserProfile(ctx context.Context) (*models.UserProfile, error)
+	GetUserEmail(ctx context.Context) (*models.UserEmail, error)
 }
 
 type Connector struct {
-	client         SendGridClient
-	scopeCache     *scopeCache
+	accounts       *accountSet
 	ignoreSubusers bool
 }
 
 // ResourceSyncers returns a ResourceSyncer for each resource type that should be synced from the upstream service.
 func (d *Connector) ResourceSyncers(ctx context.Context) []connectorbuilder.ResourceSyncer {
 	return []connectorbuilder.ResourceSyncer{
-		newTeammateBuilder(d.client),
-		newScopeBuilder(d.client, d.scopeCache),
-		newSubuserBuilder(d.client, d.ignoreSubusers),
+		newAccountBuilder(d.accounts),
+		newTeammateBuilder(d.accounts),
+		newInviteBuilder(d.accounts),
+		newScopeCategoryBuilder(d.accounts),
+		newScopeBuilder(d.accounts),
+		newSubuserBuilder(d.accounts, d.ignoreSubusers),
+		newApiKeyBuilder(d.accounts),
+		newCreditAllocationBuilder(d.accounts),
+		newIPAddressBuilder(d.accounts, d.ignoreSubusers),
+		newIPPoolBuilder(d.accounts),
+		newAuthenticatedDomainBuilder(d.accounts, d.ignoreSubusers),
+		newBrandedLinkBuilder(d.accounts, d.ignoreSubusers),
+		newVerifiedSenderBuilder(d.accounts),
 	}
 }
 
@@ -65,18 +112,47 @@ func (d *Connector) Metadata(ctx context.Context) (*v2.ConnectorMetadata, error)
 // Validate is called to ensure that the connector is properly configured. It should exercise any API credentials
 // to be sure that they are valid.
 func (d *Connector) Validate(ctx context.Context) (annotations.Annotations, error) {
+	_, err := d.accounts.all(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	return nil, nil
 }
 
-// New returns a new instance of the connector.
-func New(ctx context.Context, client SendGridClient, ignoreSubusers bool) (*Connector, error) {
-	if client == nil {
+// Option configures the connector.
+type Option func(*Connector)
+
+// WithTeammateCache persists the scopes of the teammates in the cache, so
+// syncs only fetch the scopes of the teammates which changed.
+func WithTeammateCache(cache *TeammateCache) Option {
+	return func(d *Connector) {
+		d.accounts.teammates = cache
+	}
+}
+
+// WithDryRun tells the connector its clients only log mutating requests, so
+// it does not expect SendGrid to reflect them.
+func WithDryRun(dryRun bool) Option {
+	return func(d *Connector) {
+		d.accounts.dryRun = dryRun
+	}
+}
+
+// New returns a new instance of the connector syncing one SendGrid account per client.
+func New(ctx context.Context, clients []SendGridClient, ignoreSubusers bool, opts ...Option) (*Connector, error) {
+	if len(clients) == 0 || slices.Contains(clients, nil) {
 		return nil, ErrSendgridClientNotProvided
 	}
 
-	return &Connector{
-		client:         client,
-		scopeCache:     newScopeCache(client),
+	d := &Connector{
+		accounts:       newAccountSet(clients),
 		ignoreSubusers: ignoreSubusers,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d, nil
 }
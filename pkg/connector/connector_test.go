@@ -0,0 +1,2010 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client/sendgridtest"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/types"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// syncResult is the resource graph produced by a full sync.
+type syncResult struct {
+	resources    map[string]*v2.Resource
+	entitlements map[string]*v2.Entitlement
+	// grants holds "entitlement ID -> principal type:id" edges, expandable
+	// grants being suffixed with " (expandable)".
+	grants []string
+}
+
+func (r *syncResult) hasGrant(edge string) bool {
+	return slices.Contains(r.grants, edge)
+}
+
+func resourceKey(id *v2.ResourceId) string {
+	return fmt.Sprintf("%s:%s", id.ResourceType, id.Resource)
+}
+
+func newTestConnector(t *testing.T, ignoreSubusers bool, clients ...SendGridClient) types.ConnectorServer {
+	t.Helper()
+
+	ctx := context.Background()
+
+	cb, err := New(ctx, clients, ignoreSubusers)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	cs, err := connectorbuilder.NewConnector(ctx, cb)
+	if err != nil {
+		t.Fatalf("NewConnector: %v", err)
+	}
+
+	return cs
+}
+
+// fullSync walks the connector the way the baton syncer does: every resource
+// type from the top, then child resource types under their parents, then
+// entitlements and grants of every resource, following every page.
+func fullSync(t *testing.T, cs types.ConnectorServer) *syncResult {
+	t.Helper()
+
+	ctx := context.Background()
+	result := &syncResult{
+		resources:    make(map[string]*v2.Resource),
+		entitlements: make(map[string]*v2.Entitlement),
+	}
+
+	rts, err := cs.ListResourceTypes(ctx, &v2.ResourceTypesServiceListResourceTypesRequest{})
+	if err != nil {
+		t.Fatalf("ListResourceTypes: %v", err)
+	}
+
+	type listAction struct {
+		resourceType string
+		parent       *v2.ResourceId
+	}
+
+	var queue []listAction
+	for _, rt := range rts.List {
+		queue = append(queue, listAction{resourceType: rt.Id})
+	}
+
+	var order []string
+	for len(queue) > 0 {
+		action := queue[0]
+		queue = queue[1:]
+
+		pageToken := ""
+		for {
+			resp, err := cs.ListResources(ctx, &v2.ResourcesServiceListResourcesRequest{
+				ResourceTypeId:   action.resourceType,
+				ParentResourceId: action.parent,
+				PageToken:        pageToken,
+			})
+			if err != nil {
+				t.Fatalf("ListResources %s: %v", action.resourceType, err)
+			}
+
+			for _, r := range resp.List {
+				key := resourceKey(r.Id)
+				if _, ok := result.resources[key]; ok {
+					t.Fatalf("resource %s listed twice", key)
+				}
+				result.resources[key] = r
+				order = append(order, key)
+
+				for _, a := range r.Annotations {
+					crt := &v2.ChildResourceType{}
+					if a.MessageIs(crt) {
+						if err := a.UnmarshalTo(crt); err != nil {
+							t.Fatal(err)
+						}
+						queue = append(queue, listAction{resourceType: crt.ResourceTypeId, parent: r.Id})
+					}
+				}
+			}
+
+			pageToken = resp.NextPageToken
+			if pageToken == "" {
+				break
+			}
+		}
+	}
+
+	grantIDs := make(map[string]bool)
+	for _, key := range order {
+		r := result.resources[key]
+
+		pageToken := ""
+		for {
+			resp, err := cs.ListEntitlements(ctx, &v2.EntitlementsServiceListEntitlementsRequest{Resource: r, PageToken: pageToken})
+			if err != nil {
+				t.Fatalf("ListEntitlements %s: %v", key, err)
+			}
+
+			for _, e := range resp.List {
+				result.entitlements[e.Id] = e
+			}
+
+			pageToken = resp.NextPageToken
+			if pageToken == "" {
+				break
+			}
+		}
+
+		pageToken = ""
+		for {
+			resp, err := cs.ListGrants(ctx, &v2.GrantsServiceListGrantsRequest{Resource: r, PageToken: pageToken})
+			if err != nil {
+				t.Fatalf("ListGrants %s: %v", key, err)
+			}
+
+			for _, g := range resp.List {
+				if grantIDs[g.Id] {
+					t.Fatalf("grant %s emitted twice", g.Id)
+				}
+				grantIDs[g.Id] = true
+
+				edge := fmt.Sprintf("%s -> %s", g.Entitlement.Id, resourceKey(g.Principal.Id))
+				annos := annotations.Annotations(g.Annotations)
+				if annos.Contains(&v2.GrantExpandable{}) {
+					edge += " (expandable)"
+				}
+				result.grants = append(result.grants, edge)
+			}
+
+			pageToken = resp.NextPageToken
+			if pageToken == "" {
+				break
+			}
+		}
+	}
+
+	sort.Strings(result.grants)
+
+	return result
+}
+
+func TestSync(t *testing.T) {
+	testCases := []struct {
+		name           string
+		clients        func() []SendGridClient
+		ignoreSubusers bool
+		resources      []string
+		noResources    []string
+		grants         []string
+		noGrants       []string
+	}{
+		{
+			name: "single account",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addTeammate("", "owner", ownerUserType).
+						addTeammate("", "alice", "teammate", "alerts.read", "alerts.create").
+						addTeammate("", "bob", "teammate", "alerts.read", "alerts.create", "alerts.update", "alerts.delete").
+						addSubuser(10, "sub").
+						addSubuser(11, "other").
+						addSubuserAccess("alice", "restricted", 10).
+						addSubuserAccess("bob", "restricted", 10).
+						addSubuserAccess("bob", "admin", 11),
+				}
+			},
+			resources: []string{
+				"account:1",
+				"teammate:1:owner",
+				"teammate:1:alice",
+				"teammate:1:bob",
+				"scope_category:1:alerts",
+				"scope_category:1:ips.pools",
+				"scope:1:alerts.read",
+				"scope:1:ips.pools.read",
+				"subuser:1:10",
+			},
+			grants: []string{
+				"account:1:owner -> teammate:1:owner",
+				"scope:1:alerts.read:assigned -> teammate:1:alice",
+				"scope:1:alerts.read:assigned -> teammate:1:bob",
+				"scope:1:alerts.create:assigned -> teammate:1:alice",
+				"scope:1:alerts.read:assigned -> scope_category:1:alerts (expandable)",
+				"scope_category:1:alerts:all -> teammate:1:bob",
+				"subuser:1:10:restricted -> teammate:1:alice",
+				"subuser:1:10:restricted -> teammate:1:bob",
+				"subuser:1:11:admin -> teammate:1:bob",
+			},
+			noGrants: []string{
+				"scope_category:1:alerts:all -> teammate:1:alice",
+				"scope:1:alerts.update:assigned -> teammate:1:alice",
+				"subuser:1:11:restricted -> teammate:1:alice",
+			},
+		},
+		{
+			name: "multiple accounts",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "first").addTeammate("", "alice", "teammate", "alerts.read"),
+					newMemoryClient(2, "second").addTeammate("", "alice", "teammate", "billing.read"),
+				}
+			},
+			resources: []string{
+				"account:1",
+				"account:2",
+				"teammate:1:alice",
+				"teammate:2:alice",
+				"scope:1:alerts.read",
+				"scope:2:alerts.read",
+			},
+			grants: []string{
+				"scope:1:alerts.read:assigned -> teammate:1:alice",
+				"scope:2:billing.read:assigned -> teammate:2:alice",
+			},
+			noGrants: []string{
+				"scope:2:alerts.read:assigned -> teammate:2:alice",
+				"scope:1:billing.read:assigned -> teammate:1:alice",
+			},
+		},
+		{
+			name: "subuser resources",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addSubuser(10, "sub").
+						addTeammate("sub", "carol", "teammate", "alerts.read").
+						addApiKey("sub", "key-1", "sub key").
+						addApiKey("", "key-2", "parent key"),
+				}
+			},
+			resources: []string{
+				"subuser:1:10",
+				"teammate:1:10/carol",
+				"api_key:1:10/key-1",
+			},
+			noResources: []string{
+				"teammate:1:carol",
+				"api_key:1:key-2",
+			},
+			noGrants: []string{
+				"scope:1:alerts.read:assigned -> teammate:1:10/carol",
+			},
+		},
+		{
+			name: "unrestricted subuser access",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addTeammate("", "alice", "admin").
+						addTeammate("", "bob", "teammate").
+						addTeammate("", "carol", "teammate").
+						addSubuser(10, "sub").
+						addSubuser(11, "other").
+						addUnrestrictedSubuserAccess("alice").
+						addUnrestrictedSubuserAccess("bob").
+						addSubuserAccess("carol", "restricted", 10),
+				}
+			},
+			grants: []string{
+				"subuser:1:10:admin -> teammate:1:alice",
+				"subuser:1:11:admin -> teammate:1:alice",
+				"subuser:1:10:restricted -> teammate:1:bob",
+				"subuser:1:11:restricted -> teammate:1:bob",
+				"subuser:1:10:restricted -> teammate:1:carol",
+			},
+			noGrants: []string{
+				"subuser:1:11:restricted -> teammate:1:carol",
+				"subuser:1:10:restricted -> teammate:1:alice",
+			},
+		},
+		{
+			name: "ip addresses",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addSubuser(10, "sub").
+						addSubuser(11, "other").
+						addIP("1.1.1.1", true, "sub", "other").
+						addIP("2.2.2.2", false).
+						addIP("2001:db8::1", true, "other"),
+				}
+			},
+			resources: []string{
+				"ip_address:1:1.1.1.1",
+				"ip_address:1:2.2.2.2",
+				"ip_address:1:2001:db8::1",
+			},
+			grants: []string{
+				"ip_address:1:1.1.1.1:assigned -> subuser:1:10",
+				"ip_address:1:1.1.1.1:assigned -> subuser:1:11",
+				"ip_address:1:2001:db8::1:assigned -> subuser:1:11",
+			},
+			noGrants: []string{
+				"ip_address:1:2001:db8::1:assigned -> subuser:1:10",
+			},
+		},
+		{
+			name: "ip pools",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addIP("1.1.1.1", true).
+						addIP("2.2.2.2", true).
+						addIPPool("transactional", "1.1.1.1", "2.2.2.2").
+						addIPPool("marketing", "2.2.2.2").
+						addIPPool("empty"),
+				}
+			},
+			resources: []string{
+				"ip_pool:1:transactional",
+				"ip_pool:1:marketing",
+				"ip_pool:1:empty",
+			},
+			grants: []string{
+				"ip_pool:1:transactional:member -> ip_address:1:1.1.1.1",
+				"ip_pool:1:transactional:member -> ip_address:1:2.2.2.2",
+				"ip_pool:1:marketing:member -> ip_address:1:2.2.2.2",
+			},
+			noGrants: []string{
+				"ip_pool:1:marketing:member -> ip_address:1:1.1.1.1",
+			},
+		},
+		{
+			name: "sender domains",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addSubuser(10, "sub").
+						addSubuser(11, "other").
+						addDomain(100, "example.com", "sub", "other").
+						addDomain(101, "example.org").
+						addBrandedLink(200, "example.com", "sub").
+						addBrandedLink(201, "example.org"),
+				}
+			},
+			resources: []string{
+				"authenticated_domain:1:100",
+				"authenticated_domain:1:101",
+				"branded_link:1:200",
+				"branded_link:1:201",
+			},
+			grants: []string{
+				"authenticated_domain:1:100:sender -> subuser:1:10",
+				"authenticated_domain:1:100:sender -> subuser:1:11",
+				"branded_link:1:200:sender -> subuser:1:10",
+			},
+			noGrants: []string{
+				"authenticated_domain:1:101:sender -> subuser:1:10",
+				"branded_link:1:200:sender -> subuser:1:11",
+				"branded_link:1:201:sender -> subuser:1:10",
+			},
+		},
+		{
+			name: "verified senders",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addVerifiedSender(10, "news@example.com").
+						addVerifiedSender(20, "billing@example.com").
+						addVerifiedSender(30, "support@example.com"),
+				}
+			},
+			resources: []string{
+				"verified_sender:1:10",
+				"verified_sender:1:20",
+				"verified_sender:1:30",
+			},
+		},
+		{
+			name: "pending invites",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addInvite("token-1", "bob@example.com", time.Now().Add(24*time.Hour), "alerts.read").
+						addInvite("token-2", "carol@example.com", time.Now().Add(-24*time.Hour)),
+				}
+			},
+			resources: []string{
+				"invite:1:" + inviteLocalID("token-1"),
+				"invite:1:" + inviteLocalID("token-2"),
+			},
+		},
+		{
+			name: "credit allocations",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addSubuser(10, "limited").
+						addSubuser(11, "unlimited").
+						setSubuserUsage("limited", models.SubuserUsage{
+							Credits: &models.SubuserCredits{Type: "recurring", ResetFrequency: "weekly", Total: 500},
+						}).
+						setSubuserUsage("unlimited", models.SubuserUsage{
+							Credits: &models.SubuserCredits{Type: "unlimited"},
+						}),
+				}
+			},
+			resources: []string{
+				"credit_allocation:1:10/credits",
+				"credit_allocation:1:11/credits",
+			},
+			grants: []string{
+				"credit_allocation:1:10/credits:recurring -> subuser:1:10",
+				"credit_allocation:1:11/credits:unlimited -> subuser:1:11",
+			},
+			noGrants: []string{
+				"credit_allocation:1:10/credits:unlimited -> subuser:1:10",
+				"credit_allocation:1:11/credits:unlimited -> subuser:1:10",
+			},
+		},
+		{
+			name: "ignore subusers",
+			clients: func() []SendGridClient {
+				return []SendGridClient{
+					newMemoryClient(1, "owner").
+						addSubuser(10, "sub").
+						addTeammate("sub", "carol", "teammate"),
+				}
+			},
+			ignoreSubusers: true,
+			resources: []string{
+				"account:1",
+			},
+			noResources: []string{
+				"subuser:1:10",
+				"teammate:1:10/carol",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := fullSync(t, newTestConnector(t, tc.ignoreSubusers, tc.clients()...))
+
+			for _, key := range tc.resources {
+				if _, ok := result.resources[key]; !ok {
+					t.Errorf("expected resource %s", key)
+				}
+			}
+
+			for _, key := range tc.noResources {
+				if _, ok := result.resources[key]; ok {
+					t.Errorf("unexpected resource %s", key)
+				}
+			}
+
+			for _, edge := range tc.grants {
+				if !result.hasGrant(edge) {
+					t.Errorf("expected grant %s", edge)
+				}
+			}
+
+			for _, edge := range tc.noGrants {
+				if result.hasGrant(edge) {
+					t.Errorf("unexpected grant %s", edge)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncIgnoreSubusersSkipsSubuserAccess(t *testing.T) {
+	c := newMemoryClient(1, "owner").
+		addTeammate("", "alice", "teammate").
+		addSubuser(10, "sub").
+		addSubuserAccess("alice", "restricted", 10).
+		addBrandedLink(200, "example.com", "sub")
+
+	fullSync(t, newTestConnector(t, true, c))
+
+	if c.called("GetTeammatesSubAccess") {
+		t.Fatal("expected no subuser access lookups when subusers are ignored")
+	}
+
+	if c.called("GetSubuserBrandedLink") {
+		t.Fatal("expected no branded link lookups when subusers are ignored")
+	}
+}
+
+func TestSubuserAccessOfUnlistedTeammate(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryClient(1, "owner").
+		addTeammate("", "alice", "teammate").
+		addSubuser(10, "sub").
+		addSubuserAccess("alice", subuserRestrictedEntitlement, 10).
+		// Deleted since, its access is still reported.
+		addSubuserAccess("bob", subuserAdminEntitlement, 10)
+	subuser := fullSync(t, newTestConnector(t, false, c)).resources["subuser:1:10"]
+
+	// The grants are synced by a fresh connector, which has not listed the
+	// teammates.
+	cs := newTestConnector(t, false, c)
+	_, err := cs.ListResources(ctx, &v2.ResourcesServiceListResourcesRequest{
+		ResourceTypeId:   subuserResourceType.Id,
+		ParentResourceId: subuser.ParentResourceId,
+	})
+	if err != nil {
+		t.Fatalf("ListResources: %v", err)
+	}
+
+	resp, err := cs.ListGrants(ctx, &v2.GrantsServiceListGrantsRequest{Resource: subuser})
+	if err != nil {
+		t.Fatalf("ListGrants: %v", err)
+	}
+
+	var principals []string
+	for _, g := range resp.List {
+		principals = append(principals, resourceKey(g.Principal.Id))
+	}
+
+	if !slices.Equal(principals, []string{"teammate:1:alice"}) {
+		t.Fatalf("expected the grants to go to existing teammates only, got %v", principals)
+	}
+
+	if !c.called("GetSpecificTeammate alice") {
+		t.Fatal("expected the unlisted teammate to be looked up")
+	}
+}
+
+func TestSyncEntitlements(t *testing.T) {
+	c := newMemoryClient(1, "owner").
+		addTeammate("", "alice", "teammate").
+		addSubuser(10, "sub").
+		addDomain(100, "example.com").
+		addBrandedLink(200, "example.com")
+	result := fullSync(t, newTestConnector(t, false, c))
+
+	for _, id := range []string{
+		"account:1:owner",
+		"scope_category:1:alerts:all",
+		"scope:1:alerts.read:assigned",
+		"subuser:1:10:admin",
+		"subuser:1:10:restricted",
+		"authenticated_domain:1:100:sender",
+		"branded_link:1:200:sender",
+	} {
+		if _, ok := result.entitlements[id]; !ok {
+			t.Errorf("expected entitlement %s", id)
+		}
+	}
+
+	scopes := 0
+	for key := range result.resources {
+		if result.resources[key].Id.ResourceType == scopeResourceType.Id {
+			scopes++
+		}
+	}
+
+	if scopes != len(SendGridScopes) {
+		t.Errorf("expected %d scopes, got %d", len(SendGridScopes), scopes)
+	}
+}
+
+func teammateID(accountID string, username string) *v2.Resource {
+	return &v2.Resource{Id: &v2.ResourceId{ResourceType: teammateResourceType.Id, Resource: newAccountScopedID(accountID, username)}}
+}
+
+func entitlementOf(resourceType *v2.ResourceType, id string, slug string) *v2.Entitlement {
+	resource := &v2.Resource{Id: &v2.ResourceId{ResourceType: resourceType.Id, Resource: id}}
+
+	return &v2.Entitlement{
+		Id:       fmt.Sprintf("%s:%s:%s", resourceType.Id, id, slug),
+		Resource: resource,
+		Slug:     slug,
+	}
+}
+
+func TestGrant(t *testing.T) {
+	testCases := []struct {
+		name        string
+		principal   *v2.Resource
+		entitlement *v2.Entitlement
+		wantErr     bool
+		wantExists  bool
+		// wantScopes are the scopes teammate ends up with, alice unless
+		// set.
+		teammate   string
+		wantScopes []string
+	}{
+		{
+			name:        "scope",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.create", assignedEntitlement),
+			wantScopes:  []string{"alerts.read", "alerts.create"},
+		},
+		{
+			name:        "scope already granted",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.read", assignedEntitlement),
+			wantExists:  true,
+			wantScopes:  []string{"alerts.read"},
+		},
+		{
+			name:        "scope category",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeCategoryResourceType, "1:alerts", allScopesEntitlement),
+			wantScopes:  []string{"alerts.read", "alerts.create", "alerts.delete", "alerts.update"},
+		},
+		{
+			name:        "scope requiring its read scope",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:ips.pools.update", assignedEntitlement),
+			wantScopes:  []string{"alerts.read", "ips.pools.read", "ips.pools.update"},
+		},
+		{
+			name:        "unknown scope",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.launch", assignedEntitlement),
+			wantErr:     true,
+			wantScopes:  []string{"alerts.read"},
+		},
+		{
+			name:        "admin only scope to a restricted teammate",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:billing.read", assignedEntitlement),
+			wantErr:     true,
+			wantScopes:  []string{"alerts.read"},
+		},
+		{
+			name:        "admin only scope category to a restricted teammate",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeCategoryResourceType, "1:billing", allScopesEntitlement),
+			wantErr:     true,
+			wantScopes:  []string{"alerts.read"},
+		},
+		{
+			name:        "admin only scope to an admin",
+			principal:   teammateID("1", "root"),
+			entitlement: entitlementOf(scopeResourceType, "1:billing.update", assignedEntitlement),
+			teammate:    "root",
+			wantScopes:  []string{"billing.read", "billing.update"},
+		},
+		{
+			name:        "principal is not a teammate",
+			principal:   &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: "1:10"}},
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.create", assignedEntitlement),
+			wantErr:     true,
+			wantScopes:  []string{"alerts.read"},
+		},
+		{
+			name:        "teammate of another account",
+			principal:   teammateID("2", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.create", assignedEntitlement),
+			wantErr:     true,
+			wantScopes:  []string{"alerts.read"},
+		},
+		{
+			name:        "teammate of a subuser",
+			principal:   teammateID("1", "10/alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.create", assignedEntitlement),
+			wantErr:     true,
+			wantScopes:  []string{"alerts.read"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").
+				addTeammate("", "alice", "teammate", "alerts.read").
+				addTeammate("", "root", "admin")
+			cs := newTestConnector(t, false, c)
+
+			resp, err := cs.Grant(context.Background(), &v2.GrantManagerServiceGrantRequest{
+				Principal:   tc.principal,
+				Entitlement: tc.entitlement,
+			})
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Grant: %v", err)
+				}
+
+				annos := annotations.Annotations(resp.Annotations)
+				exists := annos.Contains(&v2.GrantAlreadyExists{})
+				if exists != tc.wantExists {
+					t.Errorf("expected GrantAlreadyExists %t, got %t", tc.wantExists, exists)
+				}
+
+				if !tc.wantExists && len(resp.Grants) != 1 {
+					t.Errorf("expected a single grant, got %d", len(resp.Grants))
+				}
+			}
+
+			teammate := tc.teammate
+			if teammate == "" {
+				teammate = "alice"
+			}
+
+			if got := c.scopesOf(teammate); !slices.Equal(got, tc.wantScopes) {
+				t.Errorf("expected scopes %v, got %v", tc.wantScopes, got)
+			}
+		})
+	}
+}
+
+func TestGrantScopeCategoryWithAdminOnlyScopes(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryClient(1, "owner").
+		addTeammate("", "alice", "teammate").
+		addTeammate("", "root", "admin")
+	cs := newTestConnector(t, false, c)
+
+	entitlement := entitlementOf(scopeCategoryResourceType, "1:user", allScopesEntitlement)
+	for _, teammate := range []string{"alice", "root"} {
+		_, err := cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+			Principal:   teammateID("1", teammate),
+			Entitlement: entitlement,
+		})
+		if err != nil {
+			t.Fatalf("Grant %s: %v", teammate, err)
+		}
+	}
+
+	for _, scope := range ScopesForCategory("user") {
+		info, _ := LookupScope(scope)
+
+		if held := slices.Contains(c.scopesOf("alice"), string(scope)); held == info.AdminOnly {
+			t.Errorf("expected the restricted teammate to hold %s %t, got %t", scope, !info.AdminOnly, held)
+		}
+
+		if !slices.Contains(c.scopesOf("root"), string(scope)) {
+			t.Errorf("expected the admin to hold %s", scope)
+		}
+	}
+
+	result := fullSync(t, cs)
+	for _, teammate := range []string{"alice", "root"} {
+		edge := "scope_category:1:user:all -> teammate:1:" + teammate
+		if !slices.Contains(result.grants, edge) {
+			t.Errorf("expected %s, got %v", edge, result.grants)
+		}
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	testCases := []struct {
+		name        string
+		principal   *v2.Resource
+		entitlement *v2.Entitlement
+		wantErr     bool
+		wantRevoked bool
+		wantScopes  []string
+	}{
+		{
+			name:        "scope",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.read", assignedEntitlement),
+			wantScopes:  []string{"alerts.create", "billing.read"},
+		},
+		{
+			name:        "scope already revoked",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.update", assignedEntitlement),
+			wantRevoked: true,
+			wantScopes:  []string{"alerts.read", "alerts.create", "billing.read"},
+		},
+		{
+			name:        "scope category",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(scopeCategoryResourceType, "1:alerts", allScopesEntitlement),
+			wantScopes:  []string{"billing.read"},
+		},
+		{
+			name:        "teammate of another account",
+			principal:   teammateID("2", "alice"),
+			entitlement: entitlementOf(scopeResourceType, "1:alerts.read", assignedEntitlement),
+			wantErr:     true,
+			wantScopes:  []string{"alerts.read", "alerts.create", "billing.read"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").addTeammate("", "alice", "teammate", "alerts.read", "alerts.create", "billing.read")
+			cs := newTestConnector(t, false, c)
+
+			resp, err := cs.Revoke(context.Background(), &v2.GrantManagerServiceRevokeRequest{
+				Grant: &v2.Grant{
+					Entitlement: tc.entitlement,
+					Principal:   tc.principal,
+				},
+			})
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("Revoke: %v", err)
+				}
+
+				annos := annotations.Annotations(resp.Annotations)
+				revoked := annos.Contains(&v2.GrantAlreadyRevoked{})
+				if revoked != tc.wantRevoked {
+					t.Errorf("expected GrantAlreadyRevoked %t, got %t", tc.wantRevoked, revoked)
+				}
+			}
+
+			if got := c.scopesOf("alice"); !slices.Equal(got, tc.wantScopes) {
+				t.Errorf("expected scopes %v, got %v", tc.wantScopes, got)
+			}
+		})
+	}
+}
+
+func TestRevokeGuardrails(t *testing.T) {
+	testCases := []struct {
+		name      string
+		client    func() *memoryClient
+		principal string
+		wantCode  codes.Code
+		wantAdmin bool
+	}{
+		{
+			name: "account owner",
+			client: func() *memoryClient {
+				return newMemoryClient(1, "owner").
+					addTeammate("", "owner", ownerUserType, "alerts.read").
+					addTeammate("", "alice", "admin", "alerts.read")
+			},
+			principal: "owner",
+			wantCode:  codes.FailedPrecondition,
+			wantAdmin: true,
+		},
+		{
+			name: "last admin",
+			client: func() *memoryClient {
+				return newMemoryClient(1, "owner").
+					addTeammate("", "owner", ownerUserType, "alerts.read").
+					addTeammate("", "alice", "admin", "alerts.read")
+			},
+			principal: "alice",
+			wantCode:  codes.FailedPrecondition,
+			wantAdmin: true,
+		},
+		{
+			name: "admin besides another admin",
+			client: func() *memoryClient {
+				return newMemoryClient(1, "owner").
+					addTeammate("", "owner", ownerUserType, "alerts.read").
+					addTeammate("", "alice", "admin", "alerts.read").
+					addTeammate("", "dave", "admin", "alerts.read")
+			},
+			principal: "alice",
+			wantCode:  codes.OK,
+		},
+		{
+			name: "restricted teammate",
+			client: func() *memoryClient {
+				return newMemoryClient(1, "owner").
+					addTeammate("", "alice", "teammate", "alerts.read").
+					addTeammate("", "dave", "admin", "alerts.read")
+			},
+			principal: "alice",
+			wantCode:  codes.OK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := tc.client()
+			cs := newTestConnector(t, false, c)
+
+			for _, entitlement := range []*v2.Entitlement{
+				entitlementOf(scopeResourceType, "1:alerts.read", assignedEntitlement),
+				entitlementOf(scopeCategoryResourceType, "1:alerts", allScopesEntitlement),
+			} {
+				_, err := cs.Revoke(context.Background(), &v2.GrantManagerServiceRevokeRequest{
+					Grant: &v2.Grant{
+						Entitlement: entitlement,
+						Principal:   teammateID("1", tc.principal),
+					},
+				})
+				if status.Code(err) != tc.wantCode {
+					t.Fatalf("expected %s, got %v", tc.wantCode, err)
+				}
+
+				if tc.wantCode == codes.OK {
+					break
+				}
+			}
+
+			if admin := c.isAdmin(tc.principal); admin != tc.wantAdmin {
+				t.Errorf("expected admin %t, got %t", tc.wantAdmin, admin)
+			}
+		})
+	}
+}
+
+func TestTeammateDelete(t *testing.T) {
+	testCases := []struct {
+		name      string
+		principal string
+		others    []string
+		wantCode  codes.Code
+	}{
+		{name: "account owner", principal: "owner", others: []string{"dave"}, wantCode: codes.FailedPrecondition},
+		{name: "last admin", principal: "alice", wantCode: codes.FailedPrecondition},
+		{name: "admin besides another admin", principal: "alice", others: []string{"dave"}, wantCode: codes.OK},
+		{name: "restricted teammate", principal: "bob", wantCode: codes.OK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").
+				addTeammate("", "owner", ownerUserType).
+				addTeammate("", "alice", "admin").
+				addTeammate("", "bob", "teammate", "alerts.read")
+			for _, username := range tc.others {
+				c.addTeammate("", username, "admin")
+			}
+			cs := newTestConnector(t, false, c)
+
+			_, err := cs.DeleteResource(context.Background(), &v2.DeleteResourceRequest{
+				ResourceId: teammateID("1", tc.principal).Id,
+			})
+			if status.Code(err) != tc.wantCode {
+				t.Fatalf("expected %s, got %v", tc.wantCode, err)
+			}
+
+			deleted := c.called("DeleteTeammate " + tc.principal)
+			if deleted != (tc.wantCode == codes.OK) {
+				t.Errorf("expected deleted %t, got %t", tc.wantCode == codes.OK, deleted)
+			}
+		})
+	}
+}
+
+func TestIPAddressProvisioning(t *testing.T) {
+	subuser := &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: "1:10"}}
+
+	testCases := []struct {
+		name         string
+		revoke       bool
+		principal    *v2.Resource
+		ip           string
+		wantErr      bool
+		wantNoop     bool
+		wantSubusers map[string][]string
+	}{
+		{
+			name:         "assign",
+			principal:    subuser,
+			ip:           "2.2.2.2",
+			wantSubusers: map[string][]string{"1.1.1.1": {"sub"}, "2.2.2.2": {"sub"}},
+		},
+		{
+			name:         "already assigned",
+			principal:    subuser,
+			ip:           "1.1.1.1",
+			wantNoop:     true,
+			wantSubusers: map[string][]string{"1.1.1.1": {"sub"}, "2.2.2.2": nil},
+		},
+		{
+			name:         "unassign",
+			revoke:       true,
+			principal:    subuser,
+			ip:           "1.1.1.1",
+			wantSubusers: map[string][]string{"1.1.1.1": {}, "2.2.2.2": nil},
+		},
+		{
+			name:         "already unassigned",
+			revoke:       true,
+			principal:    subuser,
+			ip:           "2.2.2.2",
+			wantNoop:     true,
+			wantSubusers: map[string][]string{"1.1.1.1": {"sub"}, "2.2.2.2": nil},
+		},
+		{
+			name:         "principal is not a subuser",
+			principal:    teammateID("1", "alice"),
+			ip:           "2.2.2.2",
+			wantErr:      true,
+			wantSubusers: map[string][]string{"1.1.1.1": {"sub"}, "2.2.2.2": nil},
+		},
+		{
+			name:         "subuser of another account",
+			principal:    &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: "2:10"}},
+			ip:           "2.2.2.2",
+			wantErr:      true,
+			wantSubusers: map[string][]string{"1.1.1.1": {"sub"}, "2.2.2.2": nil},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").
+				addSubuser(10, "sub").
+				addIP("1.1.1.1", true, "sub").
+				addIP("2.2.2.2", true)
+			cs := newTestConnector(t, false, c)
+			ctx := context.Background()
+
+			entitlement := entitlementOf(ipAddressResourceType, "1:"+tc.ip, assignedEntitlement)
+
+			var (
+				annos annotations.Annotations
+				err   error
+			)
+			if tc.revoke {
+				var resp *v2.GrantManagerServiceRevokeResponse
+				resp, err = cs.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{
+					Grant: &v2.Grant{Entitlement: entitlement, Principal: tc.principal},
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			} else {
+				var resp *v2.GrantManagerServiceGrantResponse
+				resp, err = cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+					Entitlement: entitlement,
+					Principal:   tc.principal,
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			}
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			noop := annos.Contains(&v2.GrantAlreadyExists{}) || annos.Contains(&v2.GrantAlreadyRevoked{})
+			if noop != tc.wantNoop {
+				t.Errorf("expected no-op %t, got %t", tc.wantNoop, noop)
+			}
+
+			for ip, want := range tc.wantSubusers {
+				if got := c.subusersOf(ip); !slices.Equal(got, want) {
+					t.Errorf("expected %s assigned to %v, got %v", ip, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestProvisioningWithCachedClient(t *testing.T) {
+	ctx := context.Background()
+
+	server := sendgridtest.NewServer("owner")
+	t.Cleanup(server.Close)
+	server.AddSubuser(models.Subuser{Id: 10, Username: "sub", Email: "sub@example.com"})
+	server.AddIP(models.IPAddress{Ip: "1.1.1.1"}, true)
+	server.AddIP(models.IPAddress{Ip: "2.2.2.2"}, true)
+	server.AddAuthenticatedDomain(models.AuthenticatedDomain{Id: 20, Subdomain: "em", Domain: "example.com", Valid: true})
+	server.AddBrandedLink(models.BrandedLink{Id: 30, Subdomain: "url", Domain: "example.com", Valid: true})
+
+	cs := newTestConnector(t, false, newCachedClient(t, server))
+	subuser := &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: "1000:10"}}
+
+	grantTo := func(entitlement *v2.Entitlement) {
+		t.Helper()
+
+		resp, err := cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{Entitlement: entitlement, Principal: subuser})
+		if err != nil {
+			t.Fatalf("Grant %s: %v", entitlement.Id, err)
+		}
+
+		annos := annotations.Annotations(resp.Annotations)
+		if annos.Contains(&v2.GrantAlreadyExists{}) {
+			t.Fatalf("Grant %s: reported as already granted", entitlement.Id)
+		}
+	}
+
+	revokeFrom := func(entitlement *v2.Entitlement) {
+		t.Helper()
+
+		resp, err := cs.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{
+			Grant: &v2.Grant{Entitlement: entitlement, Principal: subuser},
+		})
+		if err != nil {
+			t.Fatalf("Revoke %s: %v", entitlement.Id, err)
+		}
+
+		annos := annotations.Annotations(resp.Annotations)
+		if annos.Contains(&v2.GrantAlreadyRevoked{}) {
+			t.Fatalf("Revoke %s: reported as already revoked", entitlement.Id)
+		}
+	}
+
+	// Each grant reads the assignments the previous one changed, which the
+	// response cache holds from before the change.
+	grantTo(entitlementOf(ipAddressResourceType, "1000:1.1.1.1", assignedEntitlement))
+	grantTo(entitlementOf(ipAddressResourceType, "1000:2.2.2.2", assignedEntitlement))
+
+	for _, address := range []string{"1.1.1.1", "2.2.2.2"} {
+		ip, _ := server.IP(address)
+		if !slices.Equal(ip.Subusers, []string{"sub"}) {
+			t.Errorf("expected %s assigned to sub, got %v", address, ip.Subusers)
+		}
+	}
+
+	domain := entitlementOf(authenticatedDomainResourceType, "1000:20", senderEntitlement)
+	grantTo(domain)
+	revokeFrom(domain)
+
+	if d, _ := server.AuthenticatedDomain(20); len(d.Subusers) != 0 {
+		t.Errorf("expected the domain to be disassociated, got %v", d.Subusers)
+	}
+
+	link := entitlementOf(brandedLinkResourceType, "1000:30", senderEntitlement)
+	grantTo(link)
+	revokeFrom(link)
+
+	if id, ok := server.SubuserBrandedLink("sub"); ok {
+		t.Errorf("expected the branded link to be disassociated, got %d", id)
+	}
+}
+
+func TestIPPoolProvisioning(t *testing.T) {
+	ip := func(address string) *v2.Resource {
+		return &v2.Resource{Id: &v2.ResourceId{ResourceType: ipAddressResourceType.Id, Resource: "1:" + address}}
+	}
+
+	testCases := []struct {
+		name      string
+		revoke    bool
+		principal *v2.Resource
+		wantErr   bool
+		wantNoop  bool
+		wantIPs   []string
+	}{
+		{
+			name:      "add",
+			principal: ip("2.2.2.2"),
+			wantIPs:   []string{"1.1.1.1", "2.2.2.2"},
+		},
+		{
+			name:      "already in pool",
+			principal: ip("1.1.1.1"),
+			wantNoop:  true,
+			wantIPs:   []string{"1.1.1.1"},
+		},
+		{
+			name:      "remove",
+			revoke:    true,
+			principal: ip("1.1.1.1"),
+			wantIPs:   nil,
+		},
+		{
+			name:      "already removed",
+			revoke:    true,
+			principal: ip("2.2.2.2"),
+			wantNoop:  true,
+			wantIPs:   []string{"1.1.1.1"},
+		},
+		{
+			name:      "principal is not an ip address",
+			principal: &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: "1:10"}},
+			wantErr:   true,
+			wantIPs:   []string{"1.1.1.1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").addIPPool("transactional", "1.1.1.1")
+			cs := newTestConnector(t, false, c)
+			ctx := context.Background()
+
+			entitlement := entitlementOf(ipPoolResourceType, "1:transactional", memberEntitlement)
+
+			var (
+				annos annotations.Annotations
+				err   error
+			)
+			if tc.revoke {
+				var resp *v2.GrantManagerServiceRevokeResponse
+				resp, err = cs.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{
+					Grant: &v2.Grant{Entitlement: entitlement, Principal: tc.principal},
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			} else {
+				var resp *v2.GrantManagerServiceGrantResponse
+				resp, err = cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+					Entitlement: entitlement,
+					Principal:   tc.principal,
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			}
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			noop := annos.Contains(&v2.GrantAlreadyExists{}) || annos.Contains(&v2.GrantAlreadyRevoked{})
+			if noop != tc.wantNoop {
+				t.Errorf("expected no-op %t, got %t", tc.wantNoop, noop)
+			}
+
+			if got, _ := c.poolIPs("transactional"); !slices.Equal(got, tc.wantIPs) {
+				t.Errorf("expected pool ips %v, got %v", tc.wantIPs, got)
+			}
+		})
+	}
+}
+
+func TestIPPoolCreateDelete(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		c := newMemoryClient(1, "owner")
+		cs := newTestConnector(t, false, c)
+
+		resp, err := cs.CreateResource(ctx, &v2.CreateResourceRequest{
+			Resource: &v2.Resource{
+				Id:               &v2.ResourceId{ResourceType: ipPoolResourceType.Id},
+				DisplayName:      "transactional",
+				ParentResourceId: accountResourceID("1"),
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateResource: %v", err)
+		}
+
+		if got := resourceKey(resp.Created.Id); got != "ip_pool:1:transactional" {
+			t.Errorf("expected ip_pool:1:transactional, got %s", got)
+		}
+
+		if _, ok := c.poolIPs("transactional"); !ok {
+			t.Error("expected the pool to be created")
+		}
+	})
+
+	t.Run("create without a name", func(t *testing.T) {
+		c := newMemoryClient(1, "owner")
+		cs := newTestConnector(t, false, c)
+
+		_, err := cs.CreateResource(ctx, &v2.CreateResourceRequest{
+			Resource: &v2.Resource{Id: &v2.ResourceId{ResourceType: ipPoolResourceType.Id}},
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("create with several accounts and no parent", func(t *testing.T) {
+		cs := newTestConnector(t, false, newMemoryClient(1, "first"), newMemoryClient(2, "second"))
+
+		_, err := cs.CreateResource(ctx, &v2.CreateResourceRequest{
+			Resource: &v2.Resource{Id: &v2.ResourceId{ResourceType: ipPoolResourceType.Id}, DisplayName: "transactional"},
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		c := newMemoryClient(1, "owner").addIPPool("transactional", "1.1.1.1")
+		cs := newTestConnector(t, false, c)
+
+		_, err := cs.DeleteResource(ctx, &v2.DeleteResourceRequest{
+			ResourceId: &v2.ResourceId{ResourceType: ipPoolResourceType.Id, Resource: "1:transactional"},
+		})
+		if err != nil {
+			t.Fatalf("DeleteResource: %v", err)
+		}
+
+		if _, ok := c.poolIPs("transactional"); ok {
+			t.Error("expected the pool to be deleted")
+		}
+	})
+}
+
+func TestSenderDomainProvisioning(t *testing.T) {
+	subuser := func(id string) *v2.Resource {
+		return &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: id}}
+	}
+
+	testCases := []struct {
+		name        string
+		revoke      bool
+		principal   *v2.Resource
+		entitlement *v2.Entitlement
+		wantErr     bool
+		wantNoop    bool
+		wantDomain  []string
+		wantLink    map[string]int
+	}{
+		{
+			name:        "associate domain",
+			principal:   subuser("1:11"),
+			entitlement: entitlementOf(authenticatedDomainResourceType, "1:100", senderEntitlement),
+			wantDomain:  []string{"sub", "other"},
+			wantLink:    map[string]int{"sub": 200},
+		},
+		{
+			name:        "domain already associated",
+			principal:   subuser("1:10"),
+			entitlement: entitlementOf(authenticatedDomainResourceType, "1:100", senderEntitlement),
+			wantNoop:    true,
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{"sub": 200},
+		},
+		{
+			name:        "disassociate domain",
+			revoke:      true,
+			principal:   subuser("1:10"),
+			entitlement: entitlementOf(authenticatedDomainResourceType, "1:100", senderEntitlement),
+			wantDomain:  []string{},
+			wantLink:    map[string]int{"sub": 200},
+		},
+		{
+			name:        "domain already disassociated",
+			revoke:      true,
+			principal:   subuser("1:11"),
+			entitlement: entitlementOf(authenticatedDomainResourceType, "1:100", senderEntitlement),
+			wantNoop:    true,
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{"sub": 200},
+		},
+		{
+			name:        "associate link",
+			principal:   subuser("1:11"),
+			entitlement: entitlementOf(brandedLinkResourceType, "1:201", senderEntitlement),
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{"sub": 200, "other": 201},
+		},
+		{
+			name:        "link already associated",
+			principal:   subuser("1:10"),
+			entitlement: entitlementOf(brandedLinkResourceType, "1:200", senderEntitlement),
+			wantNoop:    true,
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{"sub": 200},
+		},
+		{
+			name:        "subuser associated with another link",
+			principal:   subuser("1:10"),
+			entitlement: entitlementOf(brandedLinkResourceType, "1:201", senderEntitlement),
+			wantErr:     true,
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{"sub": 200},
+		},
+		{
+			name:        "disassociate link",
+			revoke:      true,
+			principal:   subuser("1:10"),
+			entitlement: entitlementOf(brandedLinkResourceType, "1:200", senderEntitlement),
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{},
+		},
+		{
+			name:        "link of another subuser",
+			revoke:      true,
+			principal:   subuser("1:10"),
+			entitlement: entitlementOf(brandedLinkResourceType, "1:201", senderEntitlement),
+			wantNoop:    true,
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{"sub": 200},
+		},
+		{
+			name:        "principal is not a subuser",
+			principal:   teammateID("1", "alice"),
+			entitlement: entitlementOf(authenticatedDomainResourceType, "1:100", senderEntitlement),
+			wantErr:     true,
+			wantDomain:  []string{"sub"},
+			wantLink:    map[string]int{"sub": 200},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").
+				addSubuser(10, "sub").
+				addSubuser(11, "other").
+				addDomain(100, "example.com", "sub").
+				addBrandedLink(200, "example.com", "sub").
+				addBrandedLink(201, "example.org")
+			cs := newTestConnector(t, false, c)
+			ctx := context.Background()
+
+			var (
+				annos annotations.Annotations
+				err   error
+			)
+			if tc.revoke {
+				var resp *v2.GrantManagerServiceRevokeResponse
+				resp, err = cs.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{
+					Grant: &v2.Grant{Entitlement: tc.entitlement, Principal: tc.principal},
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			} else {
+				var resp *v2.GrantManagerServiceGrantResponse
+				resp, err = cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+					Entitlement: tc.entitlement,
+					Principal:   tc.principal,
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			}
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			noop := annos.Contains(&v2.GrantAlreadyExists{}) || annos.Contains(&v2.GrantAlreadyRevoked{})
+			if noop != tc.wantNoop {
+				t.Errorf("expected no-op %t, got %t", tc.wantNoop, noop)
+			}
+
+			if got := c.domainSubusers(100); !slices.Equal(got, tc.wantDomain) {
+				t.Errorf("expected the domain associated with %v, got %v", tc.wantDomain, got)
+			}
+
+			for _, username := range []string{"sub", "other"} {
+				got, ok := c.subuserLink(username)
+				want, wantOk := tc.wantLink[username]
+				if ok != wantOk || got != want {
+					t.Errorf("expected %s associated with link %d (%t), got %d (%t)", username, want, wantOk, got, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestCreditAllocationProvisioning(t *testing.T) {
+	subuser := func(id string) *v2.Resource {
+		return &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: id}}
+	}
+
+	credits := func(slug string, metadata map[string]interface{}) *v2.Entitlement {
+		entitlement := entitlementOf(creditAllocationResourceType, "1:10/credits", slug)
+		if metadata != nil {
+			amount, err := structpb.NewStruct(metadata)
+			if err != nil {
+				t.Fatalf("NewStruct: %v", err)
+			}
+
+			entitlement.Annotations = annotations.New(amount)
+		}
+
+		return entitlement
+	}
+
+	testCases := []struct {
+		name        string
+		revoke      bool
+		principal   *v2.Resource
+		entitlement *v2.Entitlement
+		wantErr     bool
+		wantNoop    bool
+		want        models.SubuserCredits
+	}{
+		{
+			name:        "limit credits",
+			principal:   subuser("1:10"),
+			entitlement: credits(creditTypeNonrecurring, map[string]interface{}{"total": 200}),
+			want:        models.SubuserCredits{Type: "nonrecurring", Total: 200, Remain: 200},
+		},
+		{
+			name:        "change the recurring amount",
+			principal:   subuser("1:10"),
+			entitlement: credits(creditTypeRecurring, map[string]interface{}{"total": 800, "reset_frequency": "daily"}),
+			want:        models.SubuserCredits{Type: "recurring", ResetFrequency: "daily", Total: 800, Remain: 800},
+		},
+		{
+			name:        "unlimited credits",
+			principal:   subuser("1:10"),
+			entitlement: credits(creditTypeUnlimited, nil),
+			want:        models.SubuserCredits{Type: "unlimited"},
+		},
+		{
+			name:        "credits already allocated",
+			principal:   subuser("1:10"),
+			entitlement: credits(creditTypeRecurring, map[string]interface{}{"total": 500, "reset_frequency": "weekly"}),
+			wantNoop:    true,
+			want:        models.SubuserCredits{Type: "recurring", ResetFrequency: "weekly", Total: 500, Remain: 100},
+		},
+		{
+			name:        "missing amount",
+			principal:   subuser("1:10"),
+			entitlement: credits(creditTypeNonrecurring, nil),
+			wantErr:     true,
+			want:        models.SubuserCredits{Type: "recurring", ResetFrequency: "weekly", Total: 500, Remain: 100},
+		},
+		{
+			name:        "another subuser",
+			principal:   subuser("1:11"),
+			entitlement: credits(creditTypeUnlimited, nil),
+			wantErr:     true,
+			want:        models.SubuserCredits{Type: "recurring", ResetFrequency: "weekly", Total: 500, Remain: 100},
+		},
+		{
+			name:        "revoke the current allocation",
+			revoke:      true,
+			principal:   subuser("1:10"),
+			entitlement: credits(creditTypeRecurring, nil),
+			wantErr:     true,
+			want:        models.SubuserCredits{Type: "recurring", ResetFrequency: "weekly", Total: 500, Remain: 100},
+		},
+		{
+			name:        "revoke another allocation",
+			revoke:      true,
+			principal:   subuser("1:10"),
+			entitlement: credits(creditTypeUnlimited, nil),
+			wantNoop:    true,
+			want:        models.SubuserCredits{Type: "recurring", ResetFrequency: "weekly", Total: 500, Remain: 100},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").
+				addSubuser(10, "sub").
+				addSubuser(11, "other").
+				setSubuserUsage("sub", models.SubuserUsage{
+					Credits: &models.SubuserCredits{Type: "recurring", ResetFrequency: "weekly", Total: 500, Remain: 100},
+				})
+			cs := newTestConnector(t, false, c)
+			ctx := context.Background()
+
+			var (
+				annos annotations.Annotations
+				err   error
+			)
+			if tc.revoke {
+				var resp *v2.GrantManagerServiceRevokeResponse
+				resp, err = cs.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{
+					Grant: &v2.Grant{Entitlement: tc.entitlement, Principal: tc.principal},
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			} else {
+				var resp *v2.GrantManagerServiceGrantResponse
+				resp, err = cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+					Entitlement: tc.entitlement,
+					Principal:   tc.principal,
+				})
+				if err == nil {
+					annos = resp.Annotations
+				}
+			}
+
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			noop := annos.Contains(&v2.GrantAlreadyExists{}) || annos.Contains(&v2.GrantAlreadyRevoked{})
+			if noop != tc.wantNoop {
+				t.Errorf("expected no-op %t, got %t", tc.wantNoop, noop)
+			}
+
+			got, err := c.GetSubuserCredits(ctx, "sub")
+			if err != nil {
+				t.Fatalf("GetSubuserCredits: %v", err)
+			}
+
+			if *got != tc.want {
+				t.Errorf("expected credits %+v, got %+v", tc.want, *got)
+			}
+		})
+	}
+}
+
+func TestVerifiedSenderDelete(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("delete", func(t *testing.T) {
+		c := newMemoryClient(1, "owner").addVerifiedSender(10, "news@example.com")
+		cs := newTestConnector(t, false, c)
+
+		_, err := cs.DeleteResource(ctx, &v2.DeleteResourceRequest{
+			ResourceId: &v2.ResourceId{ResourceType: verifiedSenderResourceType.Id, Resource: "1:10"},
+		})
+		if err != nil {
+			t.Fatalf("DeleteResource: %v", err)
+		}
+
+		if c.hasVerifiedSender(10) {
+			t.Error("expected the verified sender to be deleted")
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		c := newMemoryClient(1, "owner")
+		cs := newTestConnector(t, false, c)
+
+		_, err := cs.CreateResource(ctx, &v2.CreateResourceRequest{
+			Resource: &v2.Resource{
+				Id:               &v2.ResourceId{ResourceType: verifiedSenderResourceType.Id},
+				DisplayName:      "news@example.com",
+				ParentResourceId: accountResourceID("1"),
+			},
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
+
+func TestInviteResendDelete(t *testing.T) {
+	ctx := context.Background()
+	expired := time.Now().Add(-24 * time.Hour)
+	inviteID := "1:" + inviteLocalID("token-1")
+
+	for _, tc := range []struct {
+		name     string
+		resource *v2.Resource
+	}{
+		{
+			name:     "resend by id",
+			resource: &v2.Resource{Id: &v2.ResourceId{ResourceType: inviteResourceType.Id, Resource: inviteID}},
+		},
+		{
+			name: "resend by email",
+			resource: &v2.Resource{
+				Id:               &v2.ResourceId{ResourceType: inviteResourceType.Id},
+				DisplayName:      "Bob@example.com",
+				ParentResourceId: accountResourceID("1"),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newMemoryClient(1, "owner").addInvite("token-1", "bob@example.com", expired)
+			cs := newTestConnector(t, false, c)
+
+			resp, err := cs.CreateResource(ctx, &v2.CreateResourceRequest{Resource: tc.resource})
+			if err != nil {
+				t.Fatalf("CreateResource: %v", err)
+			}
+
+			if got := resp.Created.Id.Resource; got != inviteID {
+				t.Errorf("expected %s, got %s", inviteID, got)
+			}
+
+			if !c.called("ResendTeammateInvite token-1") {
+				t.Errorf("expected the invite to be resent, got calls %v", c.calls)
+			}
+
+			if c.called("InviteTeammate") {
+				t.Error("expected no new invite")
+			}
+
+			if c.pending[0].ExpirationDate <= time.Now().Unix() {
+				t.Error("expected the invite expiration to be reset")
+			}
+		})
+	}
+
+	t.Run("invite an email without pending invite", func(t *testing.T) {
+		c := newMemoryClient(1, "owner").addInvite("token-1", "bob@example.com", expired)
+		cs := newTestConnector(t, false, c)
+
+		resp, err := cs.CreateResource(ctx, &v2.CreateResourceRequest{
+			Resource: &v2.Resource{
+				Id:               &v2.ResourceId{ResourceType: inviteResourceType.Id},
+				DisplayName:      "carol@example.com",
+				ParentResourceId: accountResourceID("1"),
+			},
+		})
+		if err != nil {
+			t.Fatalf("CreateResource: %v", err)
+		}
+
+		if !c.called("InviteTeammate carol@example.com") || c.called("ResendTeammateInvite") {
+			t.Errorf("expected carol to be invited, got calls %v", c.calls)
+		}
+
+		if resp.Created.DisplayName != "carol@example.com" {
+			t.Errorf("expected the invite of carol, got %+v", resp.Created)
+		}
+	})
+
+	t.Run("resend an unknown invite", func(t *testing.T) {
+		c := newMemoryClient(1, "owner").addInvite("token-1", "bob@example.com", expired)
+		cs := newTestConnector(t, false, c)
+
+		_, err := cs.CreateResource(ctx, &v2.CreateResourceRequest{
+			Resource: &v2.Resource{Id: &v2.ResourceId{ResourceType: inviteResourceType.Id, Resource: "1:" + inviteLocalID("token-2")}},
+		})
+		if status.Code(err) != codes.NotFound {
+			t.Fatalf("expected NotFound, got %v", err)
+		}
+
+		if c.called("ResendTeammateInvite") || c.called("InviteTeammate") {
+			t.Error("expected no invite to be sent")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		c := newMemoryClient(1, "owner").
+			addInvite("token-1", "bob@example.com", expired).
+			addInvite("token-2", "carol@example.com", expired)
+		cs := newTestConnector(t, false, c)
+
+		_, err := cs.DeleteResource(ctx, &v2.DeleteResourceRequest{
+			ResourceId: &v2.ResourceId{ResourceType: inviteResourceType.Id, Resource: inviteID},
+		})
+		if err != nil {
+			t.Fatalf("DeleteResource: %v", err)
+		}
+
+		if len(c.pending) != 1 || c.pending[0].Token != "token-2" {
+			t.Errorf("expected only token-2 to remain pending, got %+v", c.pending)
+		}
+	})
+}
+
+func TestSyncLeavesOutInviteTokens(t *testing.T) {
+	c := newMemoryClient(1, "owner").addInvite("token-1", "bob@example.com", time.Now())
+
+	result := fullSync(t, newTestConnector(t, false, c))
+
+	for key, resource := range result.resources {
+		raw, err := protojson.Marshal(resource)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(raw), "token-1") {
+			t.Errorf("expected the invite token to be left out of %s, got %s", key, raw)
+		}
+	}
+
+	if _, ok := result.resources["invite:1:"+inviteLocalID("token-1")]; !ok {
+		t.Error("expected the invite to be synced")
+	}
+}
+
+func TestSyncSubuserUsage(t *testing.T) {
+	reputation := 97.5
+	c := newMemoryClient(1, "owner").
+		addSubuser(10, "active").
+		addSubuser(11, "dormant").
+		addSubuser(12, "unreadable").
+		setSubuserUsage("active", models.SubuserUsage{
+			Reputation: &reputation,
+			Credits:    &models.SubuserCredits{Type: "recurring", ResetFrequency: "monthly", Total: 1000, Remain: 250, Used: 750},
+			Stats:      &models.StatsMetrics{Requests: 750, Delivered: 740, Bounces: 10},
+		}).
+		setSubuserUsage("dormant", models.SubuserUsage{
+			Credits: &models.SubuserCredits{Type: "unlimited"},
+		})
+
+	result := fullSync(t, newTestConnector(t, false, c))
+
+	profileOf := func(key string) map[string]interface{} {
+		trait, err := rs.GetUserTrait(result.resources[key])
+		if err != nil {
+			t.Fatalf("GetUserTrait %s: %v", key, err)
+		}
+
+		return trait.Profile.AsMap()
+	}
+
+	active := profileOf("subuser:1:10")
+	for field, want := range map[string]interface{}{
+		"reputation":        97.5,
+		"credit_type":       "recurring",
+		"credits_remaining": float64(250),
+		"requests":          float64(750),
+		"bounces":           float64(10),
+		"stats_month":       lastMonth(time.Now().UTC()),
+	} {
+		if active[field] != want {
+			t.Errorf("expected %s %v, got %v", field, want, active[field])
+		}
+	}
+
+	dormant := profileOf("subuser:1:11")
+	if _, ok := dormant["reputation"]; ok {
+		t.Errorf("expected no reputation, got %v", dormant["reputation"])
+	}
+
+	if dormant["credit_type"] != "unlimited" || dormant["requests"] != float64(0) {
+		t.Errorf("expected unlimited credits and no requests, got %v", dormant)
+	}
+
+	if _, ok := profileOf("subuser:1:12")["credit_type"]; ok {
+		t.Error("expected the unreadable credits to be left out")
+	}
+
+	// The reputations are fetched once per page of subusers, the stats once
+	// for every subuser.
+	if n := c.count("GetSubuserReputations"); n != 2 {
+		t.Errorf("expected 2 reputation lookups, got %d", n)
+	}
+
+	if n := c.count(fmt.Sprintf("GetSubuserMonthlyStats %s 0", lastMonth(time.Now().UTC()))); n != 1 {
+		t.Errorf("expected the monthly stats to be fetched once, got %d", n)
+	}
+}
+
+func TestSyncSubuserStatsFailure(t *testing.T) {
+	c := newMemoryClient(1, "owner").
+		addSubuser(10, "first").
+		addSubuser(11, "second").
+		addSubuser(12, "third").
+		setSubuserUsage("first", models.SubuserUsage{
+			Credits: &models.SubuserCredits{Type: "unlimited"},
+			Stats:   &models.StatsMetrics{Requests: 10},
+		}).
+		failSubuserStats(fmt.Errorf("forbidden"))
+
+	result := fullSync(t, newTestConnector(t, false, c))
+
+	trait, err := rs.GetUserTrait(result.resources["subuser:1:10"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := trait.Profile.AsMap()
+	if _, ok := profile["requests"]; ok || profile["credit_type"] != "unlimited" {
+		t.Errorf("expected the credits without stats, got %v", profile)
+	}
+
+	// The failure is kept for the rest of the sync, every page of subusers
+	// reusing it.
+	if n := c.count("GetSubuserMonthlyStats"); n != 1 {
+		t.Errorf("expected the monthly stats to be requested once, got %d", n)
+	}
+}
+
+func TestSyncVerifiedSenderProfile(t *testing.T) {
+	c := newMemoryClient(1, "owner").addVerifiedSender(10, "news@example.com")
+	c.senders[0].FromName = "News"
+	c.senders[0].ReplyTo = "support@example.com"
+	c.senders[0].Locked = true
+
+	result := fullSync(t, newTestConnector(t, false, c))
+
+	trait, err := rs.GetAppTrait(result.resources["verified_sender:1:10"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"nickname":      "news@example.com",
+		"from_email":    "news@example.com",
+		"from_name":     "News",
+		"reply_to":      "support@example.com",
+		"reply_to_name": "",
+		"verified":      true,
+		"locked":        true,
+	}
+
+	if profile := trait.Profile.AsMap(); !reflect.DeepEqual(profile, want) {
+		t.Errorf("expected the profile %v, got %v", want, profile)
+	}
+}
+
+func TestSyncBrandedLinkFailure(t *testing.T) {
+	c := newMemoryClient(1, "owner").
+		addSubuser(10, "first").
+		addSubuser(11, "second").
+		addSubuser(12, "third").
+		addBrandedLink(20, "example.com", "first", "second", "third").
+		failSubuserBrandedLink("second", fmt.Errorf("forbidden"))
+
+	result := fullSync(t, newTestConnector(t, false, c))
+
+	for _, edge := range []string{
+		"branded_link:1:20:sender -> subuser:1:10",
+		"branded_link:1:20:sender -> subuser:1:12",
+	} {
+		if !slices.Contains(result.grants, edge) {
+			t.Errorf("expected %s, got %v", edge, result.grants)
+		}
+	}
+
+	if edge := "branded_link:1:20:sender -> subuser:1:11"; slices.Contains(result.grants, edge) {
+		t.Errorf("expected no %s", edge)
+	}
+}
+
+func TestResyncCollectsCachesAnew(t *testing.T) {
+	ctx := context.Background()
+	c := newMemoryClient(1, "owner").
+		addTeammate("", "alice", "teammate").
+		addTeammate("", "bob", "teammate").
+		addSubuser(10, "sub").
+		addSubuserAccess("alice", subuserRestrictedEntitlement, 10).
+		addIP("1.1.1.1", true).
+		addBrandedLink(20, "example.com").
+		setSubuserUsage("sub", models.SubuserUsage{
+			Credits: &models.SubuserCredits{Type: "unlimited"},
+		})
+	cs := newTestConnector(t, false, c)
+
+	want := []string{
+		"ip_address:1:1.1.1.1:assigned -> subuser:1:10",
+		"branded_link:1:20:sender -> subuser:1:10",
+		"subuser:1:10:restricted -> teammate:1:bob",
+	}
+
+	before := fullSync(t, cs)
+	for _, edge := range want {
+		if slices.Contains(before.grants, edge) {
+			t.Fatalf("expected no %s before the changes", edge)
+		}
+	}
+
+	// Provisioned through the connector.
+	for _, entitlement := range []*v2.Entitlement{
+		entitlementOf(ipAddressResourceType, "1:1.1.1.1", assignedEntitlement),
+		entitlementOf(brandedLinkResourceType, "1:20", senderEntitlement),
+	} {
+		_, err := cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+			Entitlement: entitlement,
+			Principal:   &v2.Resource{Id: &v2.ResourceId{ResourceType: subuserResourceType.Id, Resource: "1:10"}},
+		})
+		if err != nil {
+			t.Fatalf("Grant %s: %v", entitlement.Id, err)
+		}
+	}
+
+	// Changed outside of the connector.
+	c.addSubuserAccess("bob", subuserRestrictedEntitlement, 10)
+	c.setSubuserUsage("sub", models.SubuserUsage{
+		Credits: &models.SubuserCredits{Type: "nonrecurring", Total: 100, Remain: 100},
+	})
+
+	after := fullSync(t, cs)
+	for _, edge := range want {
+		if !slices.Contains(after.grants, edge) {
+			t.Errorf("expected %s after the changes, got %v", edge, after.grants)
+		}
+	}
+
+	trait, err := rs.GetUserTrait(after.resources["subuser:1:10"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := trait.Profile.AsMap()["credit_type"]; got != "nonrecurring" {
+		t.Errorf("expected the credits of the second sync, got credit type %v", got)
+	}
+}
+
+func TestLastMonth(t *testing.T) {
+	for now, want := range map[time.Time]string{
+		time.Date(2024, time.March, 15, 10, 0, 0, 0, time.UTC): "2024-02-01",
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC): "2023-12-01",
+	} {
+		if got := lastMonth(now); got != want {
+			t.Errorf("lastMonth(%s) = %s, expected %s", now, got, want)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// The credit allocation entitlements are named after the SendGrid credit
+	// types.
+	creditTypeUnlimited    = "unlimited"
+	creditTypeRecurring    = "recurring"
+	creditTypeNonrecurring = "nonrecurring"
+
+	// creditAllocationLocalID identifies the credit allocation of a subuser,
+	// which has exactly one.
+	creditAllocationLocalID = "credits"
+
+	// The metadata of the limited credit allocation entitlements holds the
+	// amount of credits granted, and how often recurring credits reset.
+	creditTotalMetadata          = "total"
+	creditResetFrequencyMetadata = "reset_frequency"
+
+	defaultCreditResetFrequency = "monthly"
+)
+
+var creditTypes = []string{creditTypeUnlimited, creditTypeRecurring, creditTypeNonrecurring}
+
+type creditAllocationBuilder struct {
+	resourceType *v2.ResourceType
+	accounts     *accountSet
+}
+
+func newCreditAllocationBuilder(accounts *accountSet) *creditAllocationBuilder {
+	return &creditAllocationBuilder{
+		resourceType: creditAllocationResourceType,
+		accounts:     accounts,
+	}
+}
+
+func (r *creditAllocationBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return creditAllocationResourceType
+}
+
+// List returns the credit allocation of a subuser.
+func (r *creditAllocationBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil || parentResourceID.ResourceType != subuserResourceType.Id {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	_, username, err := acc.subuserFromResourceID(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Subusers whose credits cannot be read are left without a credit
+	// allocation rather than failing the sync of the account.
+	credits, err := acc.credits.GetCreditsForSubuser(ctx, username)
+	if err != nil {
+		ctxzap.Extract(ctx).Warn(
+			"baton-sendgrid: failed to get the credits of subuser",
+			zap.String("subuser", username),
+			zap.Error(err),
+		)
+
+		return rv, "", nil, nil
+	}
+
+	rb, err := creditAllocationResource(ctx, credits, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	rv = append(rv, rb)
+
+	return rv, "", nil, nil
+}
+
+// Entitlements returns an entitlement per credit type. The limited ones
+// carry the amount of credits a grant allocates in their metadata, which
+// starts from the current allocation of the subuser.
+func (r *creditAllocationBuilder) Entitlements(ctx context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	acc, username, err := r.subuser(ctx, resource.Id)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	credits, err := acc.credits.GetCreditsForSubuser(ctx, username)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	resetFrequency := credits.ResetFrequency
+	if resetFrequency == "" {
+		resetFrequency = defaultCreditResetFrequency
+	}
+
+	var rv []*v2.Entitlement
+	for _, creditType := range creditTypes {
+		options := []ent.EntitlementOption{
+			ent.WithGrantableTo(subuserResourceType),
+			ent.WithDescription(fmt.Sprintf("%s with %s credits", subuserResourceType.DisplayName, creditType)),
+			ent.WithDisplayName(fmt.Sprintf("%s %s credits", username, creditType)),
+		}
+
+		if creditType != creditTypeUnlimited {
+			metadata, err := structpb.NewStruct(map[string]interface{}{
+				creditTotalMetadata:          credits.Total,
+				creditResetFrequencyMetadata: resetFrequency,
+			})
+			if err != nil {
+				return nil, "", nil, err
+			}
+
+			options = append(options, ent.WithAnnotation(metadata))
+		}
+
+		rv = append(rv, ent.NewAssignmentEntitlement(resource, creditType, options...))
+	}
+
+	return rv, "", nil, nil
+}
+
+func (r *creditAllocationBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	acc, username, err := r.subuser(ctx, resource.Id)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	credits, err := acc.credits.GetCreditsForSubuser(ctx, username)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if !isCreditType(credits.Type) {
+		ctxzap.Extract(ctx).Warn(
+			"baton-sendgrid: unknown credit type",
+			zap.String("credit_type", credits.Type),
+			zap.String("subuser", username),
+		)
+
+		return nil, "", nil, nil
+	}
+
+	return []*v2.Grant{grant.NewGrant(resource, credits.Type, resource.ParentResourceId)}, "", nil, nil
+}
+
+// ResourceProvisioner
+
+// Grant changes the credit allocation of the subuser to the credit type of
+// the entitlement, allocating the amount of credits in its metadata.
+func (r *creditAllocationBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, err := r.forSubuserGrant(ctx, principal.Id, entitlement.Resource.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	update, err := creditsUpdate(entitlement)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	credits, err := acc.client.GetSubuserCredits(client.WithoutCache(ctx), username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if creditsMatch(credits, update) {
+		l.Info(
+			"baton-sendgrid: subuser already has the credit allocation",
+			zap.String("credit_type", update.Type),
+			zap.String("subuser", username),
+		)
+
+		return nil, annotations.New(&v2.GrantAlreadyExists{}), nil
+	}
+
+	err = acc.client.UpdateSubuserCredits(ctx, username, update)
+	acc.credits.ForgetSubuser(username)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, update.Type, principal.Id)}, nil, nil
+}
+
+// Revoke refuses to take the current credit allocation away, a subuser
+// always has one: another credit type has to be granted instead.
+func (r *creditAllocationBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, err := r.forSubuserGrant(ctx, grant.Principal.Id, grant.Entitlement.Resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	creditType, err := entitlementCreditType(grant.Entitlement)
+	if err != nil {
+		return nil, err
+	}
+
+	credits, err := acc.client.GetSubuserCredits(client.WithoutCache(ctx), username)
+	if err != nil {
+		return nil, err
+	}
+
+	if credits.Type != creditType {
+		l.Info(
+			"baton-sendgrid: subuser does not have the credit allocation",
+			zap.String("credit_type", creditType),
+			zap.String("subuser", username),
+		)
+
+		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+	}
+
+	return nil, status.Errorf(
+		codes.FailedPrecondition,
+		"baton-sendgrid: subuser %s always has a credit allocation, grant another credit type instead of revoking %s credits",
+		username,
+		creditType,
+	)
+}
+
+// subuser resolves the account and the username of the subuser a credit
+// allocation belongs to.
+func (r *creditAllocationBuilder) subuser(ctx context.Context, resourceID *v2.ResourceId) (*account, string, error) {
+	acc, err := r.accounts.forResource(ctx, resourceID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	subuserResourceID, err := creditAllocationSubuser(resourceID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, username, err := acc.subuserFromResourceID(ctx, subuserResourceID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return acc, username, nil
+}
+
+// forSubuserGrant resolves the account and the subuser username of a grant
+// of a credit allocation, which is only granted to its own subuser.
+func (r *creditAllocationBuilder) forSubuserGrant(ctx context.Context, principalID *v2.ResourceId, resourceID *v2.ResourceId) (*account, string, error) {
+	subuserResourceID, err := creditAllocationSubuser(resourceID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if principalID.ResourceType != subuserResourceType.Id || principalID.Resource != subuserResourceID.Resource {
+		return nil, "", fmt.Errorf("baton-sendgrid: the credit allocation %s can only be granted to its subuser", resourceID.Resource)
+	}
+
+	return r.subuser(ctx, resourceID)
+}
+
+// creditAllocationSubuser returns the resource ID of the subuser a credit
+// allocation belongs to.
+func creditAllocationSubuser(resourceID *v2.ResourceId) (*v2.ResourceId, error) {
+	accountID, localID, err := splitAccountScopedID(resourceID.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	subuserID, id, ok := splitSubuserScopedID(localID)
+	if !ok || id != creditAllocationLocalID {
+		return nil, fmt.Errorf("baton-sendgrid: invalid %s id %q", creditAllocationResourceType.Id, resourceID.Resource)
+	}
+
+	return &v2.ResourceId{
+		ResourceType: subuserResourceType.Id,
+		Resource:     newAccountScopedID(accountID, subuserID),
+	}, nil
+}
+
+func isCreditType(creditType string) bool {
+	for _, t := range creditTypes {
+		if t == creditType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// entitlementCreditType returns the credit type of a credit allocation
+// entitlement.
+func entitlementCreditType(entitlement *v2.Entitlement) (string, error) {
+	if !isCreditType(entitlement.Slug) {
+		return "", fmt.Errorf("baton-sendgrid: invalid credit allocation entitlement %q", entitlement.Id)
+	}
+
+	return entitlement.Slug, nil
+}
+
+// creditsUpdate returns the credit allocation granting the entitlement
+// makes, taking the amount of limited credits from its metadata.
+func creditsUpdate(entitlement *v2.Entitlement) (models.SubuserCreditsUpdate, error) {
+	creditType, err := entitlementCreditType(entitlement)
+	if err != nil {
+		return models.SubuserCreditsUpdate{}, err
+	}
+
+	update := models.SubuserCreditsUpdate{Type: creditType}
+	if creditType == creditTypeUnlimited {
+		return update, nil
+	}
+
+	metadata := &structpb.Struct{}
+	annos := annotations.Annotations(entitlement.Annotations)
+	ok, err := annos.Pick(metadata)
+	if err != nil {
+		return models.SubuserCreditsUpdate{}, err
+	}
+
+	total, hasTotal := metadata.GetFields()[creditTotalMetadata]
+	if !ok || !hasTotal || total.GetNumberValue() < 0 {
+		return models.SubuserCreditsUpdate{}, status.Errorf(
+			codes.InvalidArgument,
+			"baton-sendgrid: the %s credits entitlement requires a %q amount in its metadata",
+			creditType,
+			creditTotalMetadata,
+		)
+	}
+
+	amount := int(total.GetNumberValue())
+	update.Total = &amount
+
+	if creditType == creditTypeRecurring {
+		update.ResetFrequency = metadata.GetFields()[creditResetFrequencyMetadata].GetStringValue()
+		if update.ResetFrequency == "" {
+			update.ResetFrequency = defaultCreditResetFrequency
+		}
+	}
+
+	return update, nil
+}
+
+// creditsMatch reports whether the credit allocation is already the one the
+// update makes.
+func creditsMatch(credits *models.SubuserCredits, update models.SubuserCreditsUpdate) bool {
+	if credits.Type != update.Type {
+		return false
+	}
+
+	if update.Total != nil && credits.Total != *update.Total {
+		return false
+	}
+
+	return credits.ResetFrequency == update.ResetFrequency || update.ResetFrequency == ""
+}
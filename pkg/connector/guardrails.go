@@ -0,0 +1,70 @@
+package connector
+
+import (
+	"context"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// protectOwner refuses changes which would take privileges away from the
+// account owner.
+func protectOwner(teammate *models.TeammateScope) error {
+	if teammate.UserType == ownerUserType {
+		return status.Errorf(codes.FailedPrecondition, "baton-sendgrid: %s is the account owner and cannot lose privileges", teammate.Username)
+	}
+
+	return nil
+}
+
+// protectLastAdmin refuses changes which would remove the admin privileges of
+// the only admin teammate of the account. The owner is not counted, the
+// account has to keep an admin besides it. The admins are read past the
+// response cache, which would miss the ones demoted since.
+func (a *account) protectLastAdmin(ctx context.Context, teammate *models.TeammateScope) error {
+	if !teammate.IsAdmin || teammate.UserType == ownerUserType {
+		return nil
+	}
+
+	ctx = client.WithoutCache(ctx)
+
+	pToken := "0"
+	for pToken != "" {
+		var (
+			teammates []models.Teammate
+			err       error
+		)
+
+		teammates, pToken, err = a.client.GetTeammates(ctx, &pagination.Token{Token: pToken})
+		if err != nil {
+			return err
+		}
+
+		if len(teammates) == 0 {
+			break
+		}
+
+		for _, t := range teammates {
+			if t.IsAdmin && t.UserType != ownerUserType && t.Username != teammate.Username {
+				return nil
+			}
+		}
+	}
+
+	return status.Errorf(codes.FailedPrecondition, "baton-sendgrid: %s is the last admin of account %s", teammate.Username, a.id)
+}
+
+// protectPrivileges refuses changes which would strip the account owner, or
+// remove the last admin teammate of the account, be it by demoting or by
+// deleting it.
+func (a *account) protectPrivileges(ctx context.Context, teammate *models.TeammateScope) error {
+	err := protectOwner(teammate)
+	if err != nil {
+		return err
+	}
+
+	return a.protectLastAdmin(ctx, teammate)
+}
@@ -2,6 +2,11 @@ package connector
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
 
@@ -9,6 +14,125 @@ import (
 	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
 )
 
+// accountScopedIDSeparator separates the account ID from the SendGrid
+// identifier in the ID of resources that belong to an account, so that
+// several accounts can be synced without resource ID collisions.
+const accountScopedIDSeparator = ":"
+
+func newAccountScopedID(accountID string, id interface{}) string {
+	return fmt.Sprintf("%s%s%v", accountID, accountScopedIDSeparator, id)
+}
+
+func splitAccountScopedID(id string) (string, string, error) {
+	accountID, localID, ok := strings.Cut(id, accountScopedIDSeparator)
+	if !ok || accountID == "" || localID == "" {
+		return "", "", fmt.Errorf("baton-sendgrid: invalid resource id %q", id)
+	}
+
+	return accountID, localID, nil
+}
+
+// subuserScopedIDSeparator separates the subuser from the SendGrid
+// identifier in the ID of resources that belong to a subuser, which are
+// reached on behalf of the subuser.
+const subuserScopedIDSeparator = "/"
+
+func newSubuserScopedID(subuserResourceID string, id interface{}) string {
+	return fmt.Sprintf("%s%s%v", subuserResourceID, subuserScopedIDSeparator, id)
+}
+
+// splitSubuserScopedID splits the local part of an account scoped ID into
+// the subuser ID and the SendGrid identifier, if it belongs to a subuser.
+func splitSubuserScopedID(localID string) (string, string, bool) {
+	return strings.Cut(localID, subuserScopedIDSeparator)
+}
+
+// childResourceID returns the ID of a resource listed under the parent,
+// scoped to the account or to the subuser the parent is.
+func childResourceID(parentResourceID *v2.ResourceId, id interface{}) (string, error) {
+	if parentResourceID != nil && parentResourceID.ResourceType == subuserResourceType.Id {
+		return newSubuserScopedID(parentResourceID.Resource, id), nil
+	}
+
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return "", err
+	}
+
+	return newAccountScopedID(accountID, id), nil
+}
+
+// accountIDFromParent returns the account ID of a child resource's parent,
+// which is either the account itself or another account scoped resource.
+func accountIDFromParent(parentResourceID *v2.ResourceId) (string, error) {
+	if parentResourceID == nil {
+		return "", fmt.Errorf("baton-sendgrid: parent resource id is required")
+	}
+
+	if parentResourceID.ResourceType == accountResourceType.Id {
+		return parentResourceID.Resource, nil
+	}
+
+	accountID, _, err := splitAccountScopedID(parentResourceID.Resource)
+	if err != nil {
+		return "", err
+	}
+
+	return accountID, nil
+}
+
+func accountResourceID(accountID string) *v2.ResourceId {
+	return &v2.ResourceId{
+		ResourceType: accountResourceType.Id,
+		Resource:     accountID,
+	}
+}
+
+func accountResource(ctx context.Context, details *models.AccountDetails) (*v2.Resource, error) {
+	profile := map[string]interface{}{
+		"user_id":          details.Username.UserId,
+		"username":         details.Username.Username,
+		"plan_type":        details.Account.Type,
+		"reputation":       details.Account.Reputation,
+		"owner_username":   details.Username.Username,
+		"owner_email":      details.Email.Email,
+		"owner_first_name": details.Profile.FirstName,
+		"owner_last_name":  details.Profile.LastName,
+		"company":          details.Profile.Company,
+		"website":          details.Profile.Website,
+		"country":          details.Profile.Country,
+	}
+
+	appTraitOptions := []rs.AppTraitOption{
+		rs.WithAppProfile(profile),
+	}
+
+	resource, err := rs.NewAppResource(
+		details.Username.Username,
+		accountResourceType,
+		details.Username.UserId,
+		appTraitOptions,
+		rs.WithDescription(fmt.Sprintf("SendGrid %s account", details.Account.Type)),
+		rs.WithAnnotation(
+			&v2.ChildResourceType{ResourceTypeId: teammateResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: inviteResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: scopeCategoryResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: subuserResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: ipAddressResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: ipPoolResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: authenticatedDomainResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: brandedLinkResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: verifiedSenderResourceType.Id},
+		),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
 func teammateResource(ctx context.Context, user *models.Teammate, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	var userStatus = v2.UserTrait_Status_STATUS_ENABLED
 
@@ -20,6 +144,7 @@ func teammateResource(ctx context.Context, user *models.Teammate, parentResource
 		"is_admin":       user.IsAdmin,
 		"is_unified":     user.IsUnified,
 		"is_partner_sso": user.IsPartnerSso,
+		"is_owner":       user.UserType == ownerUserType,
 	}
 
 	userTraits := []rs.UserTraitOption{
@@ -29,12 +154,18 @@ func teammateResource(ctx context.Context, user *models.Teammate, parentResource
 		rs.WithUserLogin(user.Email),
 	}
 
+	// Twilio doesn't have a unique ID for users, so we use the username as the ID
+	id, err := childResourceID(parentResourceID, user.Username)
+	if err != nil {
+		return nil, err
+	}
+
 	ret, err := rs.NewUserResource(
 		user.Username,
 		teammateResourceType,
-		// Twilio doesn't have a unique ID for users, so we use the username as the ID
-		user.Username,
+		id,
 		userTraits,
+		rs.WithParentResourceID(parentResourceID),
 	)
 	if err != nil {
 		return nil, err
@@ -43,20 +174,49 @@ func teammateResource(ctx context.Context, user *models.Teammate, parentResource
 	return ret, nil
 }
 
+func scopeCategoryResource(ctx context.Context, category string, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := rs.NewResource(
+		category,
+		scopeCategoryResourceType,
+		newAccountScopedID(accountID, category),
+		rs.WithDescription(fmt.Sprintf("SendGrid %s scopes", category)),
+		rs.WithAnnotation(&v2.ChildResourceType{ResourceTypeId: scopeResourceType.Id}),
+		rs.WithParentResourceID(parentResourceID),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
 func scopeResource(ctx context.Context, scope Scope, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	profile := map[string]interface{}{
-		"name": string(scope),
+		"name":     string(scope),
+		"category": ScopeCategory(scope),
 	}
 
 	roleTraitOptions := []rs.RoleTraitOption{
 		rs.WithRoleProfile(profile),
 	}
 
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
 	resource, err := rs.NewRoleResource(
 		string(scope),
 		scopeResourceType,
-		string(scope),
+		newAccountScopedID(accountID, scope),
 		roleTraitOptions,
+		rs.WithParentResourceID(parentResourceID),
 	)
 
 	if err != nil {
@@ -66,7 +226,7 @@ func scopeResource(ctx context.Context, scope Scope, parentResourceID *v2.Resour
 	return resource, nil
 }
 
-func subuserResource(ctx context.Context, subuser models.Subuser, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+func subuserResource(ctx context.Context, subuser models.Subuser, usage *models.SubuserUsage, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
 	status := v2.UserTrait_Status_STATUS_ENABLED
 
 	if subuser.Disabled {
@@ -80,17 +240,54 @@ func subuserResource(ctx context.Context, subuser models.Subuser, parentResource
 		"disabled": subuser.Disabled,
 	}
 
+	if usage != nil {
+		if usage.Reputation != nil {
+			profile["reputation"] = *usage.Reputation
+		}
+
+		if usage.Credits != nil {
+			profile["credit_type"] = usage.Credits.Type
+			profile["credit_reset_frequency"] = usage.Credits.ResetFrequency
+			profile["credits_total"] = usage.Credits.Total
+			profile["credits_remaining"] = usage.Credits.Remain
+			profile["credits_used"] = usage.Credits.Used
+		}
+
+		if usage.Stats != nil {
+			profile["stats_month"] = usage.StatsMonth
+			profile["requests"] = usage.Stats.Requests
+			profile["delivered"] = usage.Stats.Delivered
+			profile["bounces"] = usage.Stats.Bounces
+			profile["blocks"] = usage.Stats.Blocks
+			profile["spam_reports"] = usage.Stats.SpamReports
+			profile["opens"] = usage.Stats.Opens
+			profile["clicks"] = usage.Stats.Clicks
+			profile["unsubscribes"] = usage.Stats.Unsubscribes
+		}
+	}
+
 	subUserTraitOptions := rs.WithUserTrait(
 		rs.WithUserProfile(profile),
 		rs.WithStatus(status),
 		rs.WithEmail(subuser.Email, true),
 	)
 
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
 	resource, err := rs.NewResource(
 		subuser.Username,
 		subuserResourceType,
-		subuser.Id,
+		newAccountScopedID(accountID, subuser.Id),
 		subUserTraitOptions,
+		rs.WithParentResourceID(parentResourceID),
+		rs.WithAnnotation(
+			&v2.ChildResourceType{ResourceTypeId: teammateResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: apiKeyResourceType.Id},
+			&v2.ChildResourceType{ResourceTypeId: creditAllocationResourceType.Id},
+		),
 	)
 
 	if err != nil {
@@ -99,3 +296,263 @@ func subuserResource(ctx context.Context, subuser models.Subuser, parentResource
 
 	return resource, nil
 }
+
+func apiKeyResource(ctx context.Context, apiKey models.ApiKey, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	id, err := childResourceID(parentResourceID, apiKey.ApiKeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := rs.NewResource(
+		apiKey.Name,
+		apiKeyResourceType,
+		id,
+		rs.WithDescription(fmt.Sprintf("SendGrid API key %s", apiKey.Name)),
+		rs.WithParentResourceID(parentResourceID),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func ipAddressResource(ctx context.Context, ip models.IPAddress, assigned bool, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	var details []string
+	if assigned {
+		details = append(details, "assigned")
+	} else {
+		details = append(details, "unassigned")
+	}
+
+	if ip.Warmup {
+		details = append(details, "warming up")
+	}
+
+	if ip.Rdns != "" {
+		details = append(details, fmt.Sprintf("rdns %s", ip.Rdns))
+	}
+
+	if len(ip.Pools) != 0 {
+		details = append(details, fmt.Sprintf("pools %s", strings.Join(ip.Pools, ", ")))
+	}
+
+	resource, err := rs.NewResource(
+		ip.Ip,
+		ipAddressResourceType,
+		newAccountScopedID(accountID, ip.Ip),
+		rs.WithDescription(fmt.Sprintf("SendGrid IP address %s (%s)", ip.Ip, strings.Join(details, ", "))),
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func ipPoolResource(ctx context.Context, pool models.IPPool, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	resource, err := rs.NewResource(
+		pool.Name,
+		ipPoolResourceType,
+		newAccountScopedID(accountID, pool.Name),
+		rs.WithDescription(fmt.Sprintf("SendGrid IP pool %s", pool.Name)),
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+// senderDomainName returns the name of the domain mail is sent or tracked
+// through, its subdomain included.
+func senderDomainName(subdomain string, domain string) string {
+	if subdomain == "" {
+		return domain
+	}
+
+	return fmt.Sprintf("%s.%s", subdomain, domain)
+}
+
+// senderDomainDetails describes the state of an authenticated domain or a
+// branded link.
+func senderDomainDetails(valid bool, isDefault bool, legacy bool) string {
+	details := []string{"unverified"}
+	if valid {
+		details[0] = "verified"
+	}
+
+	if isDefault {
+		details = append(details, "default")
+	}
+
+	if legacy {
+		details = append(details, "legacy")
+	}
+
+	return strings.Join(details, ", ")
+}
+
+func authenticatedDomainResource(ctx context.Context, domain models.AuthenticatedDomain, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := senderDomainName(domain.Subdomain, domain.Domain)
+
+	resource, err := rs.NewResource(
+		name,
+		authenticatedDomainResourceType,
+		newAccountScopedID(accountID, domain.Id),
+		rs.WithDescription(fmt.Sprintf("SendGrid authenticated domain %s (%s)", name, senderDomainDetails(domain.Valid, domain.Default, domain.Legacy))),
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func brandedLinkResource(ctx context.Context, link models.BrandedLink, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	name := senderDomainName(link.Subdomain, link.Domain)
+
+	resource, err := rs.NewResource(
+		name,
+		brandedLinkResourceType,
+		newAccountScopedID(accountID, link.Id),
+		rs.WithDescription(fmt.Sprintf("SendGrid branded link %s (%s)", name, senderDomainDetails(link.Valid, link.Default, link.Legacy))),
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func verifiedSenderResource(ctx context.Context, sender models.VerifiedSender, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := map[string]interface{}{
+		"nickname":      sender.Nickname,
+		"from_email":    sender.FromEmail,
+		"from_name":     sender.FromName,
+		"reply_to":      sender.ReplyTo,
+		"reply_to_name": sender.ReplyToName,
+		"verified":      sender.Verified,
+		"locked":        sender.Locked,
+	}
+
+	appTraitOptions := []rs.AppTraitOption{
+		rs.WithAppProfile(profile),
+	}
+
+	name := sender.Nickname
+	if name == "" {
+		name = sender.FromEmail
+	}
+
+	resource, err := rs.NewAppResource(
+		name,
+		verifiedSenderResourceType,
+		newAccountScopedID(accountID, sender.Id),
+		appTraitOptions,
+		rs.WithDescription(fmt.Sprintf("SendGrid verified sender %s", sender.FromEmail)),
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+// inviteLocalID returns the ID of an invite, derived from its token. The token
+// lets anyone accept the invite, it is kept out of the synced data.
+func inviteLocalID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:16])
+}
+
+func inviteResource(ctx context.Context, invite models.PendingUserAccess, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	accountID, err := accountIDFromParent(parentResourceID)
+	if err != nil {
+		return nil, err
+	}
+
+	access := fmt.Sprintf("%d scopes", len(invite.Scopes))
+	if invite.IsAdmin {
+		access = "admin"
+	}
+
+	expiration := time.Unix(invite.ExpirationDate, 0).UTC()
+	state := fmt.Sprintf("expires %s", expiration.Format(time.RFC3339))
+	if expiration.Before(time.Now()) {
+		state = fmt.Sprintf("expired %s", expiration.Format(time.RFC3339))
+	}
+
+	resource, err := rs.NewResource(
+		invite.Email,
+		inviteResourceType,
+		newAccountScopedID(accountID, inviteLocalID(invite.Token)),
+		rs.WithDescription(fmt.Sprintf("SendGrid teammate invite of %s (%s, %s)", invite.Email, access, state)),
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
+
+func creditAllocationResource(ctx context.Context, credits *models.SubuserCredits, parentResourceID *v2.ResourceId) (*v2.Resource, error) {
+	id, err := childResourceID(parentResourceID, creditAllocationLocalID)
+	if err != nil {
+		return nil, err
+	}
+
+	details := credits.Type
+	if credits.Type != creditTypeUnlimited {
+		details = fmt.Sprintf("%s, %d of %d remaining", credits.Type, credits.Remain, credits.Total)
+		if credits.ResetFrequency != "" {
+			details = fmt.Sprintf("%s, reset %s", details, credits.ResetFrequency)
+		}
+	}
+
+	resource, err := rs.NewResource(
+		"Credits",
+		creditAllocationResourceType,
+		id,
+		rs.WithDescription(fmt.Sprintf("SendGrid subuser credit allocation (%s)", details)),
+		rs.WithParentResourceID(parentResourceID),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return resource, nil
+}
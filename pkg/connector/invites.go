@@ -0,0 +1,263 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type inviteBuilder struct {
+	resourceType *v2.ResourceType
+	accounts     *accountSet
+}
+
+func newInviteBuilder(accounts *accountSet) *inviteBuilder {
+	return &inviteBuilder{
+		resourceType: inviteResourceType,
+		accounts:     accounts,
+	}
+}
+
+func (r *inviteBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return inviteResourceType
+}
+
+// List returns the pending invites of an account, expired ones included so
+// that they can be resent or deleted.
+func (r *inviteBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	invites, pNextToken, err := acc.client.GetPendingTeammates(ctx, pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, invite := range invites {
+		rb, err := inviteResource(ctx, invite, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, rb)
+	}
+
+	return rv, pNextToken, nil, nil
+}
+
+func (r *inviteBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func (r *inviteBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// ResourceManager
+
+// Create invites the email address given as the display name of the resource,
+// unless an invite is already pending for it. A pending invite, or the one
+// with the ID of the resource, is resent instead, resetting its expiration
+// date so that an expired invite can be accepted again. New invites are sent
+// without scopes, which are granted once the teammate joined.
+func (r *inviteBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, invite, err := r.findInvite(ctx, resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if invite == nil {
+		return r.invite(ctx, acc, resource.DisplayName)
+	}
+
+	err = acc.client.ResendTeammateInvite(ctx, invite.Token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l.Info(
+		"baton-sendgrid: resent teammate invite",
+		zap.String("email", invite.Email),
+		zap.String("account", acc.id),
+	)
+
+	// The invite is read back for its new expiration date.
+	resent, err := findPendingInvite(ctx, acc, func(i models.PendingUserAccess) bool {
+		return i.Token == invite.Token
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resent != nil {
+		invite = resent
+	}
+
+	rv, err := inviteResource(ctx, *invite, accountResourceID(acc.id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rv, nil, nil
+}
+
+// invite sends a new invite to the email address.
+func (r *inviteBuilder) invite(ctx context.Context, acc *account, email string) (*v2.Resource, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	err := acc.client.InviteTeammate(ctx, email, []string{}, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l.Info(
+		"baton-sendgrid: invited teammate",
+		zap.String("email", email),
+		zap.String("account", acc.id),
+	)
+
+	invite, err := findPendingInvite(ctx, acc, func(i models.PendingUserAccess) bool {
+		return strings.EqualFold(i.Email, email)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if invite == nil {
+		return nil, nil, fmt.Errorf("baton-sendgrid: invite of %s not found in account %s once sent", email, acc.id)
+	}
+
+	rv, err := inviteResource(ctx, *invite, accountResourceID(acc.id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rv, nil, nil
+}
+
+func (r *inviteBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	acc, err := r.accounts.forResource(ctx, resourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := findInviteByID(ctx, acc, resourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	err = acc.client.DeletePendingTeammate(ctx, invite.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// findInvite returns the pending invite a resource to create designates, by
+// its ID or by the email address it was sent to. No invite is returned when
+// none is pending for the email address.
+func (r *inviteBuilder) findInvite(ctx context.Context, resource *v2.Resource) (*account, *models.PendingUserAccess, error) {
+	if resource.Id.GetResource() != "" {
+		acc, err := r.accounts.forResource(ctx, resource.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		invite, err := findInviteByID(ctx, acc, resource.Id)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return acc, invite, nil
+	}
+
+	email := resource.DisplayName
+	if email == "" {
+		return nil, nil, fmt.Errorf("baton-sendgrid: the id or the email address of the %s is required", inviteResourceType.DisplayName)
+	}
+
+	acc, err := r.accounts.forCreate(ctx, resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	invite, err := findPendingInvite(ctx, acc, func(i models.PendingUserAccess) bool {
+		return strings.EqualFold(i.Email, email)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return acc, invite, nil
+}
+
+// findInviteByID returns the pending invite with the ID of the resource,
+// which is derived from its token.
+func findInviteByID(ctx context.Context, acc *account, resourceID *v2.ResourceId) (*models.PendingUserAccess, error) {
+	_, localID, err := splitAccountScopedID(resourceID.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	invite, err := findPendingInvite(ctx, acc, func(i models.PendingUserAccess) bool {
+		return inviteLocalID(i.Token) == localID
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if invite == nil {
+		return nil, status.Errorf(codes.NotFound, "baton-sendgrid: no pending invite %s", resourceID.Resource)
+	}
+
+	return invite, nil
+}
+
+// findPendingInvite returns the first pending invite of the account matching,
+// or nil when none does. The invites are read past the response cache, which
+// would miss the ones sent or resent since.
+func findPendingInvite(ctx context.Context, acc *account, match func(models.PendingUserAccess) bool) (*models.PendingUserAccess, error) {
+	ctx = client.WithoutCache(ctx)
+
+	pToken := &pagination.Token{}
+	for {
+		invites, next, err := acc.client.GetPendingTeammates(ctx, pToken)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, invite := range invites {
+			if match(invite) {
+				return &invite, nil
+			}
+		}
+
+		if next == "" {
+			return nil, nil
+		}
+
+		pToken = &pagination.Token{Token: next}
+	}
+}
@@ -0,0 +1,110 @@
+package connector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+)
+
+// ipAddressCache maps the IP addresses of an account to the subusers they
+// are assigned to, collected once for the grants of every IP address.
+type ipAddressCache struct {
+	client SendGridClient
+
+	mtx          sync.Mutex
+	built        bool
+	ipToSubusers map[string][]string
+	// assigned holds the IP addresses assigned to a subuser, fetched once
+	// for every page of IP addresses.
+	assigned []models.AssignedIPAddress
+}
+
+func newIPAddressCache(gridClient SendGridClient) *ipAddressCache {
+	return &ipAddressCache{
+		client: gridClient,
+	}
+}
+
+func (c *ipAddressCache) buildCache(ctx context.Context) error {
+	l := ctxzap.Extract(ctx)
+
+	l.Info("Building cache for IP addresses")
+
+	ipToSubusers := make(map[string][]string)
+
+	pToken := "0"
+	for pToken != "" {
+		var (
+			ips []models.IPAddress
+			err error
+		)
+
+		ips, pToken, err = c.client.GetIPs(ctx, &pagination.Token{Token: pToken})
+		if err != nil {
+			return err
+		}
+
+		if len(ips) == 0 {
+			break
+		}
+
+		for _, ip := range ips {
+			ipToSubusers[ip.Ip] = ip.Subusers
+		}
+	}
+
+	c.ipToSubusers = ipToSubusers
+	c.built = true
+
+	l.Info("Cache built for IP addresses")
+
+	return nil
+}
+
+// GetSubusersForIP returns the usernames of the subusers the IP address is
+// assigned to.
+func (c *ipAddressCache) GetSubusersForIP(ctx context.Context, ip string) ([]string, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.built {
+		err := c.buildCache(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c.ipToSubusers[ip], nil
+}
+
+// GetAssignedIPs returns the IP addresses assigned to a subuser.
+func (c *ipAddressCache) GetAssignedIPs(ctx context.Context) ([]models.AssignedIPAddress, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.assigned == nil {
+		assigned, err := c.client.GetAssignedIPs(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.assigned = append(make([]models.AssignedIPAddress, 0, len(assigned)), assigned...)
+	}
+
+	return c.assigned, nil
+}
+
+// reset drops the collected assignments, so that they are collected again on
+// their next use, after a sync started or an assignment changed.
+func (c *ipAddressCache) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.built = false
+	c.ipToSubusers = nil
+	c.assigned = nil
+}
@@ -0,0 +1,216 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+type ipAddressBuilder struct {
+	resourceType   *v2.ResourceType
+	accounts       *accountSet
+	ignoreSubusers bool
+}
+
+func newIPAddressBuilder(accounts *accountSet, ignoreSubusers bool) *ipAddressBuilder {
+	return &ipAddressBuilder{
+		resourceType:   ipAddressResourceType,
+		accounts:       accounts,
+		ignoreSubusers: ignoreSubusers,
+	}
+}
+
+func (r *ipAddressBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return ipAddressResourceType
+}
+
+func (r *ipAddressBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Every sync collects the assignments of the IP addresses anew.
+	if pToken == nil || pToken.Token == "" {
+		acc.ipAddresses.reset()
+	}
+
+	ips, pNextToken, err := acc.client.GetIPs(ctx, pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if len(ips) == 0 {
+		return rv, "", nil, nil
+	}
+
+	assignedIPs, err := acc.ipAddresses.GetAssignedIPs(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, ip := range ips {
+		assigned := slices.ContainsFunc(assignedIPs, func(assignedIP models.AssignedIPAddress) bool {
+			return assignedIP.Ip == ip.Ip
+		})
+
+		rb, err := ipAddressResource(ctx, ip, assigned, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, rb)
+	}
+
+	return rv, pNextToken, nil, nil
+}
+
+func (r *ipAddressBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+	assigmentOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(subuserResourceType),
+		ent.WithDescription(fmt.Sprintf("%s sending from the %s", subuserResourceType.DisplayName, ipAddressResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s assigned to %s", resource.DisplayName, subuserResourceType.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, assignedEntitlement, assigmentOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (r *ipAddressBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	var rv []*v2.Grant
+
+	// The grants go to subusers, which are not synced when ignored.
+	if r.ignoreSubusers {
+		return rv, "", nil, nil
+	}
+
+	accountID, ip, err := splitAccountScopedID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	acc, err := r.accounts.get(ctx, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	subusers, err := acc.ipAddresses.GetSubusersForIP(ctx, ip)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, username := range subusers {
+		// An IP address may still list a subuser deleted since, which is
+		// left out rather than failing the sync.
+		subuserID, ok, err := acc.knownSubuserID(ctx, username)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if !ok {
+			l.Warn(
+				"baton-sendgrid: ip address assigned to an unknown subuser",
+				zap.String("ip", ip),
+				zap.String("subuser", username),
+			)
+			continue
+		}
+
+		subuserResourceID, err := rs.NewResourceID(subuserResourceType, newAccountScopedID(accountID, subuserID))
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, grant.NewGrant(resource, assignedEntitlement, subuserResourceID))
+	}
+
+	return rv, "", nil, nil
+}
+
+// ResourceProvisioner
+
+func (r *ipAddressBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, ip, err := r.accounts.forSubuserGrant(ctx, principal.Id, entitlement.Resource.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed, err := acc.subuserIPs.apply(ctx, username, func(ips []string) ([]string, bool) {
+		if slices.Contains(ips, ip) {
+			return ips, false
+		}
+
+		return append(ips, ip), true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !changed {
+		l.Info(
+			"baton-sendgrid: ip address already assigned to subuser",
+			zap.String("ip", ip),
+			zap.String("subuser", username),
+		)
+
+		return nil, annotations.New(&v2.GrantAlreadyExists{}), nil
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, assignedEntitlement, principal.Id)}, nil, nil
+}
+
+func (r *ipAddressBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, username, ip, err := r.accounts.forSubuserGrant(ctx, grant.Principal.Id, grant.Entitlement.Resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := acc.subuserIPs.apply(ctx, username, func(ips []string) ([]string, bool) {
+		if !slices.Contains(ips, ip) {
+			return ips, false
+		}
+
+		return slices.DeleteFunc(ips, func(assignedIP string) bool {
+			return assignedIP == ip
+		}), true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !changed {
+		l.Info(
+			"baton-sendgrid: ip address not assigned to subuser",
+			zap.String("ip", ip),
+			zap.String("subuser", username),
+		)
+
+		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,233 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	memberEntitlement = "member"
+)
+
+type ipPoolBuilder struct {
+	resourceType *v2.ResourceType
+	accounts     *accountSet
+}
+
+func newIPPoolBuilder(accounts *accountSet) *ipPoolBuilder {
+	return &ipPoolBuilder{
+		resourceType: ipPoolResourceType,
+		accounts:     accounts,
+	}
+}
+
+func (r *ipPoolBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return ipPoolResourceType
+}
+
+// List returns the IP pools of an account, which SendGrid does not paginate.
+func (r *ipPoolBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	pools, err := acc.client.GetIPPools(ctx)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, pool := range pools {
+		rb, err := ipPoolResource(ctx, pool, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, rb)
+	}
+
+	return rv, "", nil, nil
+}
+
+func (r *ipPoolBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+	memberOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(ipAddressResourceType),
+		ent.WithDescription(fmt.Sprintf("%s in the %s", ipAddressResourceType.DisplayName, ipPoolResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s member", resource.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, memberEntitlement, memberOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (r *ipPoolBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	accountID, name, err := splitAccountScopedID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	acc, err := r.accounts.get(ctx, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	pool, err := acc.client.GetIPPool(ctx, name)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	for _, ip := range pool.Ips {
+		ipID, err := rs.NewResourceID(ipAddressResourceType, newAccountScopedID(accountID, ip.Ip))
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, grant.NewGrant(resource, memberEntitlement, ipID))
+	}
+
+	return rv, "", nil, nil
+}
+
+// ResourceProvisioner
+
+func (r *ipPoolBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, ip, name, err := r.accounts.forAccountGrant(ctx, ipAddressResourceType, principal.Id, entitlement.Resource.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inPool, err := ipInPool(ctx, acc, name, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if inPool {
+		l.Info(
+			"baton-sendgrid: ip address already in pool",
+			zap.String("ip", ip),
+			zap.String("pool", name),
+		)
+
+		return nil, annotations.New(&v2.GrantAlreadyExists{}), nil
+	}
+
+	err = acc.client.AddIPToPool(ctx, name, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, memberEntitlement, principal.Id)}, nil, nil
+}
+
+func (r *ipPoolBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, ip, name, err := r.accounts.forAccountGrant(ctx, ipAddressResourceType, grant.Principal.Id, grant.Entitlement.Resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	inPool, err := ipInPool(ctx, acc, name, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	if !inPool {
+		l.Info(
+			"baton-sendgrid: ip address not in pool",
+			zap.String("ip", ip),
+			zap.String("pool", name),
+		)
+
+		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+	}
+
+	err = acc.client.RemoveIPFromPool(ctx, name, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// ResourceManager
+
+// Create creates an IP pool named after the display name of the resource.
+func (r *ipPoolBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	name := resource.DisplayName
+	if name == "" {
+		return nil, nil, fmt.Errorf("baton-sendgrid: the name of the %s is required", ipPoolResourceType.DisplayName)
+	}
+
+	acc, err := r.accounts.forCreate(ctx, resource)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = acc.client.CreateIPPool(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rv, err := ipPoolResource(ctx, models.IPPool{Name: name}, accountResourceID(acc.id))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rv, nil, nil
+}
+
+func (r *ipPoolBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	acc, err := r.accounts.forResource(ctx, resourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, name, err := splitAccountScopedID(resourceId.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	err = acc.client.DeleteIPPool(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// ipInPool reports whether the IP address is in the pool, read past the
+// response cache which would miss the changes made since.
+func ipInPool(ctx context.Context, acc *account, name string, ip string) (bool, error) {
+	pool, err := acc.client.GetIPPool(client.WithoutCache(ctx), name)
+	if err != nil {
+		return false, err
+	}
+
+	return slices.ContainsFunc(pool.Ips, func(poolIP models.IPPoolAddress) bool {
+		return poolIP.Ip == ip
+	}), nil
+}
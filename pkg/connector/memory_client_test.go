@@ -0,0 +1,1026 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+)
+
+// memoryClient is an in-memory SendGridClient for a single SendGrid account.
+// Lists are paginated with offset tokens like the real client, using a small
+// page size so that the builders' pagination is exercised.
+type memoryClient struct {
+	mtx sync.Mutex
+
+	account   models.AccountDetails
+	pageLimit int
+
+	// Teammates and API keys are keyed by the subuser they belong to, the
+	// parent account being the empty string.
+	teammates     map[string][]*models.TeammateScope
+	apiKeys       map[string][]models.ApiKey
+	pending       []models.PendingUserAccess
+	subusers      []*models.Subuser
+	subuserAccess map[string]*models.TeammateSubuserResponse
+	ips           []*models.IPAddress
+	assignedIPs   []string
+	pools         []*models.IPPoolDetails
+	domains       []*models.AuthenticatedDomain
+	links         []models.BrandedLink
+	subuserLinks  map[string]int
+	senders       []models.VerifiedSender
+	usage         map[string]models.SubuserUsage
+	// statsErr fails the monthly stats lookups when set.
+	statsErr error
+	// linkErrs fails the branded link lookups of the subusers in it.
+	linkErrs map[string]error
+
+	// calls records the mutating calls, and the lookups the tests count,
+	// made on the client.
+	calls []string
+}
+
+var _ SendGridClient = (*memoryClient)(nil)
+
+func newMemoryClient(userID int, username string) *memoryClient {
+	return &memoryClient{
+		account: models.AccountDetails{
+			Username: models.UserUsername{Username: username, UserId: userID},
+			Account:  models.UserAccount{Type: "paid", Reputation: 99.5},
+			Email:    models.UserEmail{Email: username + "@example.com"},
+		},
+		pageLimit:     2,
+		teammates:     make(map[string][]*models.TeammateScope),
+		apiKeys:       make(map[string][]models.ApiKey),
+		subuserAccess: make(map[string]*models.TeammateSubuserResponse),
+		subuserLinks:  make(map[string]int),
+		usage:         make(map[string]models.SubuserUsage),
+	}
+}
+
+func (m *memoryClient) addTeammate(subuser string, username string, userType string, scopes ...string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.teammates[subuser] = append(m.teammates[subuser], &models.TeammateScope{
+		Teammate: models.Teammate{
+			Username: username,
+			Email:    username + "@example.com",
+			UserType: userType,
+			IsAdmin:  userType == "admin" || userType == ownerUserType,
+		},
+		Scopes: scopes,
+	})
+
+	return m
+}
+
+func (m *memoryClient) addInvite(token string, email string, expiration time.Time, scopes ...string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.pending = append(m.pending, models.PendingUserAccess{
+		Token:          token,
+		Email:          email,
+		Scopes:         scopes,
+		ExpirationDate: expiration.Unix(),
+	})
+
+	return m
+}
+
+func (m *memoryClient) addSubuser(id int, username string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.subusers = append(m.subusers, &models.Subuser{Id: id, Username: username, Email: username + "@example.com"})
+
+	return m
+}
+
+func (m *memoryClient) addSubuserAccess(username string, permissionType string, subuserIDs ...int) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	access, ok := m.subuserAccess[username]
+	if !ok {
+		access = &models.TeammateSubuserResponse{HasRestrictedSubuserAccess: true}
+	}
+
+	for _, id := range subuserIDs {
+		access.SubuserAccess = append(access.SubuserAccess, models.TeammateSubuser{Id: id, PermissionType: permissionType})
+	}
+	m.subuserAccess[username] = access
+
+	return m
+}
+
+// addUnrestrictedSubuserAccess gives the teammate access to every subuser.
+func (m *memoryClient) addUnrestrictedSubuserAccess(username string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.subuserAccess[username] = &models.TeammateSubuserResponse{HasRestrictedSubuserAccess: false}
+
+	return m
+}
+
+func (m *memoryClient) addApiKey(subuser string, id string, name string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.apiKeys[subuser] = append(m.apiKeys[subuser], models.ApiKey{ApiKeyId: id, Name: name})
+
+	return m
+}
+
+func (m *memoryClient) addIP(ip string, assigned bool, subusers ...string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.ips = append(m.ips, &models.IPAddress{Ip: ip, Subusers: subusers})
+	if assigned {
+		m.assignedIPs = append(m.assignedIPs, ip)
+	}
+
+	return m
+}
+
+func (m *memoryClient) addIPPool(name string, ips ...string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	pool := &models.IPPoolDetails{PoolName: name}
+	for _, ip := range ips {
+		pool.Ips = append(pool.Ips, models.IPPoolAddress{Ip: ip})
+	}
+	m.pools = append(m.pools, pool)
+
+	return m
+}
+
+// addDomain adds an authenticated domain associated with the given
+// subusers, which have to be added first.
+func (m *memoryClient) addDomain(id int, domain string, subusers ...string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	d := &models.AuthenticatedDomain{Id: id, Subdomain: "em", Domain: domain, Valid: true}
+	for _, username := range subusers {
+		d.Subusers = append(d.Subusers, models.DomainSubuser{UserId: m.findSubuser(username).Id, Username: username})
+	}
+	m.domains = append(m.domains, d)
+
+	return m
+}
+
+// addBrandedLink adds a branded link associated with the given subusers.
+func (m *memoryClient) addBrandedLink(id int, domain string, subusers ...string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.links = append(m.links, models.BrandedLink{Id: id, Subdomain: "url", Domain: domain, Valid: true})
+	for _, username := range subusers {
+		m.subuserLinks[username] = id
+	}
+
+	return m
+}
+
+func (m *memoryClient) setSubuserUsage(username string, usage models.SubuserUsage) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.usage[username] = usage
+
+	return m
+}
+
+func (m *memoryClient) failSubuserStats(err error) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.statsErr = err
+
+	return m
+}
+
+func (m *memoryClient) failSubuserBrandedLink(username string, err error) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.linkErrs == nil {
+		m.linkErrs = make(map[string]error)
+	}
+	m.linkErrs[username] = err
+
+	return m
+}
+
+// count returns how many calls were made with the prefix.
+func (m *memoryClient) count(prefix string) int {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	n := 0
+	for _, call := range m.calls {
+		if strings.HasPrefix(call, prefix) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (m *memoryClient) addVerifiedSender(id int, email string) *memoryClient {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.senders = append(m.senders, models.VerifiedSender{Id: id, Nickname: email, FromEmail: email, Verified: true})
+
+	return m
+}
+
+func (m *memoryClient) hasVerifiedSender(id int) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return slices.ContainsFunc(m.senders, func(sender models.VerifiedSender) bool {
+		return sender.Id == id
+	})
+}
+
+// domainSubusers returns the usernames of the subusers an authenticated
+// domain is associated with.
+func (m *memoryClient) domainSubusers(id int) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	var rv []string
+	if d := m.findDomain(id); d != nil {
+		for _, subuser := range d.Subusers {
+			rv = append(rv, subuser.Username)
+		}
+	}
+
+	return rv
+}
+
+// subuserLink returns the ID of the branded link associated with a subuser.
+func (m *memoryClient) subuserLink(username string) (int, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	id, ok := m.subuserLinks[username]
+
+	return id, ok
+}
+
+func (m *memoryClient) findDomain(id int) *models.AuthenticatedDomain {
+	for _, d := range m.domains {
+		if d.Id == id {
+			return d
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryClient) findSubuser(username string) *models.Subuser {
+	for _, subuser := range m.subusers {
+		if subuser.Username == username {
+			return subuser
+		}
+	}
+
+	return nil
+}
+
+// poolIPs returns the IPs of a pool, and whether the pool exists.
+func (m *memoryClient) poolIPs(name string) ([]string, bool) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	pool := m.findPool(name)
+	if pool == nil {
+		return nil, false
+	}
+
+	var rv []string
+	for _, ip := range pool.Ips {
+		rv = append(rv, ip.Ip)
+	}
+
+	return rv, true
+}
+
+func (m *memoryClient) findPool(name string) *models.IPPoolDetails {
+	for _, pool := range m.pools {
+		if pool.PoolName == name {
+			return pool
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryClient) subusersOf(ip string) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	for _, i := range m.ips {
+		if i.Ip == ip {
+			return slices.Clone(i.Subusers)
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryClient) scopesOf(username string) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	t := m.findTeammate("", username)
+	if t == nil {
+		return nil
+	}
+
+	return slices.Clone(t.Scopes)
+}
+
+func (m *memoryClient) isAdmin(username string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	t := m.findTeammate("", username)
+
+	return t != nil && t.IsAdmin
+}
+
+func (m *memoryClient) called(prefix string) bool {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return slices.ContainsFunc(m.calls, func(call string) bool {
+		return strings.HasPrefix(call, prefix)
+	})
+}
+
+func (m *memoryClient) record(format string, args ...interface{}) {
+	m.calls = append(m.calls, fmt.Sprintf(format, args...))
+}
+
+func (m *memoryClient) findTeammate(subuser string, username string) *models.TeammateScope {
+	for _, t := range m.teammates[subuser] {
+		if t.Username == username {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// page returns the page of items selected by the offset token, and the
+// token of the next page.
+func page[T any](m *memoryClient, items []T, pToken *pagination.Token) ([]T, string, error) {
+	offset := 0
+	if pToken != nil && pToken.Token != "" {
+		var err error
+		offset, err = strconv.Atoi(pToken.Token)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if offset >= len(items) {
+		return nil, "", nil
+	}
+
+	end := min(offset+m.pageLimit, len(items))
+	next := ""
+	if end-offset == m.pageLimit {
+		next = strconv.Itoa(end)
+	}
+
+	return slices.Clone(items[offset:end]), next, nil
+}
+
+func (m *memoryClient) InviteTeammate(ctx context.Context, email string, scopes []string, isAdmin bool) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("InviteTeammate %s", email)
+	m.pending = append(m.pending, models.PendingUserAccess{
+		Token:   "token-" + email,
+		Email:   email,
+		Scopes:  scopes,
+		IsAdmin: isAdmin,
+	})
+
+	return nil
+}
+
+func (m *memoryClient) DeleteTeammate(ctx context.Context, username string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	subuser := client.OnBehalfOf(ctx)
+	m.record("DeleteTeammate %s", username)
+
+	index := slices.IndexFunc(m.teammates[subuser], func(t *models.TeammateScope) bool {
+		return t.Username == username
+	})
+	if index < 0 {
+		return fmt.Errorf("teammate %s not found", username)
+	}
+
+	m.teammates[subuser] = slices.Delete(m.teammates[subuser], index, index+1)
+
+	return nil
+}
+
+func (m *memoryClient) GetSpecificTeammate(ctx context.Context, username string) (*models.TeammateScope, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("GetSpecificTeammate %s", username)
+
+	t := m.findTeammate(client.OnBehalfOf(ctx), username)
+	if t == nil {
+		return nil, fmt.Errorf("teammate %s: %w", username, client.ErrNotFound)
+	}
+
+	rv := *t
+	rv.Scopes = slices.Clone(t.Scopes)
+
+	return &rv, nil
+}
+
+func (m *memoryClient) GetTeammates(ctx context.Context, pToken *pagination.Token) ([]models.Teammate, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	teammates, next, err := page(m, m.teammates[client.OnBehalfOf(ctx)], pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rv := make([]models.Teammate, len(teammates))
+	for i, t := range teammates {
+		rv[i] = t.Teammate
+	}
+
+	return rv, next, nil
+}
+
+func (m *memoryClient) GetTeammatesSubAccess(ctx context.Context, username string, pToken *pagination.Token) (*models.TeammateSubuserResponse, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("GetTeammatesSubAccess %s", username)
+
+	access, ok := m.subuserAccess[username]
+	if !ok {
+		return &models.TeammateSubuserResponse{HasRestrictedSubuserAccess: true}, "", nil
+	}
+
+	subuserAccess, next, err := page(m, access.SubuserAccess, pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &models.TeammateSubuserResponse{
+		HasRestrictedSubuserAccess: access.HasRestrictedSubuserAccess,
+		SubuserAccess:              subuserAccess,
+	}, next, nil
+}
+
+func (m *memoryClient) GetPendingTeammates(ctx context.Context, pToken *pagination.Token) ([]models.PendingUserAccess, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return page(m, m.pending, pToken)
+}
+
+func (m *memoryClient) DeletePendingTeammate(ctx context.Context, token string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("DeletePendingTeammate %s", token)
+
+	index := slices.IndexFunc(m.pending, func(p models.PendingUserAccess) bool {
+		return p.Token == token
+	})
+	if index < 0 {
+		return fmt.Errorf("invite %s not found", token)
+	}
+
+	m.pending = slices.Delete(m.pending, index, index+1)
+
+	return nil
+}
+
+func (m *memoryClient) ResendTeammateInvite(ctx context.Context, token string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("ResendTeammateInvite %s", token)
+
+	index := slices.IndexFunc(m.pending, func(p models.PendingUserAccess) bool {
+		return p.Token == token
+	})
+	if index < 0 {
+		return fmt.Errorf("invite %s not found", token)
+	}
+
+	m.pending[index].ExpirationDate = time.Now().Add(7 * 24 * time.Hour).Unix()
+
+	return nil
+}
+
+func (m *memoryClient) SetTeammateScopes(ctx context.Context, username string, scopes []string, isAdmin bool) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("SetTeammateScopes %s %v", username, scopes)
+
+	t := m.findTeammate(client.OnBehalfOf(ctx), username)
+	if t == nil {
+		return fmt.Errorf("teammate %s not found", username)
+	}
+
+	t.Scopes = slices.Clone(scopes)
+	t.IsAdmin = isAdmin
+
+	return nil
+}
+
+func (m *memoryClient) GetSubusers(ctx context.Context, pToken *pagination.Token) ([]models.Subuser, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	subusers, next, err := page(m, m.subusers, pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rv := make([]models.Subuser, len(subusers))
+	for i, s := range subusers {
+		rv[i] = *s
+	}
+
+	return rv, next, nil
+}
+
+func (m *memoryClient) CreateSubuser(ctx context.Context, subuser models.SubuserCreate) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("CreateSubuser %s", subuser.Username)
+	m.subusers = append(m.subusers, &models.Subuser{Id: len(m.subusers) + 1, Username: subuser.Username, Email: subuser.Email})
+
+	return nil
+}
+
+func (m *memoryClient) DeleteSubuser(ctx context.Context, username string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("DeleteSubuser %s", username)
+	m.subusers = slices.DeleteFunc(m.subusers, func(s *models.Subuser) bool {
+		return s.Username == username
+	})
+
+	return nil
+}
+
+func (m *memoryClient) SetSubuserDisabled(ctx context.Context, username string, disabled bool) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("SetSubuserDisabled %s %t", username, disabled)
+	for _, s := range m.subusers {
+		if s.Username == username {
+			s.Disabled = disabled
+			return nil
+		}
+	}
+
+	return fmt.Errorf("subuser %s not found", username)
+}
+
+func (m *memoryClient) GetSubuserReputations(ctx context.Context, usernames []string) ([]models.SubuserReputation, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("GetSubuserReputations %v", usernames)
+
+	var rv []models.SubuserReputation
+	for _, username := range usernames {
+		if usage, ok := m.usage[username]; ok && usage.Reputation != nil {
+			rv = append(rv, models.SubuserReputation{Username: username, Reputation: *usage.Reputation})
+		}
+	}
+
+	return rv, nil
+}
+
+func (m *memoryClient) GetSubuserCredits(ctx context.Context, username string) (*models.SubuserCredits, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	usage, ok := m.usage[username]
+	if !ok || usage.Credits == nil {
+		return nil, fmt.Errorf("credits of subuser %s not found", username)
+	}
+
+	rv := *usage.Credits
+
+	return &rv, nil
+}
+
+func (m *memoryClient) UpdateSubuserCredits(ctx context.Context, username string, credits models.SubuserCreditsUpdate) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("UpdateSubuserCredits %s %s", username, credits.Type)
+
+	usage := m.usage[username]
+	updated := models.SubuserCredits{Type: credits.Type, ResetFrequency: credits.ResetFrequency}
+	if credits.Total != nil {
+		updated.Total = *credits.Total
+		updated.Remain = *credits.Total
+	}
+
+	usage.Credits = &updated
+	m.usage[username] = usage
+
+	return nil
+}
+
+func (m *memoryClient) GetSubuserMonthlyStats(ctx context.Context, date string, pToken *pagination.Token) ([]models.SubuserStats, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("GetSubuserMonthlyStats %s %s", date, pToken.Token)
+
+	if m.statsErr != nil {
+		return nil, "", m.statsErr
+	}
+
+	var stats []models.SubuserStats
+	for _, subuser := range m.subusers {
+		if usage, ok := m.usage[subuser.Username]; ok && usage.Stats != nil {
+			stats = append(stats, models.SubuserStats{Name: subuser.Username, Type: "subuser", Metrics: *usage.Stats})
+		}
+	}
+
+	return page(m, stats, pToken)
+}
+
+func (m *memoryClient) GetApiKeys(ctx context.Context, pToken *pagination.Token) ([]models.ApiKey, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return page(m, m.apiKeys[client.OnBehalfOf(ctx)], pToken)
+}
+
+func (m *memoryClient) GetUsername(ctx context.Context) (*models.UserUsername, error) {
+	rv := m.account.Username
+	return &rv, nil
+}
+
+func (m *memoryClient) GetUserAccount(ctx context.Context) (*models.UserAccount, error) {
+	rv := m.account.Account
+	return &rv, nil
+}
+
+func (m *memoryClient) GetUserProfile(ctx context.Context) (*models.UserProfile, error) {
+	rv := m.account.Profile
+	return &rv, nil
+}
+
+func (m *memoryClient) GetUserEmail(ctx context.Context) (*models.UserEmail, error) {
+	rv := m.account.Email
+	return &rv, nil
+}
+
+func (m *memoryClient) GetIPs(ctx context.Context, pToken *pagination.Token) ([]models.IPAddress, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	ips, next, err := page(m, m.ips, pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rv := make([]models.IPAddress, len(ips))
+	for i, ip := range ips {
+		rv[i] = *ip
+		rv[i].Subusers = slices.Clone(ip.Subusers)
+	}
+
+	return rv, next, nil
+}
+
+func (m *memoryClient) GetAssignedIPs(ctx context.Context) ([]models.AssignedIPAddress, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("GetAssignedIPs")
+
+	rv := make([]models.AssignedIPAddress, len(m.assignedIPs))
+	for i, ip := range m.assignedIPs {
+		rv[i] = models.AssignedIPAddress{Ip: ip}
+	}
+
+	return rv, nil
+}
+
+func (m *memoryClient) SetSubuserIPs(ctx context.Context, username string, ips []string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("SetSubuserIPs %s %v", username, ips)
+
+	for _, ip := range m.ips {
+		ip.Subusers = slices.DeleteFunc(ip.Subusers, func(subuser string) bool {
+			return subuser == username
+		})
+
+		if slices.Contains(ips, ip.Ip) {
+			ip.Subusers = append(ip.Subusers, username)
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryClient) GetIPPools(ctx context.Context) ([]models.IPPool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	rv := make([]models.IPPool, len(m.pools))
+	for i, pool := range m.pools {
+		rv[i] = models.IPPool{Name: pool.PoolName}
+	}
+
+	return rv, nil
+}
+
+func (m *memoryClient) GetIPPool(ctx context.Context, name string) (*models.IPPoolDetails, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	pool := m.findPool(name)
+	if pool == nil {
+		return nil, fmt.Errorf("ip pool %s not found", name)
+	}
+
+	rv := *pool
+	rv.Ips = slices.Clone(pool.Ips)
+
+	return &rv, nil
+}
+
+func (m *memoryClient) CreateIPPool(ctx context.Context, name string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("CreateIPPool %s", name)
+
+	if m.findPool(name) != nil {
+		return fmt.Errorf("ip pool %s exists", name)
+	}
+
+	m.pools = append(m.pools, &models.IPPoolDetails{PoolName: name})
+
+	return nil
+}
+
+func (m *memoryClient) DeleteIPPool(ctx context.Context, name string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("DeleteIPPool %s", name)
+
+	if m.findPool(name) == nil {
+		return fmt.Errorf("ip pool %s not found", name)
+	}
+
+	m.pools = slices.DeleteFunc(m.pools, func(pool *models.IPPoolDetails) bool {
+		return pool.PoolName == name
+	})
+
+	return nil
+}
+
+func (m *memoryClient) AddIPToPool(ctx context.Context, name string, ip string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("AddIPToPool %s %s", name, ip)
+
+	pool := m.findPool(name)
+	if pool == nil {
+		return fmt.Errorf("ip pool %s not found", name)
+	}
+
+	pool.Ips = append(pool.Ips, models.IPPoolAddress{Ip: ip})
+
+	return nil
+}
+
+func (m *memoryClient) RemoveIPFromPool(ctx context.Context, name string, ip string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("RemoveIPFromPool %s %s", name, ip)
+
+	pool := m.findPool(name)
+	if pool == nil {
+		return fmt.Errorf("ip pool %s not found", name)
+	}
+
+	pool.Ips = slices.DeleteFunc(pool.Ips, func(poolIP models.IPPoolAddress) bool {
+		return poolIP.Ip == ip
+	})
+
+	return nil
+}
+
+func (m *memoryClient) GetAuthenticatedDomains(ctx context.Context, pToken *pagination.Token) ([]models.AuthenticatedDomain, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	domains, next, err := page(m, m.domains, pToken)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rv := make([]models.AuthenticatedDomain, len(domains))
+	for i, d := range domains {
+		rv[i] = *d
+		rv[i].Subusers = slices.Clone(d.Subusers)
+	}
+
+	return rv, next, nil
+}
+
+func (m *memoryClient) GetAuthenticatedDomain(ctx context.Context, id int) (*models.AuthenticatedDomain, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	d := m.findDomain(id)
+	if d == nil {
+		return nil, fmt.Errorf("authenticated domain %d not found", id)
+	}
+
+	rv := *d
+	rv.Subusers = slices.Clone(d.Subusers)
+
+	return &rv, nil
+}
+
+func (m *memoryClient) AddAuthenticatedDomainToSubuser(ctx context.Context, id int, username string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("AddAuthenticatedDomainToSubuser %d %s", id, username)
+
+	d := m.findDomain(id)
+	if d == nil {
+		return fmt.Errorf("authenticated domain %d not found", id)
+	}
+
+	subuser := m.findSubuser(username)
+	if subuser == nil {
+		return fmt.Errorf("subuser %s not found", username)
+	}
+
+	d.Subusers = append(d.Subusers, models.DomainSubuser{UserId: subuser.Id, Username: username})
+
+	return nil
+}
+
+func (m *memoryClient) RemoveAuthenticatedDomainFromSubuser(ctx context.Context, id int, username string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("RemoveAuthenticatedDomainFromSubuser %d %s", id, username)
+
+	d := m.findDomain(id)
+	if d == nil {
+		return fmt.Errorf("authenticated domain %d not found", id)
+	}
+
+	d.Subusers = slices.DeleteFunc(d.Subusers, func(subuser models.DomainSubuser) bool {
+		return subuser.Username == username
+	})
+
+	return nil
+}
+
+func (m *memoryClient) GetBrandedLinks(ctx context.Context) ([]models.BrandedLink, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	return slices.Clone(m.links), nil
+}
+
+func (m *memoryClient) GetSubuserBrandedLink(ctx context.Context, username string) (*models.BrandedLink, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("GetSubuserBrandedLink %s", username)
+
+	if err := m.linkErrs[username]; err != nil {
+		return nil, err
+	}
+
+	id, ok := m.subuserLinks[username]
+	if !ok {
+		return nil, nil
+	}
+
+	for _, link := range m.links {
+		if link.Id == id {
+			return &link, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (m *memoryClient) AssociateBrandedLinkWithSubuser(ctx context.Context, id int, username string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("AssociateBrandedLinkWithSubuser %d %s", id, username)
+
+	m.subuserLinks[username] = id
+
+	return nil
+}
+
+func (m *memoryClient) DisassociateBrandedLinkFromSubuser(ctx context.Context, username string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("DisassociateBrandedLinkFromSubuser %s", username)
+
+	delete(m.subuserLinks, username)
+
+	return nil
+}
+
+// GetVerifiedSenders pages the verified senders by the ID of the last sender
+// seen, like SendGrid does.
+func (m *memoryClient) GetVerifiedSenders(ctx context.Context, pToken *pagination.Token) ([]models.VerifiedSender, string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	lastSeenID := 0
+	if pToken != nil && pToken.Token != "" {
+		var err error
+		lastSeenID, err = strconv.Atoi(pToken.Token)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var rv []models.VerifiedSender
+	for _, sender := range m.senders {
+		if sender.Id > lastSeenID && len(rv) < m.pageLimit {
+			rv = append(rv, sender)
+		}
+	}
+
+	if len(rv) < m.pageLimit {
+		return rv, "", nil
+	}
+
+	return rv, strconv.Itoa(rv[len(rv)-1].Id), nil
+}
+
+func (m *memoryClient) DeleteVerifiedSender(ctx context.Context, id int) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.record("DeleteVerifiedSender %d", id)
+
+	m.senders = slices.DeleteFunc(m.senders, func(sender models.VerifiedSender) bool {
+		return sender.Id == id
+	})
+
+	return nil
+}
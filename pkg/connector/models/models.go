@@ -37,12 +37,11 @@ type PaginationData struct {
 }
 
 type PendingUserAccess struct {
-	Id             int    `json:"id"`
-	ScopeGroupName string `json:"scope_group_name"`
-	Username       string `json:"username"`
-	Email          string `json:"email"`
-	FirstName      string `json:"first_name"`
-	LastName       string `json:"last_name"`
+	Token          string   `json:"token"`
+	Email          string   `json:"email"`
+	Scopes         []string `json:"scopes"`
+	IsAdmin        bool     `json:"is_admin"`
+	ExpirationDate int64    `json:"expiration_date"`
 }
 
 type Subuser struct {
@@ -83,3 +82,201 @@ type TeammateSubuserResponse struct {
 		NextParams NextParams `json:"next_params,omitempty"`
 	} `json:"_metadata"`
 }
+
+type UserUsername struct {
+	Username string `json:"username"`
+	UserId   int    `json:"user_id"`
+}
+
+type UserAccount struct {
+	Type       string  `json:"type"`
+	Reputation float64 `json:"reputation"`
+}
+
+type UserProfile struct {
+	Address   string `json:"address"`
+	Address2  string `json:"address2"`
+	City      string `json:"city"`
+	Company   string `json:"company"`
+	Country   string `json:"country"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Phone     string `json:"phone"`
+	State     string `json:"state"`
+	Website   string `json:"website"`
+	Zip       string `json:"zip"`
+}
+
+type UserEmail struct {
+	Email string `json:"email"`
+}
+
+// AccountDetails is everything the user API tells about a SendGrid account.
+type AccountDetails struct {
+	Username UserUsername
+	Account  UserAccount
+	Profile  UserProfile
+	Email    UserEmail
+}
+
+type ApiKey struct {
+	ApiKeyId string `json:"api_key_id"`
+	Name     string `json:"name"`
+}
+
+// IPAddress is an IP address of the account.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-address/retrieve-all-ip-addresses
+type IPAddress struct {
+	Ip           string   `json:"ip"`
+	Subusers     []string `json:"subusers"`
+	Rdns         string   `json:"rdns"`
+	Pools        []string `json:"pools"`
+	Warmup       bool     `json:"warmup"`
+	StartDate    int64    `json:"start_date"`
+	Whitelabeled bool     `json:"whitelabeled"`
+	AssignedAt   int64    `json:"assigned_at"`
+}
+
+// AssignedIPAddress is an IP address assigned to the account for sending.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-address/retrieve-all-assigned-ips
+type AssignedIPAddress struct {
+	Ip        string   `json:"ip"`
+	Pools     []string `json:"pools"`
+	Warmup    bool     `json:"warmup"`
+	StartDate int64    `json:"start_date"`
+}
+
+// IPPool is a named group of IP addresses mail can be sent from.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/retrieve-all-ip-pools
+type IPPool struct {
+	Name string `json:"name"`
+}
+
+// IPPoolDetails is an IP pool with its IP addresses.
+// https://www.twilio.com/docs/sendgrid/api-reference/ip-pools/retrieve-all-the-ips-in-a-specified-pool
+type IPPoolDetails struct {
+	PoolName string          `json:"pool_name"`
+	Ips      []IPPoolAddress `json:"ips"`
+}
+
+type IPPoolAddress struct {
+	Ip        string `json:"ip"`
+	StartDate int64  `json:"start_date"`
+	Warmup    bool   `json:"warmup"`
+}
+
+// AuthenticatedDomain is a domain the account is authenticated to send from.
+// https://www.twilio.com/docs/sendgrid/api-reference/domain-authentication/list-all-authenticated-domains
+type AuthenticatedDomain struct {
+	Id        int             `json:"id"`
+	UserId    int             `json:"user_id"`
+	Subdomain string          `json:"subdomain"`
+	Domain    string          `json:"domain"`
+	Username  string          `json:"username"`
+	Default   bool            `json:"default"`
+	Legacy    bool            `json:"legacy"`
+	Valid     bool            `json:"valid"`
+	Subusers  []DomainSubuser `json:"subusers"`
+}
+
+// DomainSubuser is a subuser an authenticated domain is associated with.
+type DomainSubuser struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// BrandedLink is a link branding record of the account.
+// https://www.twilio.com/docs/sendgrid/api-reference/link-branding/retrieve-all-branded-links
+type BrandedLink struct {
+	Id        int    `json:"id"`
+	UserId    int    `json:"user_id"`
+	Subdomain string `json:"subdomain"`
+	Domain    string `json:"domain"`
+	Username  string `json:"username"`
+	Default   bool   `json:"default"`
+	Legacy    bool   `json:"legacy"`
+	Valid     bool   `json:"valid"`
+}
+
+// VerifiedSender is a single sender verification, allowing mail to be sent
+// from its address.
+// https://www.twilio.com/docs/sendgrid/api-reference/sender-verification/get-all-verified-senders
+type VerifiedSender struct {
+	Id          int    `json:"id"`
+	Nickname    string `json:"nickname"`
+	FromEmail   string `json:"from_email"`
+	FromName    string `json:"from_name"`
+	ReplyTo     string `json:"reply_to"`
+	ReplyToName string `json:"reply_to_name"`
+	Address     string `json:"address"`
+	Address2    string `json:"address2"`
+	City        string `json:"city"`
+	State       string `json:"state"`
+	Zip         string `json:"zip"`
+	Country     string `json:"country"`
+	Verified    bool   `json:"verified"`
+	Locked      bool   `json:"locked"`
+}
+
+type VerifiedSendersResponse struct {
+	Results []VerifiedSender `json:"results"`
+}
+
+// SubuserReputation is the sender reputation of a subuser.
+// https://www.twilio.com/docs/sendgrid/api-reference/subusers-api/retrieve-subuser-reputations
+type SubuserReputation struct {
+	Username   string  `json:"username"`
+	Reputation float64 `json:"reputation"`
+}
+
+// SubuserCredits is the credit allocation of a subuser.
+// https://www.twilio.com/docs/sendgrid/api-reference/subusers-api/get-a-subusers-credits
+type SubuserCredits struct {
+	Type           string `json:"type"`
+	ResetFrequency string `json:"reset_frequency"`
+	Remain         int    `json:"remain"`
+	Total          int    `json:"total"`
+	Used           int    `json:"used"`
+}
+
+// SubuserMonthlyStats is the sending stats of the subusers over a month.
+// https://www.twilio.com/docs/sendgrid/api-reference/subuser-statistics/retrieve-monthly-stats-for-all-subusers
+type SubuserMonthlyStats struct {
+	Date  string         `json:"date"`
+	Stats []SubuserStats `json:"stats"`
+}
+
+type SubuserStats struct {
+	Name    string       `json:"name"`
+	Type    string       `json:"type"`
+	Metrics StatsMetrics `json:"metrics"`
+}
+
+type StatsMetrics struct {
+	Requests     int `json:"requests"`
+	Delivered    int `json:"delivered"`
+	Bounces      int `json:"bounces"`
+	Blocks       int `json:"blocks"`
+	SpamReports  int `json:"spam_reports"`
+	Opens        int `json:"opens"`
+	Clicks       int `json:"clicks"`
+	Unsubscribes int `json:"unsubscribes"`
+}
+
+// SubuserUsage is the reputation, credits and monthly sending stats of a
+// subuser, each of them nil when unknown.
+type SubuserUsage struct {
+	Reputation *float64
+	Credits    *SubuserCredits
+	StatsMonth string
+	Stats      *StatsMetrics
+}
+
+// SubuserCreditsUpdate changes the credit allocation of a subuser, the total
+// and reset frequency only applying to limited allocations.
+// https://www.twilio.com/docs/sendgrid/api-reference/subusers-api/update-a-subusers-credits
+type SubuserCreditsUpdate struct {
+	Type           string `json:"type"`
+	ResetFrequency string `json:"reset_frequency,omitempty"`
+	Total          *int   `json:"total,omitempty"`
+}
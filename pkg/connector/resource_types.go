@@ -4,12 +4,28 @@ import v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 
 // The user resource type is for all user objects from the database.
 var (
+	accountResourceType = &v2.ResourceType{
+		Id:          "account",
+		DisplayName: "Account",
+		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_APP},
+	}
+
 	teammateResourceType = &v2.ResourceType{
 		Id:          "teammate",
 		DisplayName: "teammate",
 		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_USER},
 	}
 
+	inviteResourceType = &v2.ResourceType{
+		Id:          "invite",
+		DisplayName: "Pending Invite",
+	}
+
+	scopeCategoryResourceType = &v2.ResourceType{
+		Id:          "scope_category",
+		DisplayName: "Scope Category",
+	}
+
 	scopeResourceType = &v2.ResourceType{
 		Id:          "scope",
 		DisplayName: "Scope",
@@ -20,4 +36,39 @@ var (
 		DisplayName: "Subuser",
 		Traits:      []v2.ResourceType_Trait{v2.ResourceType_TRAIT_USER},
 	}
+
+	apiKeyResourceType = &v2.ResourceType{
+		Id:          "api_key",
+		DisplayName: "API Key",
+	}
+
+	creditAllocationResourceType = &v2.ResourceType{
+		Id:          "credit_allocation",
+		DisplayName: "Credit Allocation",
+	}
+
+	ipAddressResourceType = &v2.ResourceType{
+		Id:          "ip_address",
+		DisplayName: "IP Address",
+	}
+
+	ipPoolResourceType = &v2.ResourceType{
+		Id:          "ip_pool",
+		DisplayName: "IP Pool",
+	}
+
+	authenticatedDomainResourceType = &v2.ResourceType{
+		Id:          "authenticated_domain",
+		DisplayName: "Authenticated Domain",
+	}
+
+	brandedLinkResourceType = &v2.ResourceType{
+		Id:          "branded_link",
+		DisplayName: "Branded Link",
+	}
+
+	verifiedSenderResourceType = &v2.ResourceType{
+		Id:          "verified_sender",
+		DisplayName: "Verified Sender",
+	}
 )
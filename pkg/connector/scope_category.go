@@ -0,0 +1,286 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	allScopesEntitlement = "all"
+)
+
+// scopeSubcategories are scope prefixes deeper than the first segment that
+// are big enough to deserve their own category.
+var scopeSubcategories = []string{
+	"ips.pools",
+	"ips.warmup",
+	"user.webhooks",
+}
+
+// ScopeCategory returns the category a scope belongs to, which is its first
+// segment unless a longer prefix is listed in scopeSubcategories.
+func ScopeCategory(scope Scope) string {
+	s := string(scope)
+
+	category := ""
+	for _, sub := range scopeSubcategories {
+		if strings.HasPrefix(s, sub+".") && len(sub) > len(category) {
+			category = sub
+		}
+	}
+
+	if category != "" {
+		return category
+	}
+
+	category, _, _ = strings.Cut(s, ".")
+
+	return category
+}
+
+// ScopeCategories returns the categories of SendGridScopes in order of first appearance.
+func ScopeCategories() []string {
+	var rv []string
+
+	for _, scope := range SendGridScopes {
+		category := ScopeCategory(scope)
+		if !slices.Contains(rv, category) {
+			rv = append(rv, category)
+		}
+	}
+
+	return rv
+}
+
+// ScopesForCategory returns every scope of SendGridScopes in the category.
+func ScopesForCategory(category string) []Scope {
+	var rv []Scope
+
+	for _, scope := range SendGridScopes {
+		if ScopeCategory(scope) == category {
+			rv = append(rv, scope)
+		}
+	}
+
+	return rv
+}
+
+type scopeCategoryBuilder struct {
+	resourceType *v2.ResourceType
+	accounts     *accountSet
+}
+
+func (r *scopeCategoryBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return scopeCategoryResourceType
+}
+
+func (r *scopeCategoryBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	if parentResourceID == nil {
+		return nil, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if pToken == nil || pToken.Token == "" {
+		err := acc.scopeCache.buildCache(ctx)
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+
+	categories := ScopeCategories()
+	rv := make([]*v2.Resource, len(categories))
+
+	for i, category := range categories {
+		rb, err := scopeCategoryResource(ctx, category, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv[i] = rb
+	}
+
+	return rv, "", nil, nil
+}
+
+func (r *scopeCategoryBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	var rv []*v2.Entitlement
+	assigmentOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(teammateResourceType),
+		ent.WithDescription(fmt.Sprintf("Assigned %s to every scope of the category", teammateResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s all %s scopes", teammateResourceType.DisplayName, resource.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, allScopesEntitlement, assigmentOptions...))
+
+	return rv, "", nil, nil
+}
+
+func (r *scopeCategoryBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	accountID, category, err := splitAccountScopedID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	acc, err := r.accounts.get(ctx, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Restricted teammates are granted the category without its admin-only
+	// scopes, so they hold it once they hold the rest of its scopes.
+	categoryScopes := ScopesForCategory(category)
+	restrictedScopes := grantableScopes(&models.TeammateScope{}, categoryScopes)
+	if len(restrictedScopes) == 0 {
+		restrictedScopes = categoryScopes
+	}
+
+	users := acc.scopeCache.GetUsersForScopes(restrictedScopes)
+
+	var rv []*v2.Grant
+
+	for _, user := range users {
+		if !holdsScopes(user, grantableScopes(user, categoryScopes)) {
+			continue
+		}
+
+		userR, err := teammateResource(ctx, &user.Teammate, accountResourceID(accountID))
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, grant.NewGrant(resource, allScopesEntitlement, userR.Id))
+	}
+
+	return rv, "", nil, nil
+}
+
+// ResourceProvisioner
+
+func (r *scopeCategoryBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, principalUsername, category, err := r.accounts.forTeammateGrant(ctx, principal.Id, entitlement.Resource.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed, err := acc.teammateScopes.apply(ctx, principalUsername, func(ctx context.Context, teammate *models.TeammateScope) (bool, error) {
+		// Restricted teammates are given every scope of the category but the
+		// admin-only ones, which SendGrid would refuse them.
+		categoryScopes := grantableScopes(teammate, ScopesForCategory(category))
+		if len(categoryScopes) == 0 {
+			return false, status.Errorf(
+				codes.FailedPrecondition,
+				"baton-sendgrid: the %s scopes can only be held by admin teammates, %s is a restricted teammate",
+				category,
+				principalUsername,
+			)
+		}
+
+		var added []Scope
+		for _, scope := range categoryScopes {
+			scopes, err := scopesToGrant(teammate, scope)
+			if err != nil {
+				return false, err
+			}
+
+			for _, s := range scopes {
+				if !slices.Contains(added, s) {
+					added = append(added, s)
+				}
+			}
+		}
+
+		for _, scope := range added {
+			teammate.Scopes = append(teammate.Scopes, string(scope))
+		}
+
+		return len(added) > 0, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !changed {
+		l.Info(
+			"baton-sendgrid: scope category already granted to teammate",
+			zap.String("category", category),
+			zap.String("teammate", principalUsername),
+		)
+
+		return []*v2.Grant{}, annotations.New(&v2.GrantAlreadyExists{}), nil
+	}
+
+	return []*v2.Grant{grant.NewGrant(entitlement.Resource, allScopesEntitlement, principal.Id)}, nil, nil
+}
+
+func (r *scopeCategoryBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
+	l := ctxzap.Extract(ctx)
+
+	acc, principalUsername, category, err := r.accounts.forTeammateGrant(ctx, grant.Principal.Id, grant.Entitlement.Resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := acc.teammateScopes.apply(ctx, principalUsername, func(ctx context.Context, teammate *models.TeammateScope) (bool, error) {
+		categoryScopes := ScopesForCategory(category)
+		remaining := slices.DeleteFunc(slices.Clone(teammate.Scopes), func(c string) bool {
+			return slices.Contains(categoryScopes, Scope(c))
+		})
+
+		if len(remaining) == len(teammate.Scopes) {
+			return false, nil
+		}
+
+		err := acc.protectPrivileges(ctx, teammate)
+		if err != nil {
+			return false, err
+		}
+
+		// Admins implicitly hold every scope, taking some away demotes the
+		// teammate to a restricted teammate keeping the remaining scopes.
+		teammate.Scopes = remaining
+		teammate.IsAdmin = false
+
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !changed {
+		l.Info(
+			"baton-sendgrid: scope category not found in teammate",
+			zap.String("category", category),
+			zap.String("teammate", principalUsername),
+		)
+
+		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
+	}
+
+	return nil, nil
+}
+
+func newScopeCategoryBuilder(accounts *accountSet) *scopeCategoryBuilder {
+	return &scopeCategoryBuilder{
+		resourceType: scopeCategoryResourceType,
+		accounts:     accounts,
+	}
+}
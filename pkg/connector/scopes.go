@@ -18,8 +18,7 @@ import (
 
 type scopeBuilder struct {
 	resourceType *v2.ResourceType
-	client       SendGridClient
-	scopeCache   *scopeCache
+	accounts     *accountSet
 }
 
 const (
@@ -31,17 +30,20 @@ func (r *scopeBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 }
 
 func (r *scopeBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	if pToken == nil || pToken.Token == "" {
-		err := r.scopeCache.buildCache(ctx)
-		if err != nil {
-			return nil, "", nil, err
-		}
+	if parentResourceID == nil || parentResourceID.ResourceType != scopeCategoryResourceType.Id {
+		return nil, "", nil, nil
+	}
+
+	_, category, err := splitAccountScopedID(parentResourceID.Resource)
+	if err != nil {
+		return nil, "", nil, err
 	}
 
-	rv := make([]*v2.Resource, len(SendGridScopes))
+	scopes := ScopesForCategory(category)
+	rv := make([]*v2.Resource, len(scopes))
 
-	for i, scope := range SendGridScopes {
-		rb, err := scopeResource(ctx, scope, nil)
+	for i, scope := range scopes {
+		rb, err := scopeResource(ctx, scope, parentResourceID)
 		if err != nil {
 			return nil, "", nil, err
 		}
@@ -65,21 +67,44 @@ func (r *scopeBuilder) Entitlements(_ context.Context, resource *v2.Resource, _
 }
 
 func (r *scopeBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	scope := resource.Id.Resource
+	accountID, scope, err := splitAccountScopedID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
 
-	users := r.scopeCache.GetUsersForScope(scope)
+	acc, err := r.accounts.get(ctx, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	users := acc.scopeCache.GetUsersForScope(scope)
 
 	var rv []*v2.Grant
 
 	for _, user := range users {
-		userGrants, err := createGrantToScopeFromTeammateScope(ctx, resource, user)
+		userGrant, err := createGrantToScopeFromTeammateScope(ctx, resource, accountID, user)
 		if err != nil {
 			return nil, "", nil, err
 		}
 
-		rv = append(rv, userGrants...)
+		rv = append(rv, userGrant)
+	}
+
+	// Holders of the category entitlement are implied to hold every scope in it.
+	categoryR, err := scopeCategoryResource(ctx, ScopeCategory(Scope(scope)), accountResourceID(accountID))
+	if err != nil {
+		return nil, "", nil, err
 	}
 
+	rv = append(rv, grant.NewGrant(
+		resource,
+		assignedEntitlement,
+		categoryR.Id,
+		grant.WithAnnotation(&v2.GrantExpandable{
+			EntitlementIds: []string{ent.NewEntitlementID(categoryR, allScopesEntitlement)},
+		}),
+	))
+
 	return rv, "", nil, nil
 }
 
@@ -88,22 +113,46 @@ func (r *scopeBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken
 func (r *scopeBuilder) Grant(ctx context.Context, principal *v2.Resource, entitlement *v2.Entitlement) ([]*v2.Grant, annotations.Annotations, error) {
 	l := ctxzap.Extract(ctx)
 
-	if principal.Id.ResourceType != teammateResourceType.Id {
-		return nil, nil, fmt.Errorf("baton-sendgrid: principal resource type is not %s", teammateResourceType.Id)
+	acc, principalUsername, scopeId, err := r.accounts.forTeammateGrant(ctx, principal.Id, entitlement.Resource.Id)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	scopeId := entitlement.Resource.Id.Resource
-	principalUsername := principal.Id.Resource
+	var granted models.TeammateScope
+	changed, err := acc.teammateScopes.apply(ctx, principalUsername, func(ctx context.Context, teammate *models.TeammateScope) (bool, error) {
+		if slices.Contains(teammate.Scopes, scopeId) {
+			return false, nil
+		}
+
+		// SendGrid refuses write scopes without their read counterpart,
+		// which is granted along.
+		scopes, err := scopesToGrant(teammate, Scope(scopeId))
+		if err != nil {
+			return false, err
+		}
+
+		if len(scopes) > 1 {
+			l.Info(
+				"baton-sendgrid: granting the scopes required by the scope",
+				zap.String("scope", scopeId),
+				zap.Any("scopes", scopes),
+				zap.String("teammate", principalUsername),
+			)
+		}
+
+		for _, scope := range scopes {
+			teammate.Scopes = append(teammate.Scopes, string(scope))
+		}
+
+		granted = *teammate
 
-	teammate, err := r.client.GetSpecificTeammate(ctx, principalUsername)
+		return true, nil
+	})
 	if err != nil {
 		return nil, nil, err
 	}
 
-	index := slices.IndexFunc(teammate.Scopes, func(c string) bool {
-		return c == scopeId
-	})
-	if index >= 0 {
+	if !changed {
 		l.Info(
 			"baton-sendgrid: scope already granted to teammate",
 			zap.String("scope", scopeId),
@@ -113,47 +162,47 @@ func (r *scopeBuilder) Grant(ctx context.Context, principal *v2.Resource, entitl
 		return []*v2.Grant{}, annotations.New(&v2.GrantAlreadyExists{}), nil
 	}
 
-	teammate.Scopes = append(teammate.Scopes, scopeId)
-
-	err = r.client.SetTeammateScopes(ctx, principalUsername, teammate.Scopes, teammate.IsAdmin)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	scopeRs, err := scopeResource(ctx, Scope(scopeId), nil)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	grants, err := createGrantToScopeFromTeammateScope(ctx, scopeRs, teammate)
+	userGrant, err := createGrantToScopeFromTeammateScope(ctx, entitlement.Resource, acc.id, &granted)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	return grants, nil, nil
+	return []*v2.Grant{userGrant}, nil, nil
 }
 
 func (r *scopeBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations.Annotations, error) {
 	l := ctxzap.Extract(ctx)
 
-	principal := grant.Principal
-	scopeToRemove := grant.Entitlement.Resource.Id.Resource
+	acc, principalUsername, scopeToRemove, err := r.accounts.forTeammateGrant(ctx, grant.Principal.Id, grant.Entitlement.Resource.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	changed, err := acc.teammateScopes.apply(ctx, principalUsername, func(ctx context.Context, teammate *models.TeammateScope) (bool, error) {
+		if !slices.Contains(teammate.Scopes, scopeToRemove) {
+			return false, nil
+		}
+
+		err := acc.protectPrivileges(ctx, teammate)
+		if err != nil {
+			return false, err
+		}
 
-	principalUsername := principal.Id.Resource
+		teammate.Scopes = slices.DeleteFunc(teammate.Scopes, func(c string) bool {
+			return c == scopeToRemove
+		})
 
-	if principal.Id.ResourceType != teammateResourceType.Id {
-		return nil, fmt.Errorf("baton-sendgrid: principal resource type is not %s", teammateResourceType.Id)
-	}
+		// Admins implicitly hold every scope, taking one away demotes the
+		// teammate to a restricted teammate keeping the remaining scopes.
+		teammate.IsAdmin = false
 
-	teammate, err := r.client.GetSpecificTeammate(ctx, principalUsername)
+		return true, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	index := slices.IndexFunc(teammate.Scopes, func(c string) bool {
-		return c == scopeToRemove
-	})
-	if index < 0 {
+	if !changed {
 		l.Info(
 			"baton-sendgrid: scope not found in teammate",
 			zap.String("scope", scopeToRemove),
@@ -163,46 +212,21 @@ func (r *scopeBuilder) Revoke(ctx context.Context, grant *v2.Grant) (annotations
 		return annotations.New(&v2.GrantAlreadyRevoked{}), nil
 	}
 
-	if index == 0 {
-		teammate.Scopes = teammate.Scopes[1:]
-	} else {
-		teammate.Scopes = append(teammate.Scopes[:index], teammate.Scopes[index+1:]...)
-	}
-
-	err = r.client.SetTeammateScopes(ctx, principalUsername, teammate.Scopes, teammate.IsAdmin)
-	if err != nil {
-		return nil, err
-	}
-
 	return nil, nil
 }
 
-func newScopeBuilder(c SendGridClient, cache *scopeCache) *scopeBuilder {
+func newScopeBuilder(accounts *accountSet) *scopeBuilder {
 	return &scopeBuilder{
 		resourceType: scopeResourceType,
-		client:       c,
-		scopeCache:   cache,
+		accounts:     accounts,
 	}
 }
 
-func createGrantToScopeFromTeammateScope(ctx context.Context, resource *v2.Resource, teammate *models.TeammateScope) ([]*v2.Grant, error) {
-	var rv []*v2.Grant
-	l := ctxzap.Extract(ctx)
-
-	for _, scope := range teammate.Scopes {
-		if scope == "" {
-			l.Warn("empty scope", zap.String("scope", scope))
-			continue
-		}
-
-		userR, err := teammateResource(ctx, &teammate.Teammate, nil)
-		if err != nil {
-			return nil, err
-		}
-
-		grantToUser := grant.NewGrant(resource, assignedEntitlement, userR.Id)
-		rv = append(rv, grantToUser)
+func createGrantToScopeFromTeammateScope(ctx context.Context, resource *v2.Resource, accountID string, teammate *models.TeammateScope) (*v2.Grant, error) {
+	userR, err := teammateResource(ctx, &teammate.Teammate, accountResourceID(accountID))
+	if err != nil {
+		return nil, err
 	}
 
-	return rv, nil
+	return grant.NewGrant(resource, assignedEntitlement, userR.Id), nil
 }
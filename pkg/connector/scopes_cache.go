@@ -7,17 +7,25 @@ import (
 
 	"github.com/conductorone/baton-sdk/pkg/pagination"
 	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
 )
 
 type scopeCache struct {
 	client      SendGridClient
 	scopeToUser map[string][]*models.TeammateScope
+
+	// accountID and teammates persist the scopes of the teammates between
+	// syncs, teammates is nil unless a teammate cache is configured.
+	accountID string
+	teammates *TeammateCache
 }
 
-func newScopeCache(gridClient SendGridClient) *scopeCache {
+func newScopeCache(gridClient SendGridClient, accountID string, teammates *TeammateCache) *scopeCache {
 	return &scopeCache{
 		client:      gridClient,
 		scopeToUser: make(map[string][]*models.TeammateScope),
+		accountID:   accountID,
+		teammates:   teammates,
 	}
 }
 
@@ -28,6 +36,8 @@ func (s *scopeCache) buildCache(ctx context.Context) error {
 
 	s.scopeToUser = make(map[string][]*models.TeammateScope)
 
+	listed := make(map[string]struct{})
+	cached := 0
 	pToken := "0"
 
 	for pToken != "" {
@@ -46,9 +56,18 @@ func (s *scopeCache) buildCache(ctx context.Context) error {
 		}
 
 		for _, teammate := range teammates {
-			specificTeammate, err := s.client.GetSpecificTeammate(ctx, teammate.Username)
-			if err != nil {
-				return err
+			listed[teammate.Username] = struct{}{}
+
+			specificTeammate, ok := s.cachedTeammate(teammate)
+			if ok {
+				cached++
+			} else {
+				specificTeammate, err = s.client.GetSpecificTeammate(ctx, teammate.Username)
+				if err != nil {
+					return err
+				}
+
+				s.teammates.store(s.accountID, teammate, specificTeammate.Scopes)
 			}
 
 			for _, scope := range specificTeammate.Scopes {
@@ -57,11 +76,46 @@ func (s *scopeCache) buildCache(ctx context.Context) error {
 		}
 	}
 
-	l.Info("Cache built for scopes")
+	if s.teammates != nil {
+		s.teammates.retain(s.accountID, listed)
+
+		err := s.teammates.save()
+		if err != nil {
+			l.Warn("baton-sendgrid: failed to save the teammate cache", zap.Error(err))
+		}
+	}
+
+	l.Info("Cache built for scopes", zap.Int("cached_teammates", cached))
 
 	return nil
 }
 
+// cachedTeammate returns the teammate with the scopes persisted by the
+// teammate cache, if they are still current.
+func (s *scopeCache) cachedTeammate(teammate models.Teammate) (*models.TeammateScope, bool) {
+	scopes, ok := s.teammates.scopes(s.accountID, teammate)
+	if !ok {
+		return nil, false
+	}
+
+	return &models.TeammateScope{Teammate: teammate, Scopes: scopes}, true
+}
+
+// forgetTeammate drops the persisted scopes of a teammate whose scopes the
+// connector changed, so the next sync fetches them again.
+func (s *scopeCache) forgetTeammate(ctx context.Context, username string) {
+	if s.teammates == nil {
+		return
+	}
+
+	s.teammates.forget(s.accountID, username)
+
+	err := s.teammates.save()
+	if err != nil {
+		ctxzap.Extract(ctx).Warn("baton-sendgrid: failed to save the teammate cache", zap.Error(err))
+	}
+}
+
 func (s *scopeCache) GetUsersForScope(scope string) []*models.TeammateScope {
 	users, ok := s.scopeToUser[scope]
 
@@ -71,3 +125,26 @@ func (s *scopeCache) GetUsersForScope(scope string) []*models.TeammateScope {
 
 	return []*models.TeammateScope{}
 }
+
+// GetUsersForScopes returns the users holding every one of the scopes.
+func (s *scopeCache) GetUsersForScopes(scopes []Scope) []*models.TeammateScope {
+	if len(scopes) == 0 {
+		return []*models.TeammateScope{}
+	}
+
+	counts := make(map[string]int)
+	for _, scope := range scopes {
+		for _, user := range s.GetUsersForScope(string(scope)) {
+			counts[user.Username]++
+		}
+	}
+
+	var rv []*models.TeammateScope
+	for _, user := range s.GetUsersForScope(string(scopes[0])) {
+		if counts[user.Username] == len(scopes) {
+			rv = append(rv, user)
+		}
+	}
+
+	return rv
+}
@@ -0,0 +1,136 @@
+package connector
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// adminOnlyScopePrefixes are the scopes SendGrid only lets admin teammates
+// hold, restricted teammates being refused them.
+// https://www.twilio.com/docs/sendgrid/ui/account-and-settings/teammates#teammate-permissions
+var adminOnlyScopePrefixes = []string{
+	"billing.",
+	"subusers.",
+	"user.multifactor_authentication.",
+	"user.password.",
+}
+
+// readAction is the action of the scopes SendGrid requires next to the
+// create, update and delete scopes of the same resource.
+const readAction = "read"
+
+// ScopeInfo is what the scope catalog knows about a scope.
+type ScopeInfo struct {
+	// AdminOnly is set for the scopes restricted teammates cannot hold.
+	AdminOnly bool
+	// Requires are the scopes SendGrid refuses the scope without.
+	Requires []Scope
+}
+
+var scopeCatalog = sync.OnceValue(func() map[Scope]ScopeInfo {
+	catalog := make(map[Scope]ScopeInfo, len(SendGridScopes))
+
+	for _, scope := range SendGridScopes {
+		info := ScopeInfo{}
+
+		s := string(scope)
+		for _, prefix := range adminOnlyScopePrefixes {
+			if strings.HasPrefix(s, prefix) {
+				info.AdminOnly = true
+			}
+		}
+
+		resource, action, ok := cutLast(s, ".")
+		if ok && action != readAction {
+			read := Scope(resource + "." + readAction)
+			if slices.Contains(SendGridScopes, read) {
+				info.Requires = append(info.Requires, read)
+			}
+		}
+
+		catalog[scope] = info
+	}
+
+	return catalog
+})
+
+// LookupScope returns what the scope catalog knows about the scope, and
+// whether it is a SendGrid scope at all.
+func LookupScope(scope Scope) (ScopeInfo, bool) {
+	info, ok := scopeCatalog()[scope]
+
+	return info, ok
+}
+
+// scopesToGrant returns the scopes the teammate needs to be given to hold the
+// scope, the scope itself and the scopes it requires which the teammate
+// lacks, refusing the scopes SendGrid does not know or would refuse the
+// teammate.
+func scopesToGrant(teammate *models.TeammateScope, scope Scope) ([]Scope, error) {
+	info, ok := LookupScope(scope)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "baton-sendgrid: %q is not a SendGrid scope", scope)
+	}
+
+	if info.AdminOnly && !teammate.IsAdmin {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"baton-sendgrid: the %s scope can only be held by admin teammates, %s is a restricted teammate",
+			scope,
+			teammate.Username,
+		)
+	}
+
+	var rv []Scope
+	for _, required := range append(slices.Clone(info.Requires), scope) {
+		if !slices.Contains(teammate.Scopes, string(required)) && !slices.Contains(rv, required) {
+			rv = append(rv, required)
+		}
+	}
+
+	return rv, nil
+}
+
+// grantableScopes returns the scopes the teammate can hold, leaving out the
+// admin-only ones for restricted teammates.
+func grantableScopes(teammate *models.TeammateScope, scopes []Scope) []Scope {
+	if teammate.IsAdmin {
+		return scopes
+	}
+
+	var rv []Scope
+	for _, scope := range scopes {
+		info, ok := LookupScope(scope)
+		if !ok || !info.AdminOnly {
+			rv = append(rv, scope)
+		}
+	}
+
+	return rv
+}
+
+// holdsScopes returns whether the teammate holds every one of the scopes.
+func holdsScopes(teammate *models.TeammateScope, scopes []Scope) bool {
+	for _, scope := range scopes {
+		if !slices.Contains(teammate.Scopes, string(scope)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cutLast slices s around the last instance of sep.
+func cutLast(s string, sep string) (string, string, bool) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return s, "", false
+	}
+
+	return s[:i], s[i+len(sep):], true
+}
@@ -0,0 +1,43 @@
+package connector
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestScopeCatalog(t *testing.T) {
+	for scope, want := range map[Scope]ScopeInfo{
+		"alerts.read":                       {},
+		"alerts.create":                     {Requires: []Scope{"alerts.read"}},
+		"ips.pools.ips.delete":              {Requires: []Scope{"ips.pools.ips.read"}},
+		"mail.send":                         {},
+		"billing.update":                    {AdminOnly: true, Requires: []Scope{"billing.read"}},
+		"subusers.credits.remaining.create": {AdminOnly: true, Requires: []Scope{"subusers.credits.remaining.read"}},
+		"user.password.update":              {AdminOnly: true, Requires: []Scope{"user.password.read"}},
+		"user.profile.update":               {Requires: []Scope{"user.profile.read"}},
+	} {
+		info, ok := LookupScope(scope)
+		if !ok {
+			t.Errorf("expected %s in the catalog", scope)
+			continue
+		}
+
+		if info.AdminOnly != want.AdminOnly || !slices.Equal(info.Requires, want.Requires) {
+			t.Errorf("expected %s to be %+v, got %+v", scope, want, info)
+		}
+	}
+
+	if _, ok := LookupScope("alerts.launch"); ok {
+		t.Error("expected an unknown scope to be missing from the catalog")
+	}
+
+	// Every scope a scope requires is itself in the catalog.
+	for _, scope := range SendGridScopes {
+		info, _ := LookupScope(scope)
+		for _, required := range info.Requires {
+			if _, ok := LookupScope(required); !ok {
+				t.Errorf("%s requires %s, which is not a SendGrid scope", scope, required)
+			}
+		}
+	}
+}
@@ -2,22 +2,38 @@ package connector
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
+	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
+	"github.com/conductorone/baton-sdk/pkg/types/grant"
+	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+const (
+	// The subuser entitlements are named after the permission types of the
+	// teammate access to subusers.
+	subuserAdminEntitlement      = "admin"
+	subuserRestrictedEntitlement = "restricted"
 )
 
 type subuserBuilder struct {
 	resourceType   *v2.ResourceType
-	client         SendGridClient
+	accounts       *accountSet
 	ignoreSubusers bool
 }
 
-func newSubuserBuilder(c SendGridClient, ignoreSubusers bool) *subuserBuilder {
+func newSubuserBuilder(accounts *accountSet, ignoreSubusers bool) *subuserBuilder {
 	return &subuserBuilder{
 		resourceType:   subuserResourceType,
-		client:         c,
+		accounts:       accounts,
 		ignoreSubusers: ignoreSubusers,
 	}
 }
@@ -29,17 +45,33 @@ func (r *subuserBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 func (r *subuserBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
 	var rv []*v2.Resource
 
-	if r.ignoreSubusers {
+	if r.ignoreSubusers || parentResourceID == nil {
 		return rv, "", nil, nil
 	}
 
-	subusers, pNextToken, err := r.client.GetSubusers(ctx, pToken)
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// Every sync collects the usage and access of the subusers anew.
+	if pToken == nil || pToken.Token == "" {
+		acc.subuserAccess.reset()
+		acc.subuserStats.reset()
+		acc.credits.reset()
+	}
+
+	subusers, pNextToken, err := acc.client.GetSubusers(ctx, pToken)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
+	usage := subuserUsage(ctx, acc, subusers)
+
 	for _, subuser := range subusers {
-		rb, err := subuserResource(ctx, subuser, nil)
+		acc.rememberSubuser(subuser)
+
+		rb, err := subuserResource(ctx, subuser, usage[subuser.Username], parentResourceID)
 		if err != nil {
 			return nil, "", nil, err
 		}
@@ -56,9 +88,147 @@ func (r *subuserBuilder) List(ctx context.Context, parentResourceID *v2.Resource
 }
 
 func (r *subuserBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
-	return nil, "", nil, nil
+	var rv []*v2.Entitlement
+
+	adminOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(teammateResourceType),
+		ent.WithDescription(fmt.Sprintf("%s with admin access to the %s", teammateResourceType.DisplayName, subuserResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s admin", resource.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, subuserAdminEntitlement, adminOptions...))
+
+	restrictedOptions := []ent.EntitlementOption{
+		ent.WithGrantableTo(teammateResourceType),
+		ent.WithDescription(fmt.Sprintf("%s with restricted access to the %s", teammateResourceType.DisplayName, subuserResourceType.DisplayName)),
+		ent.WithDisplayName(fmt.Sprintf("%s restricted", resource.DisplayName)),
+	}
+	rv = append(rv, ent.NewAssignmentEntitlement(resource, subuserRestrictedEntitlement, restrictedOptions...))
+
+	return rv, "", nil, nil
 }
 
 func (r *subuserBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	return nil, "", nil, nil
+	l := ctxzap.Extract(ctx)
+
+	// Collecting the subuser access costs a request per teammate, which is
+	// not spent when subusers are ignored.
+	if r.ignoreSubusers {
+		return nil, "", nil, nil
+	}
+
+	accountID, localID, err := splitAccountScopedID(resource.Id.Resource)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	subuserID, err := strconv.Atoi(localID)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("baton-sendgrid: invalid subuser id %q", resource.Id.Resource)
+	}
+
+	acc, err := r.accounts.get(ctx, accountID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	access, err := acc.subuserAccess.GetTeammatesForSubuser(ctx, subuserID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	var rv []*v2.Grant
+	for _, a := range access {
+		var entitlement string
+		switch a.permissionType {
+		case subuserAdminEntitlement, subuserRestrictedEntitlement:
+			entitlement = a.permissionType
+		default:
+			l.Warn(
+				"baton-sendgrid: unknown subuser permission type",
+				zap.String("permission_type", a.permissionType),
+				zap.String("teammate", a.username),
+			)
+			continue
+		}
+
+		// The access is collected apart from the teammates, one of which may
+		// have been deleted in between.
+		exists, err := acc.teammateExists(ctx, a.username)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		if !exists {
+			l.Warn(
+				"baton-sendgrid: subuser access of a teammate not found",
+				zap.String("teammate", a.username),
+				zap.Int("subuser_id", subuserID),
+			)
+			continue
+		}
+
+		teammateID, err := rs.NewResourceID(teammateResourceType, newAccountScopedID(accountID, a.username))
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, grant.NewGrant(resource, entitlement, teammateID))
+	}
+
+	return rv, "", nil, nil
+}
+
+// subuserUsage returns the reputation, credits and last month's sending stats
+// of a page of subusers. The reputations are fetched for the whole page and
+// the stats for every subuser at once. The credits are fetched per subuser,
+// SendGrid having no endpoint returning the credits of several subusers, and
+// only once per sync. Usage the API key cannot read is left out of the
+// profiles rather than failing the sync.
+func subuserUsage(ctx context.Context, acc *account, subusers []models.Subuser) map[string]*models.SubuserUsage {
+	l := ctxzap.Extract(ctx)
+
+	rv := make(map[string]*models.SubuserUsage, len(subusers))
+	usernames := make([]string, 0, len(subusers))
+	for _, subuser := range subusers {
+		rv[subuser.Username] = &models.SubuserUsage{}
+		usernames = append(usernames, subuser.Username)
+	}
+
+	if len(usernames) == 0 {
+		return rv
+	}
+
+	reputations, err := acc.client.GetSubuserReputations(ctx, usernames)
+	if err != nil {
+		l.Warn("baton-sendgrid: failed to get subuser reputations", zap.Error(err))
+	}
+
+	for _, reputation := range reputations {
+		if usage, ok := rv[reputation.Username]; ok {
+			usage.Reputation = &reputation.Reputation
+		}
+	}
+
+	var statsErr error
+	for _, username := range usernames {
+		usage := rv[username]
+
+		// The stats of every subuser failing together, the failure is
+		// reported once for the page.
+		usage.StatsMonth, usage.Stats, err = acc.subuserStats.GetStatsForSubuser(ctx, username)
+		if err != nil {
+			statsErr = err
+		}
+
+		usage.Credits, err = acc.credits.GetCreditsForSubuser(ctx, username)
+		if err != nil {
+			l.Warn("baton-sendgrid: failed to get subuser credits", zap.String("subuser", username), zap.Error(err))
+		}
+	}
+
+	if statsErr != nil {
+		l.Warn("baton-sendgrid: failed to get subuser stats", zap.Error(statsErr))
+	}
+
+	return rv
 }
@@ -0,0 +1,142 @@
+package connector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+)
+
+// subuserAccess is the access of a parent account teammate to a subuser.
+type subuserAccess struct {
+	username       string
+	permissionType string
+}
+
+// subuserAccessCache maps subusers to the teammates of the parent account
+// which can act inside them. SendGrid only exposes the access per teammate,
+// so it is collected once for every subuser.
+type subuserAccessCache struct {
+	client SendGridClient
+
+	mtx                sync.Mutex
+	built              bool
+	subuserToTeammates map[int][]subuserAccess
+	// unrestricted holds the teammates without restricted subuser access,
+	// which can act inside every subuser.
+	unrestricted []subuserAccess
+}
+
+func newSubuserAccessCache(gridClient SendGridClient) *subuserAccessCache {
+	return &subuserAccessCache{
+		client: gridClient,
+	}
+}
+
+func (s *subuserAccessCache) buildCache(ctx context.Context) error {
+	l := ctxzap.Extract(ctx)
+
+	l.Info("Building cache for subuser access")
+
+	subuserToTeammates := make(map[int][]subuserAccess)
+	var unrestricted []subuserAccess
+
+	pToken := "0"
+	for pToken != "" {
+		var (
+			teammates []models.Teammate
+			err       error
+		)
+
+		teammates, pToken, err = s.client.GetTeammates(ctx, &pagination.Token{Token: pToken})
+		if err != nil {
+			return err
+		}
+
+		if len(teammates) == 0 {
+			break
+		}
+
+		for _, teammate := range teammates {
+			// The subuser access is paginated by subuser ID, its first page
+			// has no token.
+			accessToken := ""
+			for {
+				var access *models.TeammateSubuserResponse
+
+				access, accessToken, err = s.client.GetTeammatesSubAccess(ctx, teammate.Username, &pagination.Token{Token: accessToken})
+				if err != nil {
+					return err
+				}
+
+				// Teammates without restricted access keep the permissions they
+				// have on the parent account in every subuser.
+				if !access.HasRestrictedSubuserAccess {
+					permissionType := subuserRestrictedEntitlement
+					if teammate.IsAdmin || teammate.UserType == ownerUserType {
+						permissionType = subuserAdminEntitlement
+					}
+
+					unrestricted = append(unrestricted, subuserAccess{
+						username:       teammate.Username,
+						permissionType: permissionType,
+					})
+
+					break
+				}
+
+				for _, a := range access.SubuserAccess {
+					subuserToTeammates[a.Id] = append(subuserToTeammates[a.Id], subuserAccess{
+						username:       teammate.Username,
+						permissionType: a.PermissionType,
+					})
+				}
+
+				if len(access.SubuserAccess) == 0 || accessToken == "" {
+					break
+				}
+			}
+		}
+	}
+
+	s.subuserToTeammates = subuserToTeammates
+	s.unrestricted = unrestricted
+	s.built = true
+
+	l.Info("Cache built for subuser access")
+
+	return nil
+}
+
+// GetTeammatesForSubuser returns the access of the teammates to the subuser.
+func (s *subuserAccessCache) GetTeammatesForSubuser(ctx context.Context, subuserID int) ([]subuserAccess, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.built {
+		err := s.buildCache(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rv := make([]subuserAccess, 0, len(s.subuserToTeammates[subuserID])+len(s.unrestricted))
+	rv = append(rv, s.subuserToTeammates[subuserID]...)
+	rv = append(rv, s.unrestricted...)
+
+	return rv, nil
+}
+
+// reset drops the collected access, so that it is collected again on its next
+// use.
+func (s *subuserAccessCache) reset() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.built = false
+	s.subuserToTeammates = nil
+	s.unrestricted = nil
+}
@@ -0,0 +1,64 @@
+package connector
+
+import (
+	"context"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+)
+
+// subuserCreditsCache holds the credit allocations of the subusers of an
+// account. SendGrid only exposes them per subuser, so they are fetched once,
+// when the subusers are listed, for their profiles and credit allocations.
+type subuserCreditsCache struct {
+	client SendGridClient
+
+	mtx        sync.Mutex
+	byUsername map[string]*models.SubuserCredits
+}
+
+func newSubuserCreditsCache(gridClient SendGridClient) *subuserCreditsCache {
+	return &subuserCreditsCache{
+		client:     gridClient,
+		byUsername: make(map[string]*models.SubuserCredits),
+	}
+}
+
+// GetCreditsForSubuser returns the credit allocation of the subuser,
+// fetching it the first time it is needed.
+func (c *subuserCreditsCache) GetCreditsForSubuser(ctx context.Context, username string) (*models.SubuserCredits, error) {
+	c.mtx.Lock()
+	credits, ok := c.byUsername[username]
+	c.mtx.Unlock()
+
+	if ok {
+		return credits, nil
+	}
+
+	credits, err := c.client.GetSubuserCredits(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mtx.Lock()
+	c.byUsername[username] = credits
+	c.mtx.Unlock()
+
+	return credits, nil
+}
+
+// ForgetSubuser drops the credit allocation of the subuser once it changed.
+func (c *subuserCreditsCache) ForgetSubuser(username string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.byUsername, username)
+}
+
+// reset drops every credit allocation, so that they are fetched again.
+func (c *subuserCreditsCache) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.byUsername = make(map[string]*models.SubuserCredits)
+}
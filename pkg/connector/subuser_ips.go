@@ -0,0 +1,187 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+)
+
+// ipChange changes the IP addresses assigned to a subuser, returning them
+// and whether it changed anything.
+type ipChange func(ips []string) ([]string, bool)
+
+// subuserIPWriter changes the IP addresses assigned to the subusers of an
+// account. SendGrid only replaces the whole list of IP addresses of a
+// subuser, so changes to the same subuser are serialized the way the scopes
+// of a teammate are: the changes queued while an update is in flight are
+// applied together by the next one, with a single read and write of the list.
+type subuserIPWriter struct {
+	client      SendGridClient
+	ipAddresses *ipAddressCache
+
+	mtx    sync.Mutex
+	queues map[string]*ipChangeQueue
+}
+
+type ipChangeQueue struct {
+	pending []*queuedIPChange
+}
+
+type queuedIPChange struct {
+	// ctx is the context of the caller, whose change is dropped if it gave
+	// up before the change was applied.
+	ctx     context.Context
+	change  ipChange
+	changed bool
+	err     error
+	done    chan struct{}
+}
+
+func newSubuserIPWriter(gridClient SendGridClient, ipAddresses *ipAddressCache) *subuserIPWriter {
+	return &subuserIPWriter{
+		client:      gridClient,
+		ipAddresses: ipAddresses,
+		queues:      make(map[string]*ipChangeQueue),
+	}
+}
+
+// apply applies the change to the IP addresses of the subuser, reporting
+// whether it changed anything. It returns when ctx is done without waiting
+// for the change, which is still applied if its update was already sent.
+func (w *subuserIPWriter) apply(ctx context.Context, username string, change ipChange) (bool, error) {
+	q := &queuedIPChange{ctx: ctx, change: change, done: make(chan struct{})}
+
+	w.mtx.Lock()
+	queue, running := w.queues[username]
+	if !running {
+		queue = &ipChangeQueue{}
+		w.queues[username] = queue
+	}
+	queue.pending = append(queue.pending, q)
+	w.mtx.Unlock()
+
+	if !running {
+		go w.drain(context.WithoutCancel(ctx), username, queue)
+	}
+
+	select {
+	case <-q.done:
+		return q.changed, q.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (w *subuserIPWriter) drain(ctx context.Context, username string, queue *ipChangeQueue) {
+	for {
+		w.mtx.Lock()
+		batch := queue.pending
+		queue.pending = nil
+		if len(batch) == 0 {
+			delete(w.queues, username)
+			w.mtx.Unlock()
+
+			return
+		}
+		w.mtx.Unlock()
+
+		w.applyBatch(ctx, username, batch)
+
+		for _, q := range batch {
+			close(q.done)
+		}
+	}
+}
+
+func (w *subuserIPWriter) applyBatch(ctx context.Context, username string, batch []*queuedIPChange) {
+	var pending []*queuedIPChange
+	for _, q := range batch {
+		if err := q.ctx.Err(); err != nil {
+			q.err = err
+			continue
+		}
+
+		pending = append(pending, q)
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	ips, err := subuserIPs(ctx, w.client, username)
+	if err != nil {
+		for _, q := range pending {
+			q.err = err
+		}
+
+		return
+	}
+
+	var changed []*queuedIPChange
+	for _, q := range pending {
+		ips, q.changed = q.change(ips)
+		if q.changed {
+			changed = append(changed, q)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	if len(changed) > 1 {
+		ctxzap.Extract(ctx).Info(
+			"baton-sendgrid: coalesced ip address changes of subuser",
+			zap.String("subuser", username),
+			zap.Int("changes", len(changed)),
+		)
+	}
+
+	err = w.client.SetSubuserIPs(ctx, username, ips)
+	w.ipAddresses.reset()
+	if err != nil {
+		for _, q := range changed {
+			q.changed, q.err = false, err
+		}
+	}
+}
+
+// subuserIPs returns the IP addresses currently assigned to the subuser. They
+// are read past the response cache, which would miss the assignments changed
+// since.
+func subuserIPs(ctx context.Context, gridClient SendGridClient, username string) ([]string, error) {
+	ctx = client.WithoutCache(ctx)
+	rv := make([]string, 0)
+
+	pToken := "0"
+	for pToken != "" {
+		var (
+			ips []models.IPAddress
+			err error
+		)
+
+		ips, pToken, err = gridClient.GetIPs(ctx, &pagination.Token{Token: pToken})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ips) == 0 {
+			break
+		}
+
+		for _, ip := range ips {
+			if slices.Contains(ip.Subusers, username) {
+				rv = append(rv, ip.Ip)
+			}
+		}
+	}
+
+	return rv, nil
+}
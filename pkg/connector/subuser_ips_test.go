@@ -0,0 +1,134 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedIPClient holds the first SetSubuserIPs call until the gate is opened,
+// so that changes queue up behind it.
+type gatedIPClient struct {
+	*memoryClient
+
+	once    sync.Once
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedIPClient) SetSubuserIPs(ctx context.Context, username string, ips []string) error {
+	g.once.Do(func() {
+		close(g.entered)
+		<-g.gate
+	})
+
+	return g.memoryClient.SetSubuserIPs(ctx, username, ips)
+}
+
+func assignIP(ip string) ipChange {
+	return func(ips []string) ([]string, bool) {
+		if slices.Contains(ips, ip) {
+			return ips, false
+		}
+
+		return append(ips, ip), true
+	}
+}
+
+func TestSubuserIPWriter(t *testing.T) {
+	ctx := context.Background()
+
+	var ips []string
+	for i := 1; i <= 4; i++ {
+		ips = append(ips, fmt.Sprintf("1.1.1.%d", i))
+	}
+
+	m := newMemoryClient(1, "owner").addSubuser(10, "sub")
+	for _, ip := range ips {
+		m.addIP(ip, false)
+	}
+
+	c := &gatedIPClient{memoryClient: m, entered: make(chan struct{}), gate: make(chan struct{})}
+	w := newSubuserIPWriter(c, newIPAddressCache(c))
+
+	var wg sync.WaitGroup
+	apply := func(change ipChange) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := w.apply(ctx, "sub", change)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	apply(assignIP(ips[0]))
+	<-c.entered
+
+	for _, ip := range ips[1:] {
+		apply(assignIP(ip))
+	}
+
+	// Wait for the changes to queue up behind the first one.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mtx.Lock()
+		queued := len(w.queues["sub"].pending)
+		w.mtx.Unlock()
+
+		if queued == len(ips)-1 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d queued changes, want %d", queued, len(ips)-1)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	close(c.gate)
+	wg.Wait()
+
+	for _, ip := range ips {
+		if assigned := m.subusersOf(ip); !slices.Contains(assigned, "sub") {
+			t.Errorf("expected %s to be assigned to sub, got %v", ip, assigned)
+		}
+	}
+
+	if n := m.count("SetSubuserIPs sub"); n != 2 {
+		t.Errorf("got %d SetSubuserIPs calls, want 2", n)
+	}
+}
+
+func TestSyncIPAddressesOfUnknownSubuser(t *testing.T) {
+	c := newMemoryClient(1, "owner").
+		addSubuser(10, "sub").
+		addIP("1.1.1.1", true, "sub", "ghost").
+		addIP("2.2.2.2", true, "ghost").
+		addIP("3.3.3.3", true)
+	cs := newTestConnector(t, false, c)
+
+	result := fullSync(t, cs)
+
+	want := "ip_address:1:1.1.1.1:assigned -> subuser:1:10"
+	if !slices.Contains(result.grants, want) {
+		t.Errorf("expected %s, got %v", want, result.grants)
+	}
+
+	for _, grant := range result.grants {
+		if strings.HasPrefix(grant, "ip_address:") && grant != want {
+			t.Errorf("unexpected grant %s", grant)
+		}
+	}
+
+	// The IP addresses span several pages, the assigned ones are read once.
+	if n := c.count("GetAssignedIPs"); n != 1 {
+		t.Errorf("got %d GetAssignedIPs calls, want 1", n)
+	}
+}
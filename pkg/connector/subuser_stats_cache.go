@@ -0,0 +1,112 @@
+package connector
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+)
+
+// subuserStatsCache holds the sending stats of the subusers of an account over
+// the last complete month, which SendGrid returns for every subuser at once.
+type subuserStatsCache struct {
+	client SendGridClient
+
+	mtx        sync.Mutex
+	built      bool
+	month      string
+	byUsername map[string]models.StatsMetrics
+	// err is the error the stats failed to be collected with, kept for the
+	// rest of the sync rather than collecting them again for every subuser.
+	err error
+}
+
+func newSubuserStatsCache(gridClient SendGridClient) *subuserStatsCache {
+	return &subuserStatsCache{
+		client: gridClient,
+	}
+}
+
+// lastMonth returns the first day of the month before the one of now.
+func lastMonth(now time.Time) string {
+	return time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC).Format(time.DateOnly)
+}
+
+func (c *subuserStatsCache) buildCache(ctx context.Context) error {
+	l := ctxzap.Extract(ctx)
+
+	l.Info("Building cache for subuser stats")
+
+	month := lastMonth(time.Now().UTC())
+	byUsername := make(map[string]models.StatsMetrics)
+
+	pToken := "0"
+	for pToken != "" {
+		var (
+			stats []models.SubuserStats
+			err   error
+		)
+
+		stats, pToken, err = c.client.GetSubuserMonthlyStats(ctx, month, &pagination.Token{Token: pToken})
+		if err != nil {
+			c.err = err
+			c.built = true
+
+			return err
+		}
+
+		if len(stats) == 0 {
+			break
+		}
+
+		for _, s := range stats {
+			byUsername[s.Name] = s.Metrics
+		}
+	}
+
+	c.month = month
+	c.byUsername = byUsername
+	c.built = true
+
+	l.Info("Cache built for subuser stats")
+
+	return nil
+}
+
+// GetStatsForSubuser returns the month the stats are for and the stats of
+// the subuser, which are all zero when it sent nothing that month.
+func (c *subuserStatsCache) GetStatsForSubuser(ctx context.Context, username string) (string, *models.StatsMetrics, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.built {
+		err := c.buildCache(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+	}
+
+	if c.err != nil {
+		return "", nil, c.err
+	}
+
+	stats := c.byUsername[username]
+
+	return c.month, &stats, nil
+}
+
+// reset drops the collected stats, so that they are collected again on their
+// next use.
+func (c *subuserStatsCache) reset() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.built = false
+	c.month = ""
+	c.byUsername = nil
+	c.err = nil
+}
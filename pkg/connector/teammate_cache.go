@@ -0,0 +1,189 @@
+package connector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+)
+
+// TeammateCache keeps the scopes of the teammates on disk between syncs.
+// SendGrid only returns the scopes of one teammate at a time, so a sync of a
+// large account makes a request per teammate; the cache skips the ones whose
+// entry in the teammate list did not change since their scopes were fetched,
+// as long as the scopes are younger than the TTL. It is safe to share
+// between the accounts of a connector.
+type TeammateCache struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time
+
+	mtx sync.Mutex
+	// accounts holds the cached teammates by account ID then username.
+	accounts map[string]map[string]teammateCacheEntry
+}
+
+type teammateCacheEntry struct {
+	// Fingerprint is the hash of the teammate list entry the scopes were
+	// fetched for.
+	Fingerprint string    `json:"fingerprint"`
+	Scopes      []string  `json:"scopes"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// OpenTeammateCache loads the teammate cache file at path, starting empty if
+// it does not exist yet. The file is written back after every scope sync.
+func OpenTeammateCache(path string, ttl time.Duration) (*TeammateCache, error) {
+	c := &TeammateCache{
+		path:     path,
+		ttl:      ttl,
+		now:      time.Now,
+		accounts: make(map[string]map[string]teammateCacheEntry),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &c.accounts)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// scopes returns the cached scopes of the teammate if its list entry did not
+// change and they did not expire. A nil cache never has any.
+func (c *TeammateCache) scopes(accountID string, teammate models.Teammate) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	fingerprint, err := teammateFingerprint(teammate)
+	if err != nil {
+		return nil, false
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.accounts[accountID][teammate.Username]
+	if !ok || entry.Fingerprint != fingerprint || c.now().Sub(entry.FetchedAt) >= c.ttl {
+		return nil, false
+	}
+
+	return slices.Clone(entry.Scopes), true
+}
+
+// store records the scopes just fetched for the teammate.
+func (c *TeammateCache) store(accountID string, teammate models.Teammate, scopes []string) {
+	if c == nil {
+		return
+	}
+
+	fingerprint, err := teammateFingerprint(teammate)
+	if err != nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.accounts[accountID] == nil {
+		c.accounts[accountID] = make(map[string]teammateCacheEntry)
+	}
+
+	c.accounts[accountID][teammate.Username] = teammateCacheEntry{
+		Fingerprint: fingerprint,
+		Scopes:      slices.Clone(scopes),
+		FetchedAt:   c.now(),
+	}
+}
+
+// forget drops the teammate, whose scopes changed without its list entry
+// necessarily changing.
+func (c *TeammateCache) forget(accountID string, username string) {
+	if c == nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.accounts[accountID], username)
+}
+
+// retain drops the teammates of the account which are no longer listed.
+func (c *TeammateCache) retain(accountID string, usernames map[string]struct{}) {
+	if c == nil {
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for username := range c.accounts[accountID] {
+		if _, ok := usernames[username]; !ok {
+			delete(c.accounts[accountID], username)
+		}
+	}
+}
+
+// save writes the cache back to its file, replacing it at once so an
+// interrupted write never leaves a truncated cache behind.
+func (c *TeammateCache) save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mtx.Lock()
+	data, err := json.Marshal(c.accounts)
+	c.mtx.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), c.path)
+}
+
+// teammateFingerprint hashes the teammate list entry, any change to it
+// invalidating the cached scopes.
+func teammateFingerprint(teammate models.Teammate) (string, error) {
+	data, err := json.Marshal(teammate)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,119 @@
+package connector
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"github.com/conductorone/baton-sdk/pkg/types"
+)
+
+func TestTeammateCache(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "teammates.json")
+	now := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	c := newMemoryClient(1, "owner").
+		addTeammate("", "owner", ownerUserType).
+		addTeammate("", "alice", "teammate", "alerts.read").
+		addTeammate("", "bob", "teammate", "alerts.read", "alerts.create")
+
+	// Every sync runs in a new connector over the reopened cache file, the
+	// way consecutive syncs run in separate processes.
+	newConnector := func() types.ConnectorServer {
+		cache, err := OpenTeammateCache(path, time.Hour)
+		if err != nil {
+			t.Fatalf("OpenTeammateCache: %v", err)
+		}
+		cache.now = func() time.Time { return now }
+
+		cb, err := New(ctx, []SendGridClient{c}, false, WithTeammateCache(cache))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+
+		cs, err := connectorbuilder.NewConnector(ctx, cb)
+		if err != nil {
+			t.Fatalf("NewConnector: %v", err)
+		}
+
+		return cs
+	}
+
+	syncFetching := func(want ...string) *syncResult {
+		t.Helper()
+
+		before := len(c.calls)
+		result := fullSync(t, newConnector())
+
+		var fetched []string
+		for _, call := range c.calls[before:] {
+			if username, ok := strings.CutPrefix(call, "GetSpecificTeammate "); ok {
+				fetched = append(fetched, username)
+			}
+		}
+
+		slices.Sort(fetched)
+		if !slices.Equal(fetched, want) {
+			t.Errorf("expected the scopes of %v to be fetched, got %v", want, fetched)
+		}
+
+		return result
+	}
+
+	first := syncFetching("alice", "bob", "owner")
+
+	second := syncFetching()
+	if !slices.Equal(first.grants, second.grants) {
+		t.Errorf("expected the cached scopes to sync the same grants, got %v and %v", first.grants, second.grants)
+	}
+
+	// A change to the list entry of a teammate invalidates its scopes.
+	c.mtx.Lock()
+	c.findTeammate("", "bob").Email = "robert@example.com"
+	c.mtx.Unlock()
+	syncFetching("bob")
+
+	// So does granting a scope through the connector, which does not change
+	// the list entry.
+	_, err := newConnector().Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+		Entitlement: entitlementOf(scopeResourceType, "1:alerts.create", assignedEntitlement),
+		Principal:   teammateID("1", "alice"),
+	})
+	if err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+
+	result := syncFetching("alice")
+	if !slices.Contains(result.grants, "scope:1:alerts.create:assigned -> teammate:1:alice") {
+		t.Errorf("expected the granted scope to be synced, got %v", result.grants)
+	}
+
+	// Scopes older than the TTL are fetched again.
+	now = now.Add(2 * time.Hour)
+	syncFetching("alice", "bob", "owner")
+
+	// Deleted teammates are dropped from the cache.
+	c.mtx.Lock()
+	c.teammates[""] = slices.DeleteFunc(c.teammates[""], func(teammate *models.TeammateScope) bool {
+		return teammate.Username == "bob"
+	})
+	c.mtx.Unlock()
+	syncFetching()
+
+	cache, err := OpenTeammateCache(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenTeammateCache: %v", err)
+	}
+
+	if _, ok := cache.accounts["1"]["bob"]; ok {
+		t.Error("expected the deleted teammate to be dropped from the cache")
+	}
+}
@@ -0,0 +1,206 @@
+package connector
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// scopeChange changes the scopes of a teammate in place, reporting whether
+// it changed anything. It must leave the teammate untouched when it fails.
+type scopeChange func(ctx context.Context, teammate *models.TeammateScope) (bool, error)
+
+// teammateScopeWriter changes the scopes of the teammates of an account.
+// SendGrid only replaces the whole scope list of a teammate, so changes to
+// the same teammate are serialized: the changes queued while an update is
+// in flight are applied together by the next one, with a single read and
+// write of the scope list, and the scopes are read back to verify SendGrid
+// applied them.
+type teammateScopeWriter struct {
+	client     SendGridClient
+	scopeCache *scopeCache
+	// dryRun skips the verification of the writes, which the client does
+	// not send.
+	dryRun bool
+
+	mtx    sync.Mutex
+	queues map[string]*scopeChangeQueue
+}
+
+type scopeChangeQueue struct {
+	pending []*queuedScopeChange
+}
+
+type queuedScopeChange struct {
+	// ctx is the context of the caller, whose change is dropped if it gave
+	// up before the change was applied.
+	ctx     context.Context
+	change  scopeChange
+	changed bool
+	err     error
+	done    chan struct{}
+}
+
+func newTeammateScopeWriter(gridClient SendGridClient, scopeCache *scopeCache, dryRun bool) *teammateScopeWriter {
+	return &teammateScopeWriter{
+		client:     gridClient,
+		scopeCache: scopeCache,
+		dryRun:     dryRun,
+		queues:     make(map[string]*scopeChangeQueue),
+	}
+}
+
+// apply applies the change to the scopes of the teammate, reporting whether
+// it changed anything. It returns when ctx is done without waiting for the
+// change, which is still applied if its update was already sent.
+func (w *teammateScopeWriter) apply(ctx context.Context, username string, change scopeChange) (bool, error) {
+	q := &queuedScopeChange{ctx: ctx, change: change, done: make(chan struct{})}
+
+	w.mtx.Lock()
+	queue, running := w.queues[username]
+	if !running {
+		queue = &scopeChangeQueue{}
+		w.queues[username] = queue
+	}
+	queue.pending = append(queue.pending, q)
+	w.mtx.Unlock()
+
+	// The first change to a teammate starts applying the changes queued
+	// behind it until there are none left, under a context none of their
+	// callers giving up cancels.
+	if !running {
+		go w.drain(context.WithoutCancel(ctx), username, queue)
+	}
+
+	select {
+	case <-q.done:
+		return q.changed, q.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+func (w *teammateScopeWriter) drain(ctx context.Context, username string, queue *scopeChangeQueue) {
+	for {
+		w.mtx.Lock()
+		batch := queue.pending
+		queue.pending = nil
+		if len(batch) == 0 {
+			delete(w.queues, username)
+			w.mtx.Unlock()
+
+			return
+		}
+		w.mtx.Unlock()
+
+		w.applyBatch(ctx, username, batch)
+
+		for _, q := range batch {
+			close(q.done)
+		}
+	}
+}
+
+func (w *teammateScopeWriter) applyBatch(ctx context.Context, username string, batch []*queuedScopeChange) {
+	// The scopes are written from and checked against what SendGrid holds,
+	// never against a cached read which predates the last write.
+	ctx = client.WithoutCache(ctx)
+
+	var pending []*queuedScopeChange
+	for _, q := range batch {
+		if err := q.ctx.Err(); err != nil {
+			q.err = err
+			continue
+		}
+
+		pending = append(pending, q)
+	}
+
+	if len(pending) == 0 {
+		return
+	}
+
+	teammate, err := w.client.GetSpecificTeammate(ctx, username)
+	if err != nil {
+		for _, q := range pending {
+			q.err = err
+		}
+
+		return
+	}
+
+	before := slices.Clone(teammate.Scopes)
+
+	var changed []*queuedScopeChange
+	for _, q := range pending {
+		q.changed, q.err = q.change(ctx, teammate)
+		if q.err == nil && q.changed {
+			changed = append(changed, q)
+		}
+	}
+
+	if len(changed) == 0 {
+		return
+	}
+
+	if len(changed) > 1 {
+		ctxzap.Extract(ctx).Info(
+			"baton-sendgrid: coalesced scope changes of teammate",
+			zap.String("teammate", username),
+			zap.Int("changes", len(changed)),
+		)
+	}
+
+	err = w.client.SetTeammateScopes(ctx, username, teammate.Scopes, teammate.IsAdmin)
+	w.scopeCache.forgetTeammate(ctx, username)
+	if err == nil && !w.dryRun {
+		err = w.verify(ctx, teammate, before)
+	}
+
+	if err != nil {
+		for _, q := range changed {
+			q.changed, q.err = false, err
+		}
+	}
+}
+
+// verify reads the scopes of the teammate back, checking it holds the scopes
+// written and none of the scopes taken away.
+func (w *teammateScopeWriter) verify(ctx context.Context, written *models.TeammateScope, before []string) error {
+	teammate, err := w.client.GetSpecificTeammate(ctx, written.Username)
+	if err != nil {
+		return err
+	}
+
+	var missing, kept []string
+	for _, scope := range written.Scopes {
+		if !slices.Contains(teammate.Scopes, scope) {
+			missing = append(missing, scope)
+		}
+	}
+
+	for _, scope := range before {
+		if !slices.Contains(written.Scopes, scope) && slices.Contains(teammate.Scopes, scope) {
+			kept = append(kept, scope)
+		}
+	}
+
+	if len(missing) > 0 || len(kept) > 0 || teammate.IsAdmin != written.IsAdmin {
+		return status.Errorf(
+			codes.Aborted,
+			"baton-sendgrid: the scopes of %s did not change as requested, missing %v and still holding %v",
+			written.Username,
+			missing,
+			kept,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,396 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client/sendgridtest"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/connectorbuilder"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gatedClient holds the first SetTeammateScopes call until the gate is
+// opened, so that changes queue up behind it, and fails the calls made under
+// a cancelled context like the real client.
+type gatedClient struct {
+	*memoryClient
+
+	once    sync.Once
+	entered chan struct{}
+	gate    chan struct{}
+}
+
+func (g *gatedClient) SetTeammateScopes(ctx context.Context, username string, scopes []string, isAdmin bool) error {
+	g.once.Do(func() {
+		close(g.entered)
+		<-g.gate
+	})
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return g.memoryClient.SetTeammateScopes(ctx, username, scopes, isAdmin)
+}
+
+// lossyClient drops the last scope of every SetTeammateScopes call, the way
+// SendGrid silently ignores the scopes it does not accept.
+type lossyClient struct {
+	*memoryClient
+}
+
+func (l *lossyClient) SetTeammateScopes(ctx context.Context, username string, scopes []string, isAdmin bool) error {
+	if len(scopes) > 0 {
+		scopes = scopes[:len(scopes)-1]
+	}
+
+	return l.memoryClient.SetTeammateScopes(ctx, username, scopes, isAdmin)
+}
+
+func addScope(scope string) scopeChange {
+	return func(ctx context.Context, teammate *models.TeammateScope) (bool, error) {
+		if slices.Contains(teammate.Scopes, scope) {
+			return false, nil
+		}
+
+		teammate.Scopes = append(teammate.Scopes, scope)
+
+		return true, nil
+	}
+}
+
+func TestTeammateScopeWriter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("concurrent changes are all applied", func(t *testing.T) {
+		c := newMemoryClient(1, "owner").addTeammate("", "alice", "teammate")
+		w := newTeammateScopeWriter(c, newScopeCache(c, "", nil), false)
+
+		var scopes []string
+		for i := 0; i < 20; i++ {
+			scopes = append(scopes, fmt.Sprintf("scope.%d", i))
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, len(scopes))
+		for _, scope := range scopes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				changed, err := w.apply(ctx, "alice", addScope(scope))
+				if err == nil && !changed {
+					err = fmt.Errorf("adding %s changed nothing", scope)
+				}
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got := c.scopesOf("alice")
+		for _, scope := range scopes {
+			if !slices.Contains(got, scope) {
+				t.Errorf("alice is missing %s, holds %v", scope, got)
+			}
+		}
+
+		if n := c.count("SetTeammateScopes alice"); n > len(scopes) {
+			t.Errorf("got %d SetTeammateScopes calls for %d changes", n, len(scopes))
+		}
+	})
+
+	t.Run("queued changes are coalesced", func(t *testing.T) {
+		c := &gatedClient{
+			memoryClient: newMemoryClient(1, "owner").addTeammate("", "alice", "teammate"),
+			entered:      make(chan struct{}),
+			gate:         make(chan struct{}),
+		}
+		w := newTeammateScopeWriter(c, newScopeCache(c, "", nil), false)
+
+		var wg sync.WaitGroup
+		apply := func(change scopeChange) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				_, err := w.apply(ctx, "alice", change)
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+
+		apply(addScope("alerts.read"))
+		<-c.entered
+
+		apply(addScope("alerts.create"))
+		apply(addScope("alerts.update"))
+		// Already held once the first change lands, so it is a no-op.
+		apply(addScope("alerts.read"))
+
+		// Wait for the changes to queue up behind the first one.
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			w.mtx.Lock()
+			queued := len(w.queues["alice"].pending)
+			w.mtx.Unlock()
+
+			if queued == 3 {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("got %d queued changes, want 3", queued)
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		close(c.gate)
+		wg.Wait()
+
+		// The queued changes race to the queue, so their order is not set.
+		want := []string{"alerts.create", "alerts.read", "alerts.update"}
+		got := slices.Clone(c.scopesOf("alice"))
+		slices.Sort(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("got scopes %v, want %v", got, want)
+		}
+
+		if n := c.count("SetTeammateScopes alice"); n != 2 {
+			t.Errorf("got %d SetTeammateScopes calls, want 2", n)
+		}
+
+		waitDrained(t, w, "alice")
+	})
+
+	t.Run("a caller giving up does not fail the changes queued behind it", func(t *testing.T) {
+		c := &gatedClient{
+			memoryClient: newMemoryClient(1, "owner").addTeammate("", "alice", "teammate"),
+			entered:      make(chan struct{}),
+			gate:         make(chan struct{}),
+		}
+		w := newTeammateScopeWriter(c, newScopeCache(c, "", nil), false)
+
+		firstCtx, cancel := context.WithCancel(ctx)
+		first := make(chan error, 1)
+		go func() {
+			_, err := w.apply(firstCtx, "alice", addScope("alerts.read"))
+			first <- err
+		}()
+		<-c.entered
+
+		second := make(chan error, 1)
+		go func() {
+			_, err := w.apply(ctx, "alice", addScope("alerts.create"))
+			second <- err
+		}()
+
+		// The first caller stops waiting while its update is in flight.
+		cancel()
+		if err := <-first; !errors.Is(err, context.Canceled) {
+			t.Fatalf("got error %v for the cancelled caller, want context.Canceled", err)
+		}
+
+		close(c.gate)
+		if err := <-second; err != nil {
+			t.Fatalf("got error %v for the queued change", err)
+		}
+
+		want := []string{"alerts.read", "alerts.create"}
+		if got := c.scopesOf("alice"); !slices.Equal(got, want) {
+			t.Errorf("got scopes %v, want %v", got, want)
+		}
+
+		waitDrained(t, w, "alice")
+	})
+
+	t.Run("changes given up before they are applied are dropped", func(t *testing.T) {
+		c := newMemoryClient(1, "owner").addTeammate("", "alice", "teammate")
+		w := newTeammateScopeWriter(c, newScopeCache(c, "", nil), false)
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		_, err := w.apply(cancelled, "alice", addScope("alerts.read"))
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got error %v, want context.Canceled", err)
+		}
+
+		waitDrained(t, w, "alice")
+
+		if c.called("SetTeammateScopes") {
+			t.Errorf("expected no write for a change given up, got calls %v", c.calls)
+		}
+	})
+
+	t.Run("scopes SendGrid did not apply are reported", func(t *testing.T) {
+		c := &lossyClient{newMemoryClient(1, "owner").addTeammate("", "alice", "teammate", "alerts.read")}
+		w := newTeammateScopeWriter(c, newScopeCache(c, "", nil), false)
+
+		changed, err := w.apply(ctx, "alice", addScope("alerts.create"))
+		if status.Code(err) != codes.Aborted {
+			t.Fatalf("got error %v, want Aborted", err)
+		}
+
+		if changed {
+			t.Error("a failed change was reported as changed")
+		}
+	})
+}
+
+// newCachedClient returns a client of the fake server going through the
+// response cache, as the connector does outside of tests.
+func newCachedClient(t *testing.T, server *sendgridtest.Server) *client.SendGridClient {
+	t.Helper()
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "false")
+
+	c, err := client.NewClient(context.Background(), server.URL, server.ApiKey)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	return c
+}
+
+func TestTeammateScopesWithCachedClient(t *testing.T) {
+	ctx := context.Background()
+
+	server := sendgridtest.NewServer("owner")
+	t.Cleanup(server.Close)
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "owner", UserType: ownerUserType, IsAdmin: true},
+	})
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "alice", UserType: "teammate"},
+		Scopes:   []string{"alerts.read"},
+	})
+
+	c := newCachedClient(t, server)
+	cs := newTestConnector(t, false, c)
+
+	// A sync has read the teammate, leaving its scopes in the cache.
+	_, err := c.GetSpecificTeammate(ctx, "alice")
+	if err != nil {
+		t.Fatalf("GetSpecificTeammate: %v", err)
+	}
+
+	alice := teammateID("1000", "alice")
+	for _, scope := range []string{"alerts.create", "alerts.update"} {
+		_, err := cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+			Entitlement: entitlementOf(scopeResourceType, "1000:"+scope, assignedEntitlement),
+			Principal:   alice,
+		})
+		if err != nil {
+			t.Fatalf("Grant %s: %v", scope, err)
+		}
+	}
+
+	_, err = cs.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{
+		Grant: &v2.Grant{
+			Entitlement: entitlementOf(scopeResourceType, "1000:alerts.create", assignedEntitlement),
+			Principal:   alice,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	teammate, _ := server.Teammate("alice")
+	if want := []string{"alerts.read", "alerts.update"}; !slices.Equal(teammate.Scopes, want) {
+		t.Errorf("got scopes %v, want %v", teammate.Scopes, want)
+	}
+}
+
+func TestTeammateScopesDryRun(t *testing.T) {
+	ctx := context.Background()
+
+	server := sendgridtest.NewServer("owner")
+	t.Cleanup(server.Close)
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "owner", UserType: ownerUserType, IsAdmin: true},
+	})
+	server.AddTeammate("", models.TeammateScope{
+		Teammate: models.Teammate{Username: "alice", UserType: "teammate"},
+		Scopes:   []string{"alerts.read"},
+	})
+
+	t.Setenv("BATON_DISABLE_HTTP_CACHE", "true")
+	c, err := client.NewClient(ctx, server.URL, server.ApiKey, client.WithDryRun(true))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	cb, err := New(ctx, []SendGridClient{c}, false, WithDryRun(true))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	cs, err := connectorbuilder.NewConnector(ctx, cb)
+	if err != nil {
+		t.Fatalf("NewConnector: %v", err)
+	}
+
+	alice := teammateID("1000", "alice")
+
+	_, err = cs.Grant(ctx, &v2.GrantManagerServiceGrantRequest{
+		Entitlement: entitlementOf(scopeResourceType, "1000:alerts.create", assignedEntitlement),
+		Principal:   alice,
+	})
+	if err != nil {
+		t.Fatalf("Grant: %v", err)
+	}
+
+	_, err = cs.Revoke(ctx, &v2.GrantManagerServiceRevokeRequest{
+		Grant: &v2.Grant{
+			Entitlement: entitlementOf(scopeResourceType, "1000:alerts.read", assignedEntitlement),
+			Principal:   alice,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+
+	teammate, _ := server.Teammate("alice")
+	if want := []string{"alerts.read"}; !slices.Equal(teammate.Scopes, want) {
+		t.Errorf("expected the dry run to leave the scopes %v, got %v", want, teammate.Scopes)
+	}
+}
+
+// waitDrained waits for the writer to be done with the changes to the
+// teammate, which it finishes after their callers returned.
+func waitDrained(t *testing.T, w *teammateScopeWriter, username string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.mtx.Lock()
+		_, running := w.queues[username]
+		w.mtx.Unlock()
+
+		if !running {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("the changes to %s were not drained", username)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -4,24 +4,22 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/conductorone/baton-sendgrid/pkg/connector/models"
+	"github.com/conductorone/baton-sendgrid/pkg/connector/client"
 
 	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
 	"github.com/conductorone/baton-sdk/pkg/annotations"
 	"github.com/conductorone/baton-sdk/pkg/pagination"
-	ent "github.com/conductorone/baton-sdk/pkg/types/entitlement"
-	"github.com/conductorone/baton-sdk/pkg/types/grant"
-	rs "github.com/conductorone/baton-sdk/pkg/types/resource"
-	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap/ctxzap"
-	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const (
-	accessEntitlement = "access"
+	// ownerUserType is the user_type of the teammate owning the account.
+	ownerUserType = "owner"
 )
 
 type teammateBuilder struct {
-	client SendGridClient
+	accounts *accountSet
 }
 
 func (u *teammateBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
@@ -29,14 +27,41 @@ func (u *teammateBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
 }
 
 func (u *teammateBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
-	teammates, pNextToken, err := u.client.GetTeammates(ctx, pToken)
+	if parentResourceID == nil {
+		return nil, "", nil, nil
+	}
+
+	acc, err := u.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	// The teammates of the account are remembered for the grants of the
+	// subuser access, which go to existing teammates only.
+	listingAccount := parentResourceID.ResourceType == accountResourceType.Id
+	if listingAccount && (pToken == nil || pToken.Token == "") {
+		acc.forgetTeammates()
+	}
+
+	if parentResourceID.ResourceType == subuserResourceType.Id {
+		ctx, err = acc.onBehalfOfSubuser(ctx, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+	}
+
+	teammates, pNextToken, err := acc.client.GetTeammates(ctx, pToken)
 	if err != nil {
 		return nil, "", nil, err
 	}
 
 	rv := make([]*v2.Resource, len(teammates))
 	for i, teammate := range teammates {
-		us, err := teammateResource(ctx, &teammate, nil)
+		if listingAccount {
+			acc.rememberTeammate(teammate.Username)
+		}
+
+		us, err := teammateResource(ctx, &teammate, parentResourceID)
 		if err != nil {
 			return nil, "", nil, err
 		}
@@ -52,57 +77,62 @@ func (u *teammateBuilder) List(ctx context.Context, parentResourceID *v2.Resourc
 }
 
 func (u *teammateBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
-	var rv []*v2.Entitlement
-	assigmentOptions := []ent.EntitlementOption{
-		ent.WithGrantableTo(subuserResourceType),
-		ent.WithDescription(fmt.Sprintf("Teammate acess to %s", subuserResourceType.DisplayName)),
-		ent.WithDisplayName(fmt.Sprintf("%s can access %s", resource.DisplayName, subuserResourceType.DisplayName)),
-	}
-	rv = append(rv, ent.NewAssignmentEntitlement(resource, accessEntitlement, assigmentOptions...))
-
-	return rv, "", nil, nil
+	return nil, "", nil, nil
 }
 
+// Grants is empty, the access of teammates to subusers is modeled on the
+// subusers.
 func (u *teammateBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
-	var rv []*v2.Grant
+	return nil, "", nil, nil
+}
 
-	username := resource.Id.Resource
+// ResourceManager
 
-	access, nextToken, err := u.client.GetTeammatesSubAccess(ctx, username, pToken)
+// Create is not supported, a teammate joins by accepting an invite, which is
+// created as a pending invite.
+func (u *teammateBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	return nil, nil, status.Errorf(codes.Unimplemented, "baton-sendgrid: creating a %s is not supported, invite it instead", teammateResourceType.DisplayName)
+}
+
+// Delete removes a teammate of an account, unless it is the account owner or
+// its last admin.
+func (u *teammateBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	acc, err := u.accounts.forResource(ctx, resourceId)
 	if err != nil {
-		return nil, "", nil, err
+		return nil, err
 	}
 
-	logger := ctxzap.Extract(ctx)
-	logger.Info("Teammate grants", zap.String("username", username), zap.Any("COUNT", access))
-
-	for _, subAcess := range access {
-		grants, err := createGrantSubuserFromTeammate(ctx, resource, &subAcess)
-		if err != nil {
-			return nil, "", nil, err
-		}
-
-		rv = append(rv, grants...)
+	_, username, err := splitAccountScopedID(resourceId.Resource)
+	if err != nil {
+		return nil, err
 	}
 
-	return rv, nextToken, nil, nil
-}
+	if _, _, ok := splitSubuserScopedID(username); ok {
+		return nil, fmt.Errorf("baton-sendgrid: teammate %s belongs to a subuser", resourceId.Resource)
+	}
 
-func newTeammateBuilder(client SendGridClient) *teammateBuilder {
-	return &teammateBuilder{
-		client: client,
+	// The guardrails are checked against what SendGrid holds, not against a
+	// cached read which predates the last change.
+	teammate, err := acc.client.GetSpecificTeammate(client.WithoutCache(ctx), username)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func createGrantSubuserFromTeammate(ctx context.Context, resource *v2.Resource, subAcess *models.TeammateSubuser) ([]*v2.Grant, error) {
-	userId, err := rs.NewResourceID(subuserResourceType, subAcess.Id)
+	err = acc.protectPrivileges(ctx, teammate)
 	if err != nil {
 		return nil, err
 	}
 
-	rv := []*v2.Grant{
-		grant.NewGrant(resource, accessEntitlement, userId),
+	err = acc.client.DeleteTeammate(ctx, username)
+	if err != nil {
+		return nil, err
 	}
 
-	return rv, nil
+	return nil, nil
+}
+
+func newTeammateBuilder(accounts *accountSet) *teammateBuilder {
+	return &teammateBuilder{
+		accounts: accounts,
+	}
 }
@@ -0,0 +1,98 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	v2 "github.com/conductorone/baton-sdk/pb/c1/connector/v2"
+	"github.com/conductorone/baton-sdk/pkg/annotations"
+	"github.com/conductorone/baton-sdk/pkg/pagination"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type verifiedSenderBuilder struct {
+	resourceType *v2.ResourceType
+	accounts     *accountSet
+}
+
+func newVerifiedSenderBuilder(accounts *accountSet) *verifiedSenderBuilder {
+	return &verifiedSenderBuilder{
+		resourceType: verifiedSenderResourceType,
+		accounts:     accounts,
+	}
+}
+
+func (r *verifiedSenderBuilder) ResourceType(ctx context.Context) *v2.ResourceType {
+	return verifiedSenderResourceType
+}
+
+func (r *verifiedSenderBuilder) List(ctx context.Context, parentResourceID *v2.ResourceId, pToken *pagination.Token) ([]*v2.Resource, string, annotations.Annotations, error) {
+	var rv []*v2.Resource
+
+	if parentResourceID == nil {
+		return rv, "", nil, nil
+	}
+
+	acc, err := r.accounts.forParent(ctx, parentResourceID)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	senders, pNextToken, err := acc.client.GetVerifiedSenders(ctx, pToken)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	for _, sender := range senders {
+		rb, err := verifiedSenderResource(ctx, sender, parentResourceID)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		rv = append(rv, rb)
+	}
+
+	return rv, pNextToken, nil, nil
+}
+
+func (r *verifiedSenderBuilder) Entitlements(_ context.Context, resource *v2.Resource, _ *pagination.Token) ([]*v2.Entitlement, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+func (r *verifiedSenderBuilder) Grants(ctx context.Context, resource *v2.Resource, pToken *pagination.Token) ([]*v2.Grant, string, annotations.Annotations, error) {
+	return nil, "", nil, nil
+}
+
+// ResourceManager
+
+// Create is not supported, a verified sender is only created by the owner of
+// its address confirming it.
+func (r *verifiedSenderBuilder) Create(ctx context.Context, resource *v2.Resource) (*v2.Resource, annotations.Annotations, error) {
+	return nil, nil, status.Errorf(codes.Unimplemented, "baton-sendgrid: creating a %s is not supported", verifiedSenderResourceType.DisplayName)
+}
+
+func (r *verifiedSenderBuilder) Delete(ctx context.Context, resourceId *v2.ResourceId) (annotations.Annotations, error) {
+	acc, err := r.accounts.forResource(ctx, resourceId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, localID, err := splitAccountScopedID(resourceId.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := strconv.Atoi(localID)
+	if err != nil {
+		return nil, fmt.Errorf("baton-sendgrid: invalid %s id %q", verifiedSenderResourceType.Id, localID)
+	}
+
+	err = acc.client.DeleteVerifiedSender(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}